@@ -0,0 +1,198 @@
+package cloudinit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	addonsv1 "sigs.k8s.io/cluster-api/exp/addons/api/v1beta1"
+
+	"capi-bootstrap/types"
+	capiYaml "capi-bootstrap/yaml"
+)
+
+// addonTemplateValues are the values available when templating an add-on.
+type addonTemplateValues struct {
+	*types.Values
+	Addon types.Addon
+}
+
+// generateAddons renders every add-on into a manifest in the BootstrapManifestDir.
+func generateAddons(ctx context.Context, values *types.Values) ([]capiYaml.InitFile, error) {
+	var addonFiles []capiYaml.InitFile
+	for _, addon := range values.Addons {
+		files, err := generateAddon(ctx, values, addon)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate addon %s: %s", addon.Name, err)
+		}
+		addonFiles = append(addonFiles, files...)
+	}
+	return addonFiles, nil
+}
+
+func generateAddon(ctx context.Context, values *types.Values, addon types.Addon) ([]capiYaml.InitFile, error) {
+	if addon.Name == "" {
+		return nil, errors.New("addon name is empty")
+	}
+	if errs := validation.IsDNS1123Label(addon.Name); len(errs) != 0 {
+		return nil, fmt.Errorf("invalid addon name: %s", strings.Join(errs, ", "))
+	}
+	if addon.Namespace == "" {
+		addon.Namespace = addon.Name
+	}
+	templateValues := addonTemplateValues{Values: values, Addon: addon}
+	filePath := path.Join(values.BootstrapManifestDir, fmt.Sprintf("addon-%s.yaml", addon.Name))
+
+	var addonFile *capiYaml.InitFile
+	var err error
+	switch {
+	case addon.Chart != "" && addon.Manifest != "":
+		return nil, errors.New("only one of chart or manifest can be set")
+	case addon.Chart != "":
+		addonFile, err = generateAddonHelmChart(filePath, templateValues)
+	case addon.Manifest != "":
+		addonFile, err = generateAddonManifest(ctx, filePath, templateValues)
+	default:
+		return nil, errors.New("either a chart or manifest is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	addonFiles := []capiYaml.InitFile{*addonFile}
+	if addon.ClusterResourceSet {
+		crsFile, err := generateAddonClusterResourceSet(values, addon, addonFile.Content)
+		if err != nil {
+			return nil, err
+		}
+		addonFiles = append(addonFiles, *crsFile)
+	}
+	// the manifests are escaped like the other manifests, so cloud-init doesn't render the '{{ }}' of the add-on
+	for i := range addonFiles {
+		addonFiles[i].Content = capiYaml.EscapeJinja(addonFiles[i].Content)
+	}
+	return addonFiles, nil
+}
+
+func generateAddonHelmChart(filePath string, templateValues addonTemplateValues) (*capiYaml.InitFile, error) {
+	helmValues, err := capiYaml.TemplateString(templateValues.Addon.Name+" values", templateValues.Addon.Values, templateValues)
+	if err != nil {
+		return nil, err
+	}
+	var valuesContent []string
+	if strings.TrimSpace(helmValues) != "" {
+		for _, line := range strings.Split(strings.TrimRight(helmValues, "\n"), "\n") {
+			valuesContent = append(valuesContent, "    "+line)
+		}
+	}
+	return capiYaml.ConstructFile(filePath, path.Join("files", "addon-helmchart.yaml"), files, struct {
+		addonTemplateValues
+		ValuesContent string
+	}{
+		templateValues,
+		strings.Join(valuesContent, "\n"),
+	}, false)
+}
+
+func generateAddonManifest(ctx context.Context, filePath string, templateValues addonTemplateValues) (*capiYaml.InitFile, error) {
+	location, err := capiYaml.TemplateString(templateValues.Addon.Name+" manifest location", templateValues.Addon.Manifest, templateValues)
+	if err != nil {
+		return nil, err
+	}
+	rawManifest, err := ReadManifest(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := capiYaml.TemplateString(location, string(rawManifest), templateValues)
+	if err != nil {
+		return nil, err
+	}
+	return &capiYaml.InitFile{
+		Path:    filePath,
+		Content: manifest,
+	}, nil
+}
+
+// ReadManifest reads a manifest from a http(s) URL or from the local filesystem.
+func ReadManifest(ctx context.Context, location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return os.ReadFile(location)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not download manifest %s: %s", location, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not download manifest %s: %s", location, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// generateAddonClusterResourceSet wraps the add-on manifest in a ConfigMap and a ClusterResourceSet that applies it
+// to the workload cluster.
+func generateAddonClusterResourceSet(values *types.Values, addon types.Addon, manifest string) (*capiYaml.InitFile, error) {
+	name := fmt.Sprintf("%s-addon-%s", values.ClusterName, addon.Name)
+	labels := map[string]string{
+		clusterv1.ClusterNameLabel: values.ClusterName,
+	}
+	configMap := corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: values.Namespace,
+			Labels:    labels,
+		},
+		Data: map[string]string{
+			fmt.Sprintf("%s.yaml", addon.Name): manifest,
+		},
+	}
+	clusterResourceSet := addonsv1.ClusterResourceSet{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ClusterResourceSet",
+			APIVersion: addonsv1.GroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: values.Namespace,
+			Labels:    labels,
+		},
+		Spec: addonsv1.ClusterResourceSetSpec{
+			ClusterSelector: metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Resources: []addonsv1.ResourceRef{{
+				Name: name,
+				Kind: string(addonsv1.ConfigMapClusterResourceSetResourceKind),
+			}},
+		},
+	}
+	configMapYaml, err := capiYaml.Marshal(configMap)
+	if err != nil {
+		return nil, err
+	}
+	clusterResourceSetYaml, err := capiYaml.Marshal(clusterResourceSet)
+	if err != nil {
+		return nil, err
+	}
+	return &capiYaml.InitFile{
+		Path:    path.Join(values.BootstrapManifestDir, fmt.Sprintf("addon-%s-crs.yaml", addon.Name)),
+		Content: fmt.Sprintf("---\n%s---\n%s", configMapYaml, clusterResourceSetYaml),
+	}, nil
+}
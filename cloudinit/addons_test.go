@@ -0,0 +1,256 @@
+package cloudinit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	addonsv1 "sigs.k8s.io/cluster-api/exp/addons/api/v1beta1"
+
+	"capi-bootstrap/types"
+	"capi-bootstrap/yaml"
+)
+
+func TestGenerateAddons(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "manifest.yaml")
+	err := os.WriteFile(manifestPath, []byte(`---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: "[[[ .ClusterName ]]]-[[[ .Addon.Version ]]]"
+  namespace: "[[[ .Addon.Namespace ]]]"
+`), 0o600)
+	assert.NoError(t, err)
+
+	type test struct {
+		name    string
+		addons  []types.Addon
+		want    []yaml.InitFile
+		wantErr string
+	}
+	tests := []test{
+		{
+			name: "success helm chart",
+			addons: []types.Addon{{
+				Name:    "ingress-nginx",
+				Chart:   "ingress-nginx",
+				Repo:    "https://kubernetes.github.io/ingress-nginx",
+				Version: "4.11.2",
+				Values:  "controller:\n  ingressClass: \"[[[ .ClusterName ]]]\"\n",
+			}},
+			want: []yaml.InitFile{{
+				Path: "/tmp/addon-ingress-nginx.yaml",
+				Content: `---
+apiVersion: helm.cattle.io/v1
+kind: HelmChart
+metadata:
+  name: "ingress-nginx"
+  namespace: kube-system
+spec:
+  repo: "https://kubernetes.github.io/ingress-nginx"
+  chart: "ingress-nginx"
+  version: "4.11.2"
+  targetNamespace: "ingress-nginx"
+  createNamespace: true
+  valuesContent: |-
+    controller:
+      ingressClass: "test-cluster"
+`,
+			}},
+		},
+		{
+			name: "success helm chart without version and values",
+			addons: []types.Addon{{
+				Name:      "external-dns",
+				Namespace: "dns",
+				Chart:     "external-dns",
+				Repo:      "https://kubernetes-sigs.github.io/external-dns",
+			}},
+			want: []yaml.InitFile{{
+				Path: "/tmp/addon-external-dns.yaml",
+				Content: `---
+apiVersion: helm.cattle.io/v1
+kind: HelmChart
+metadata:
+  name: "external-dns"
+  namespace: kube-system
+spec:
+  repo: "https://kubernetes-sigs.github.io/external-dns"
+  chart: "external-dns"
+  targetNamespace: "dns"
+  createNamespace: true
+`,
+			}},
+		},
+		{
+			name: "success manifest with cluster resource set",
+			addons: []types.Addon{{
+				Name:               "cni",
+				Version:            "v1.0.0",
+				Manifest:           manifestPath,
+				ClusterResourceSet: true,
+			}},
+			want: []yaml.InitFile{
+				{
+					Path: "/tmp/addon-cni.yaml",
+					Content: `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: "test-cluster-v1.0.0"
+  namespace: "cni"
+`,
+				},
+				{
+					Path: "/tmp/addon-cni-crs.yaml",
+					Content: `---
+apiVersion: v1
+data:
+  cni.yaml: |
+    ---
+    apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "test-cluster-v1.0.0"
+      namespace: "cni"
+kind: ConfigMap
+metadata:
+  creationTimestamp: null
+  labels:
+    cluster.x-k8s.io/cluster-name: test-cluster
+  name: test-cluster-addon-cni
+  namespace: default
+---
+apiVersion: addons.cluster.x-k8s.io/v1beta1
+kind: ClusterResourceSet
+metadata:
+  creationTimestamp: null
+  labels:
+    cluster.x-k8s.io/cluster-name: test-cluster
+  name: test-cluster-addon-cni
+  namespace: default
+spec:
+  clusterSelector:
+    matchLabels:
+      cluster.x-k8s.io/cluster-name: test-cluster
+  resources:
+  - kind: ConfigMap
+    name: test-cluster-addon-cni
+status: {}
+`,
+				},
+			},
+		},
+		{
+			name: "success escaped jinja",
+			addons: []types.Addon{{
+				Name:   "grafana",
+				Chart:  "grafana",
+				Repo:   "https://grafana.github.io/helm-charts",
+				Values: "fullnameOverride: \"{{ .Release.Name }}\"\n",
+			}},
+			want: []yaml.InitFile{{
+				Path: "/tmp/addon-grafana.yaml",
+				Content: `---
+apiVersion: helm.cattle.io/v1
+kind: HelmChart
+metadata:
+  name: "grafana"
+  namespace: kube-system
+spec:
+  repo: "https://grafana.github.io/helm-charts"
+  chart: "grafana"
+  targetNamespace: "grafana"
+  createNamespace: true
+  valuesContent: |-
+    fullnameOverride: "{{ '{{ .Release.Name }}' }}"
+`,
+			}},
+		},
+		{
+			name:    "err missing name",
+			addons:  []types.Addon{{Chart: "cilium"}},
+			wantErr: "failed to generate addon : addon name is empty",
+		},
+		{
+			name:    "err invalid name",
+			addons:  []types.Addon{{Name: "Cilium_CNI", Chart: "cilium"}},
+			wantErr: "failed to generate addon Cilium_CNI: invalid addon name: a lowercase RFC 1123 label must consist of lower case alphanumeric characters or '-', and must start and end with an alphanumeric character (e.g. 'my-name',  or '123-abc', regex used for validation is '[a-z0-9]([-a-z0-9]*[a-z0-9])?')",
+		},
+		{
+			name:    "err chart and manifest",
+			addons:  []types.Addon{{Name: "cilium", Chart: "cilium", Manifest: manifestPath}},
+			wantErr: "failed to generate addon cilium: only one of chart or manifest can be set",
+		},
+		{
+			name:    "err no source",
+			addons:  []types.Addon{{Name: "cilium"}},
+			wantErr: "failed to generate addon cilium: either a chart or manifest is required",
+		},
+		{
+			name:    "err missing manifest",
+			addons:  []types.Addon{{Name: "cilium", Manifest: filepath.Join(dir, "missing.yaml")}},
+			wantErr: "failed to generate addon cilium: open " + filepath.Join(dir, "missing.yaml") + ": no such file or directory",
+		},
+		{
+			name:    "err invalid values template",
+			addons:  []types.Addon{{Name: "cilium", Chart: "cilium", Values: "[[[ {} ]]]"}},
+			wantErr: "failed to generate addon cilium: failed to parse template cilium values, template: cilium values:1: unexpected \"{\" in command",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			values := &types.Values{
+				ClusterName:          "test-cluster",
+				Namespace:            "default",
+				BootstrapManifestDir: "/tmp/",
+				Addons:               tc.addons,
+			}
+			addonFiles, err := generateAddons(context.Background(), values)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.want, addonFiles)
+			}
+		})
+	}
+}
+
+func TestAddonClusterResourceSetSelector(t *testing.T) {
+	t.Parallel()
+	values := &types.Values{ClusterName: "test-cluster", Namespace: "default"}
+	crsFile, err := generateAddonClusterResourceSet(values, types.Addon{Name: "cni"}, "---\n")
+	require.NoError(t, err)
+	crsManifest, err := yaml.ParseManifest(crsFile.Content)
+	require.NoError(t, err)
+	var clusterResourceSet addonsv1.ClusterResourceSet
+	require.NoError(t, yaml.DecodeObject(crsManifest.Get(addonsv1.GroupVersion.WithKind("ClusterResourceSet"), ""), &clusterResourceSet))
+	selector, err := metav1.LabelSelectorAsSelector(&clusterResourceSet.Spec.ClusterSelector)
+	require.NoError(t, err)
+
+	manifest, err := yaml.ParseManifest(`---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+  namespace: default
+spec:
+  controlPlaneRef:
+    apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+    kind: KThreesControlPlane
+    name: test-cluster-control-plane
+`)
+	require.NoError(t, err)
+	require.NoError(t, yaml.UpdateCluster(manifest))
+	cluster := yaml.GetClusterDef(manifest)
+	require.NotNil(t, cluster)
+	assert.True(t, selector.Matches(labels.Set(cluster.Labels)), "selector %s doesn't match the labels %v of the cluster", selector, cluster.Labels)
+}
@@ -6,9 +6,11 @@ import (
 	"compress/gzip"
 	"context"
 	"embed"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"path"
+	"slices"
 	"strings"
 	"time"
 
@@ -24,11 +26,33 @@ import (
 //go:embed files
 var files embed.FS
 
+const (
+	tarPath = "/tmp/cloud-init-files.tgz"
+	// tarAppendDir is where files that are appended to are extracted before they are appended to their path
+	tarAppendDir = "tmp/cloud-init-append"
+)
+
+// debugCmds are the commands run on the bootstrap machine when the debug profile is enabled.
+var debugCmds = []string{"curl -s -L https://github.com/derailed/k9s/releases/download/v0.32.4/k9s_Linux_amd64.tar.gz | tar -xvz -C /usr/local/bin k9s",
+	`echo "alias k=\"k3s kubectl\"" >> /root/.bashrc`,
+	"echo \"export KUBECONFIG=/etc/rancher/k3s/k3s.yaml\" >> /root/.bashrc"}
+
 func GenerateCloudInit(ctx context.Context, values *types.Values, infra infrastructure.Provider, controlPlane controlplane.Provider, backend backend.Provider) ([]byte, error) {
-	debugCmds := []string{"curl -s -L https://github.com/derailed/k9s/releases/download/v0.32.4/k9s_Linux_amd64.tar.gz | tar -xvz -C /usr/local/bin k9s",
-		`echo "alias k=\"k3s kubectl\"" >> /root/.bashrc`,
-		"echo \"export KUBECONFIG=/etc/rancher/k3s/k3s.yaml\" >> /root/.bashrc"}
+	cloudConfig, err := GenerateCloudConfig(ctx, values, infra, controlPlane, backend)
+	if err != nil {
+		return nil, err
+	}
+	return MarshalUserData(cloudConfig, values.OutputFormat)
+}
+
+// GenerateCloudConfig generates the cloud-config for the bootstrap machine, with all files already handed to the backend.
+func GenerateCloudConfig(ctx context.Context, values *types.Values, infra infrastructure.Provider, controlPlane controlplane.Provider, backend backend.Provider) (*capiYaml.Config, error) {
 	initScriptPath := "/tmp/init-cluster.sh"
+	if values.TarWriteFiles && values.OutputFormat == types.OutputFormatIgnition {
+		// the tar is extracted by a runcmd that renders every member with cloud-init query
+		return nil, fmt.Errorf("tar write files requires cloud-init, it can't be used with the %s output format", types.OutputFormatIgnition)
+	}
+	values.Versions.SetDefaults()
 	certManager, err := generateCertManagerManifest(values)
 	if err != nil {
 		return nil, err
@@ -38,6 +62,11 @@ func GenerateCloudInit(ctx context.Context, values *types.Values, infra infrastr
 		return nil, err
 	}
 
+	addons, err := generateAddons(ctx, values)
+	if err != nil {
+		return nil, err
+	}
+
 	capiManifests, err := GenerateCapiManifests(ctx, values, infra, controlPlane, true)
 	if err != nil {
 		return nil, err
@@ -87,11 +116,11 @@ func GenerateCloudInit(ctx context.Context, values *types.Values, infra infrastr
 		return nil, err
 	}
 
-	runCmds := []string{fmt.Sprintf("bash %s", initScriptPath)}
-	runCmds = append(debugCmds, runCmds...)
-	runCmds = append(controlPlaneRunCmd, runCmds...)
-	runCmds = append(capiManifests.PreRunCmd, runCmds...)
-	runCmds = append(runCmds, capiManifests.PostRunCmd...)
+	runCmds := slices.Concat(capiManifests.PreRunCmd, controlPlaneRunCmd)
+	if values.CloudInit.Debug {
+		runCmds = append(runCmds, debugCmds...)
+	}
+	postRunCmds := slices.Concat(capiManifests.PostRunCmd, values.CloudInit.RunCmd)
 
 	writeFiles := []capiYaml.InitFile{
 		*certManager,
@@ -102,29 +131,146 @@ func GenerateCloudInit(ctx context.Context, values *types.Values, infra infrastr
 		*capiManifests.ManifestFile,
 		*controlPlaneCertSecrets,
 		*kubeconfigSecret,
-		*initScript,
 	}
+	var parts []capiYaml.Part
+	if values.OutputFormat == types.OutputFormatMIME {
+		// the init script and everything after it are shipped as shell script parts, which run after runcmd
+		parts = generateParts(values, initScript, postRunCmds)
+	} else {
+		if len(values.CloudInit.CloudConfigParts) != 0 || len(values.CloudInit.Boothooks) != 0 {
+			return nil, fmt.Errorf("cloud-config and boothook parts require the %s output format", types.OutputFormatMIME)
+		}
+		writeFiles = append(writeFiles, *initScript)
+		runCmds = append(runCmds, fmt.Sprintf("bash %s", initScriptPath))
+		runCmds = append(runCmds, postRunCmds...)
+	}
+	writeFiles = append(writeFiles, addons...)
 	writeFiles = append(writeFiles, additionalInfraFiles...)
 	writeFiles = append(writeFiles, additionalControlPlaneFiles...)
 	writeFiles = append(writeFiles, controlPlaneCertFiles...)
 	writeFiles = append(writeFiles, capiManifests.AdditionalFiles...)
+	writeFiles = append(writeFiles, values.CloudInit.WriteFiles...)
+
+	cloudConfig := capiYaml.Config{
+		WriteFiles: writeFiles,
+		RunCmd:     runCmds,
+		Parts:      parts,
+	}
+	if values.OutputFormat != types.OutputFormatIgnition {
+		if err := ValidateJinja(&cloudConfig); err != nil {
+			return nil, err
+		}
+	}
 	if values.TarWriteFiles {
-		writeFiles, err = createTar(writeFiles)
+		tarFiles, tarCmds, err := createTar(writeFiles)
 		if err != nil {
 			return nil, err
 		}
-		runCmds = append([]string{"tar -C / -xvf /tmp/cloud-init-files.tgz", "tar -xf /tmp/cloud-init-files.tgz --to-command='xargs -0 cloud-init query -f > /$TAR_FILENAME'"}, runCmds...)
+		cloudConfig.WriteFiles = tarFiles
+		cloudConfig.RunCmd = append(tarCmds, runCmds...)
+		// files with a remote source are kept in write_files by createTar
+		for _, file := range writeFiles {
+			if file.Source.URI == "" {
+				cloudConfig.TarFiles = append(cloudConfig.TarFiles, file)
+			}
+		}
 	}
 
-	cloudConfig := capiYaml.Config{
-		WriteFiles: writeFiles,
-		RunCmd:     runCmds,
-	}
-	downloadCmds, err := backend.WriteFiles(ctx, values.ClusterName, &cloudConfig)
+	downloadCmds, err := writeBackendFiles(ctx, values, backend, &cloudConfig)
 	if err != nil {
 		return nil, err
 	}
 	cloudConfig.RunCmd = append(downloadCmds, cloudConfig.RunCmd...)
+	if values.OutputFormat != types.OutputFormatIgnition {
+		if err := ValidateCloudConfig(&cloudConfig); err != nil {
+			return nil, err
+		}
+	}
+	return &cloudConfig, nil
+}
+
+// writeBackendFiles hands the files of cloudConfig to the backend and returns the commands that install them. Files
+// that already have a source are never uploaded. With FileDeliverySource the files keep the source set by the backend
+// and are fetched by cloud-init, files that use instance data stay inline so they are still rendered with jinja.
+// Every downloaded file is verified against the SHA-256 of what was uploaded by the fetch script added to the files.
+func writeBackendFiles(ctx context.Context, values *types.Values, backend backend.Provider, cloudConfig *capiYaml.Config) ([]string, error) {
+	sourceDelivery, err := isSourceDelivery(values)
+	if err != nil {
+		return nil, err
+	}
+	var uploadIndexes []int
+	upload := capiYaml.Config{}
+	for i, file := range cloudConfig.WriteFiles {
+		if file.Source.URI != "" || (sourceDelivery && file.Jinja) {
+			continue
+		}
+		if sourceDelivery {
+			// cloud-init doesn't decode or render files from a source, so they are uploaded as they are written to disk
+			content, err := file.DecodedContent()
+			if err != nil {
+				return nil, err
+			}
+			if file.Encoding == "" || file.Encoding == "text/plain" {
+				content = []byte(capiYaml.UnescapeJinja(string(content)))
+			}
+			file.Content = string(content)
+			file.Encoding = ""
+		}
+		uploadIndexes = append(uploadIndexes, i)
+		upload.WriteFiles = append(upload.WriteFiles, file)
+	}
+	if len(uploadIndexes) == 0 {
+		return nil, nil
+	}
+	// the checksums are taken before the upload since backends clear the content of the files they store
+	checksums := make([]string, len(upload.WriteFiles))
+	for j, file := range upload.WriteFiles {
+		checksums[j] = file.SHA256()
+	}
+
+	downloadCmds, err := backend.WriteFiles(ctx, values.ClusterName, &upload)
+	if err != nil {
+		return nil, err
+	}
+	var verifyCmds []string
+	for j, i := range uploadIndexes {
+		file := upload.WriteFiles[j]
+		switch {
+		case !sourceDelivery:
+			file.Source = capiYaml.Source{}
+		case file.Source.URI == "":
+			// the backend kept the file inline
+			continue
+		default:
+			verifyCmds = append(verifyCmds, file.VerifySourceCmd(checksums[j]))
+		}
+		cloudConfig.WriteFiles[i] = file
+	}
+	if sourceDelivery {
+		downloadCmds = verifyCmds
+	}
+	if len(downloadCmds) != 0 {
+		cloudConfig.WriteFiles = append(cloudConfig.WriteFiles, capiYaml.FetchScript())
+	}
+	return downloadCmds, nil
+}
+
+// isSourceDelivery returns whether the files uploaded to the backend are delivered with FileDeliverySource, which is
+// the default with Ignition. FileDeliveryRunCmd can't be used with Ignition since the runcmds render the files with
+// cloud-init query.
+func isSourceDelivery(values *types.Values) (bool, error) {
+	if values.OutputFormat != types.OutputFormatIgnition {
+		return values.FileDelivery == types.FileDeliverySource, nil
+	}
+	if values.FileDelivery == types.FileDeliveryRunCmd {
+		return false, fmt.Errorf("file delivery %s requires cloud-init, use %s with the %s output format",
+			types.FileDeliveryRunCmd, types.FileDeliverySource, types.OutputFormatIgnition)
+	}
+	return true, nil
+}
+
+// MarshalCloudConfig returns the user-data for a cloud-config with the headers needed by cloud-init.
+func MarshalCloudConfig(cloudConfig *capiYaml.Config) ([]byte, error) {
 	rawCloudConfig, err := yaml.Marshal(cloudConfig)
 	if err != nil {
 		return nil, err
@@ -146,7 +292,8 @@ func generateCapiOperator(values *types.Values) (*capiYaml.InitFile, error) {
 
 func GenerateCapiManifests(ctx context.Context, values *types.Values, infra infrastructure.Provider, controlPlane controlplane.Provider, escapeYaml bool) (*capiYaml.ParsedManifest, error) {
 	filePath := path.Join(values.BootstrapManifestDir, "capi-manifests.yaml")
-	cloudInitFile, err := capiYaml.ConstructFile(filePath, values.ManifestFile, values.ManifestFS, values, escapeYaml)
+	// the manifest is escaped after it's updated, the patches of a ClusterClass are templates that can't be escaped
+	cloudInitFile, err := capiYaml.ConstructFile(filePath, values.ManifestFile, values.ManifestFS, values, false)
 	if err != nil {
 		return nil, err
 	}
@@ -156,29 +303,76 @@ func GenerateCapiManifests(ctx context.Context, values *types.Values, infra infr
 		return nil, err
 	}
 	cloudInitFile.Content = string(initFileContent)
+	if escapeYaml {
+		cloudInitFile.Content = capiYaml.EscapeJinja(cloudInitFile.Content)
+	}
 	capiManifests.ManifestFile = cloudInitFile
 	return capiManifests, nil
 }
 
-func createTar(cloudFiles []capiYaml.InitFile) ([]capiYaml.InitFile, error) {
-	data, err := tarFromInitFiles(cloudFiles)
+// createTar bundles files into a single tarball and returns the commands to extract it. Members keep the mode and owner
+// of their file and are decoded, only unencoded members are rendered with cloud-init query. Files that are appended to
+// are staged in tarAppendDir and appended after extraction, files with a remote source are kept in write_files.
+// The tarball is extracted by runcmd, which runs after write_files_deferred, so deferred files are always honoured.
+func createTar(cloudFiles []capiYaml.InitFile) ([]capiYaml.InitFile, []string, error) {
+	var writeFiles []capiYaml.InitFile
+	var members []tarMember
+	var renderMembers []string
+	var appendCmds []string
+	for _, file := range cloudFiles {
+		if file.Source.URI != "" {
+			writeFiles = append(writeFiles, file)
+			continue
+		}
+		name := strings.TrimPrefix(file.Path, "/")
+		if file.Append {
+			name = path.Join(tarAppendDir, name)
+			cmd, err := appendCmd("/"+name, file)
+			if err != nil {
+				return nil, nil, err
+			}
+			appendCmds = append(appendCmds, cmd)
+		}
+		if !file.IsBase64() && !file.IsGzip() {
+			renderMembers = append(renderMembers, capiYaml.ShellQuote(name))
+		}
+		members = append(members, tarMember{name: name, file: file})
+	}
+
+	data, err := tarFromInitFiles(members)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	writeFiles = append(writeFiles, capiYaml.InitFile{
+		Path:        tarPath,
+		Content:     base64.StdEncoding.EncodeToString(data),
+		Encoding:    "b64",
+		Permissions: "0600",
+	})
+
+	tarCmds := []string{fmt.Sprintf("tar --same-owner -C / -xpvf %s", tarPath)}
+	if len(renderMembers) != 0 {
+		tarCmds = append(tarCmds, fmt.Sprintf("tar -xf %s --to-command='xargs -0 cloud-init query -f > /$TAR_FILENAME' %s", tarPath, strings.Join(renderMembers, " ")))
 	}
+	tarCmds = append(tarCmds, appendCmds...)
+	return writeFiles, tarCmds, nil
+}
 
-	writeFiles := []capiYaml.InitFile{{
-		Path:    "/tmp/cloud-init-files.tgz",
-		Content: string(data),
-	}}
-	return writeFiles, nil
+// tarMember is a file in the tarball, name is the path it is extracted to relative to /.
+type tarMember struct {
+	name string
+	file capiYaml.InitFile
 }
 
-func tarFromInitFiles(files []capiYaml.InitFile) (data []byte, err error) {
+func tarFromInitFiles(members []tarMember) (data []byte, err error) {
 	var buf bytes.Buffer
 	gzipWriter := gzip.NewWriter(&buf)
 	tarWriter := tar.NewWriter(gzipWriter)
 
 	defer func() {
+		if err != nil {
+			return
+		}
 		err = tarWriter.Close() // close tar writer first
 		if err != nil {
 			return
@@ -190,19 +384,30 @@ func tarFromInitFiles(files []capiYaml.InitFile) (data []byte, err error) {
 		data, err = io.ReadAll(&buf) // capture all output
 	}()
 
-	for _, file := range files {
+	for _, member := range members {
+		content, err := member.file.DecodedContent()
+		if err != nil {
+			return data, err
+		}
+		mode, err := member.file.FileMode()
+		if err != nil {
+			return data, err
+		}
+		user, group := member.file.UserGroup()
 		header := &tar.Header{
-			Name:    file.Path[1:],
-			Size:    int64(len(file.Content)),
+			Name:    member.name,
+			Size:    int64(len(content)),
 			ModTime: time.Now(),
-			Mode:    0o644,
+			Mode:    int64(mode.Perm()),
+			Uname:   user,
+			Gname:   group,
 		}
 		err = tarWriter.WriteHeader(header)
 		if err != nil {
 			return data, err
 		}
 
-		_, err = io.WriteString(tarWriter, file.Content)
+		_, err = tarWriter.Write(content)
 		if err != nil {
 			return data, err
 		}
@@ -211,26 +416,45 @@ func tarFromInitFiles(files []capiYaml.InitFile) (data []byte, err error) {
 	return data, err
 }
 
+// appendCmd returns the command appending the staged file at stagedPath to the path of file.
+func appendCmd(stagedPath string, file capiYaml.InitFile) (string, error) {
+	mode, err := file.FileMode()
+	if err != nil {
+		return "", err
+	}
+	user, group := file.UserGroup()
+	return fmt.Sprintf("cat %[1]s >> %[2]s && chmod %04[3]o %[2]s && chown %[4]s:%[5]s %[2]s && rm %[1]s",
+		capiYaml.ShellQuote(stagedPath), capiYaml.ShellQuote(file.Path), mode.Perm(), user, group), nil
+}
+
 func UpdateManifest(ctx context.Context, yamlManifest string, infra infrastructure.Provider, controlPlane controlplane.Provider, values *types.Values) ([]byte, *capiYaml.ParsedManifest, error) {
-	manifests := strings.Split(yamlManifest, "---")
+	manifest, err := capiYaml.ParseManifest(yamlManifest)
+	if err != nil {
+		return nil, nil, err
+	}
 	controlPlaneManifests := &capiYaml.ParsedManifest{}
-	var err error
-	if err := capiYaml.UpdateCluster(manifests); err != nil {
+	if err := capiYaml.ResolveTopology(ctx, manifest); err != nil {
+		return nil, nil, err
+	}
+	if err := capiYaml.UpdateCluster(manifest); err != nil {
 		return nil, nil, err
 	}
 	if infra != nil {
-		if err := infra.UpdateManifests(ctx, manifests, values); err != nil {
+		if err := infra.UpdateManifests(ctx, manifest, values); err != nil {
 			return nil, nil, err
 		}
 	}
 
 	if controlPlane != nil {
-		controlPlaneManifests, err = controlPlane.UpdateManifests(ctx, manifests, values)
+		controlPlaneManifests, err = controlPlane.UpdateManifests(ctx, manifest, values)
 		if err != nil {
 			return nil, nil, err
 		}
 	}
 
-	yamlManifest = strings.Join(manifests, "---\n")
-	return []byte(yamlManifest), controlPlaneManifests, nil
+	rawManifest, err := manifest.Marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+	return rawManifest, controlPlaneManifests, nil
 }
@@ -1,10 +1,15 @@
 package cloudinit
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
@@ -35,6 +40,16 @@ func workingMock(ctx context.Context, t *testing.T, mock *mockInfa.MockProvider)
 }
 
 func TestGenerateCloudInit(t *testing.T) {
+	// the fetch script is added to the files whenever the backend returns commands
+	var fetchScript strings.Builder
+	fetchScript.WriteString("    - path: " + yaml.FetchScriptPath + "\n      content: |\n")
+	for _, line := range strings.SplitAfter(yaml.FetchScript().Content, "\n") {
+		if strings.TrimSpace(line) != "" {
+			fetchScript.WriteString("        ")
+		}
+		fetchScript.WriteString(line)
+	}
+	fetchScript.WriteString("      permissions: \"0755\"\n")
 	expectedManifest := `## template: jinja
 #cloud-config
 
@@ -50,6 +65,7 @@ write_files:
         spec:
           repo: https://charts.jetstack.io
           chart: cert-manager
+          version: "v1.15.3"
           targetNamespace: cert-manager
           createNamespace: true
           bootstrap: true
@@ -71,11 +87,12 @@ write_files:
         spec:
           repo: https://kubernetes-sigs.github.io/cluster-api-operator
           chart: cluster-api-operator
+          version: "v0.12.0"
           targetNamespace: capi-operator-system
           createNamespace: true
           bootstrap: true
           valuesContent: |-
-            core: cluster-api
+            core: "cluster-api:v1.8.1"
             addon: helm
             manager:
               featureGates:
@@ -91,7 +108,8 @@ write_files:
         apiVersion: cluster.x-k8s.io/v1beta1
         kind: Cluster
         metadata:
-          creationTimestamp: null
+          labels:
+            cluster.x-k8s.io/cluster-name: test-cluster
           name: test-cluster
           namespace: default
         spec:
@@ -99,9 +117,6 @@ write_files:
             pods:
               cidrBlocks:
               - 10.192.0.0/10
-          controlPlaneEndpoint:
-            host: ""
-            port: 0
           controlPlaneRef:
             apiVersion: controlplane.cluster.x-k8s.io/v1beta1
             kind: FakeControlPlane
@@ -110,9 +125,6 @@ write_files:
             apiVersion: infrastructure.cluster.x-k8s.io/v1alpha1
             kind: FakeInfrastructureCluster
             name: test-cluster
-        status:
-          controlPlaneReady: false
-          infrastructureReady: false
     - path: /tmp/test.cert
     - path: /tmp/kubeconfig
     - path: /tmp/init-cluster.sh
@@ -120,20 +132,27 @@ write_files:
       content: additional text
     - path: /tmp/cp-additional.txt
     - path: /tmp/certs.yaml
-runcmd:
+` + fetchScript.String() + `runcmd:
     - curl install-manifests
     - curl install-k8s.com
-    - curl -s -L https://github.com/derailed/k9s/releases/download/v0.32.4/k9s_Linux_amd64.tar.gz | tar -xvz -C /usr/local/bin k9s
+    - bash /tmp/init-cluster.sh
+`
+	expectedDebugManifest := strings.Replace(expectedManifest, "    - path: /tmp/certs.yaml\n", `    - path: /tmp/certs.yaml
+    - path: /etc/extra.conf
+      content: extra
+`, 1)
+	expectedDebugManifest = strings.Replace(expectedDebugManifest, "    - bash /tmp/init-cluster.sh\n", `    - curl -s -L https://github.com/derailed/k9s/releases/download/v0.32.4/k9s_Linux_amd64.tar.gz | tar -xvz -C /usr/local/bin k9s
     - echo "alias k=\"k3s kubectl\"" >> /root/.bashrc
     - echo "export KUBECONFIG=/etc/rancher/k3s/k3s.yaml" >> /root/.bashrc
     - bash /tmp/init-cluster.sh
-`
+    - echo done
+`, 1)
 	expectedTarManfest := `## template: jinja
 #cloud-config
 
 write_files:
     - path: /tmp/cloud-init-files.tgz
-      content: !!binary |`
+      content: `
 	manifestInput := `---
 apiVersion: cluster.x-k8s.io/v1beta1
 kind: Cluster
@@ -210,6 +229,54 @@ spec:
 			},
 			want: expectedManifest,
 		},
+		{
+			name:            "success debug profile and extras",
+			mockInfraClient: workingMock,
+			mockControlPlaneClient: func(ctx context.Context, t *testing.T, mock *mockControlplane.MockProvider) *mockControlplane.MockProvider {
+				mock.EXPECT().
+					UpdateManifests(ctx, gomock.Any(), gomock.Any()).
+					Return(&yaml.ParsedManifest{}, nil)
+				mock.EXPECT().
+					GenerateCapiFile(ctx, gomock.Any()).
+					Return(&yaml.InitFile{Path: "/tmp/cpCapi.yaml"}, nil)
+				mock.EXPECT().
+					GenerateAdditionalFiles(ctx, gomock.Any()).
+					Return([]yaml.InitFile{{Path: "/tmp/cp-additional.txt"}}, nil)
+				mock.EXPECT().
+					GenerateInitScript(ctx, "/tmp/init-cluster.sh", gomock.Any()).
+					Return(&yaml.InitFile{Path: "/tmp/init-cluster.sh"}, nil)
+				mock.EXPECT().
+					GenerateRunCommand(ctx, gomock.Any()).
+					Return([]string{"curl install-k8s.com"}, nil)
+				mock.EXPECT().
+					GetControlPlaneCertFiles(ctx).
+					Return([]yaml.InitFile{{Path: "/tmp/certs.yaml"}}, nil)
+				mock.EXPECT().
+					GetControlPlaneCertSecret(ctx, gomock.Any()).
+					Return(&yaml.InitFile{Path: "/tmp/test.cert"}, nil)
+				mock.EXPECT().
+					GetKubeconfig(ctx, gomock.Any()).
+					Return(&yaml.InitFile{Path: "/tmp/kubeconfig"}, nil)
+				return mock
+			},
+			mocBackendClient: func(ctx context.Context, t *testing.T, mock *mockBackend.MockProvider) *mockBackend.MockProvider {
+				mock.EXPECT().
+					WriteFiles(ctx, gomock.Any(), gomock.Any()).
+					Return([]string{"curl install-manifests"}, nil)
+				return mock
+			},
+			manifest: manifestInput,
+			value: types.Values{
+				ManifestFile:         "tmpfile",
+				BootstrapManifestDir: "/tmp/",
+				CloudInit: types.CloudInit{
+					Debug:      true,
+					RunCmd:     []string{"echo done"},
+					WriteFiles: []yaml.InitFile{{Path: "/etc/extra.conf", Content: "extra"}},
+				},
+			},
+			want: expectedDebugManifest,
+		},
 		{
 			name:            "success tar files",
 			mockInfraClient: workingMock,
@@ -254,6 +321,67 @@ spec:
 			manifest: manifestInput,
 			want:     expectedTarManfest,
 		},
+		{
+			name: "err tar with ignition",
+			mockInfraClient: func(ctx context.Context, t *testing.T, mock *mockInfa.MockProvider) *mockInfa.MockProvider {
+				return mock
+			},
+			mockControlPlaneClient: func(ctx context.Context, t *testing.T, mock *mockControlplane.MockProvider) *mockControlplane.MockProvider {
+				return mock
+			},
+			mocBackendClient: func(ctx context.Context, t *testing.T, mock *mockBackend.MockProvider) *mockBackend.MockProvider {
+				return mock
+			},
+			value: types.Values{
+				ManifestFile:         "tmpfile",
+				BootstrapManifestDir: "/tmp/",
+				TarWriteFiles:        true,
+				OutputFormat:         types.OutputFormatIgnition,
+			},
+			manifest: manifestInput,
+			wantErr:  "tar write files requires cloud-init, it can't be used with the ignition output format",
+		},
+		{
+			name:            "err parts without mime",
+			mockInfraClient: workingMock,
+			mockControlPlaneClient: func(ctx context.Context, t *testing.T, mock *mockControlplane.MockProvider) *mockControlplane.MockProvider {
+				mock.EXPECT().
+					UpdateManifests(ctx, gomock.Any(), gomock.Any()).
+					Return(&yaml.ParsedManifest{}, nil)
+				mock.EXPECT().
+					GenerateCapiFile(ctx, gomock.Any()).
+					Return(&yaml.InitFile{Path: "/tmp/cpCapi.yaml"}, nil)
+				mock.EXPECT().
+					GenerateAdditionalFiles(ctx, gomock.Any()).
+					Return([]yaml.InitFile{{Path: "/tmp/cp-additional.txt"}}, nil)
+				mock.EXPECT().
+					GenerateInitScript(ctx, "/tmp/init-cluster.sh", gomock.Any()).
+					Return(&yaml.InitFile{Path: "/tmp/init-cluster.sh"}, nil)
+				mock.EXPECT().
+					GenerateRunCommand(ctx, gomock.Any()).
+					Return([]string{"curl install-k8s.com"}, nil)
+				mock.EXPECT().
+					GetControlPlaneCertFiles(ctx).
+					Return([]yaml.InitFile{{Path: "/tmp/certs.yaml"}}, nil)
+				mock.EXPECT().
+					GetControlPlaneCertSecret(ctx, gomock.Any()).
+					Return(&yaml.InitFile{Path: "/tmp/test.cert"}, nil)
+				mock.EXPECT().
+					GetKubeconfig(ctx, gomock.Any()).
+					Return(&yaml.InitFile{Path: "/tmp/kubeconfig"}, nil)
+				return mock
+			},
+			mocBackendClient: func(ctx context.Context, t *testing.T, mock *mockBackend.MockProvider) *mockBackend.MockProvider {
+				return mock
+			},
+			value: types.Values{
+				ManifestFile:         "tmpfile",
+				BootstrapManifestDir: "/tmp/",
+				CloudInit:            types.CloudInit{Boothooks: []string{"echo boothook"}},
+			},
+			manifest: manifestInput,
+			wantErr:  "cloud-config and boothook parts require the mime output format",
+		},
 		{
 			name: "err updateManifests",
 			mockInfraClient: func(ctx context.Context, t *testing.T, mock *mockInfa.MockProvider) *mockInfa.MockProvider {
@@ -682,6 +810,38 @@ func TestGenerateCapiManifests(t *testing.T) {
 		mockControlPlaneClient func(ctx context.Context, t *testing.T, mock *mockControlplane.MockProvider) *mockControlplane.MockProvider
 	}
 
+	clusterManifest := `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+  namespace: default
+spec:
+  clusterNetwork:
+    pods:
+      cidrBlocks:
+      - 10.192.0.0/10
+  controlPlaneRef:
+    apiVersion: controlplane.cluster.x-k8s.io/v1beta1
+    kind: FakeControlPlane
+    name: test-cluster-control-plane
+  infrastructureRef:
+    apiVersion: infrastructure.cluster.x-k8s.io/v1alpha1
+    kind: FakeInfrastructureCluster
+    name: test-cluster
+`
+	configMapManifest := `--- # leading comment
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-config
+data:
+  ca.crt: |
+    -----BEGIN CERTIFICATE-----
+    ---
+    -----END CERTIFICATE-----
+`
+
 	tests := []test{
 		{
 			name: "success",
@@ -702,30 +862,7 @@ func TestGenerateCapiManifests(t *testing.T) {
 					}, nil)
 				return mock
 			},
-			manifest: `---
-test1: false
----
-test2: true
----
-apiVersion: cluster.x-k8s.io/v1beta1
-kind: Cluster
-metadata:
-  name: test-cluster
-  namespace: default
-spec:
-  clusterNetwork:
-    pods:
-      cidrBlocks:
-      - 10.192.0.0/10
-  controlPlaneRef:
-    apiVersion: controlplane.cluster.x-k8s.io/v1beta1
-    kind: FakeControlPlane
-    name: test-cluster-control-plane
-  infrastructureRef:
-    apiVersion: infrastructure.cluster.x-k8s.io/v1alpha1
-    kind: FakeInfrastructureCluster
-    name: test-cluster
-`,
+			manifest: configMapManifest + clusterManifest,
 		},
 		{
 			name: "err construct file",
@@ -739,7 +876,7 @@ spec:
 			wantErr:  "failed to parse template tmpfile, template: tmpfile:1: unexpected \"{\" in command",
 		},
 		{
-			name: "err update cluster",
+			name: "err parse manifest",
 			mockInfraClient: func(ctx context.Context, t *testing.T, mock *mockInfa.MockProvider) *mockInfa.MockProvider {
 				return mock
 			},
@@ -747,7 +884,38 @@ spec:
 				return mock
 			},
 			manifest: `--`,
-			wantErr:  "error unmarshaling JSON: while decoding JSON: json: cannot unmarshal string into Go value of type v1beta1.Cluster",
+			wantErr:  "could not decode document 0: json: cannot unmarshal string into Go value of type map[string]interface {}",
+		},
+		{
+			name: "err resolve topology",
+			mockInfraClient: func(ctx context.Context, t *testing.T, mock *mockInfa.MockProvider) *mockInfa.MockProvider {
+				return mock
+			},
+			mockControlPlaneClient: func(ctx context.Context, t *testing.T, mock *mockControlplane.MockProvider) *mockControlplane.MockProvider {
+				return mock
+			},
+			manifest: `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+spec:
+  topology:
+    class: test-class
+    version: v1.30.0
+`,
+			wantErr: "ClusterClass test-class referenced by cluster test-cluster not found in the manifest",
+		},
+		{
+			name: "err update cluster",
+			mockInfraClient: func(ctx context.Context, t *testing.T, mock *mockInfa.MockProvider) *mockInfa.MockProvider {
+				return mock
+			},
+			mockControlPlaneClient: func(ctx context.Context, t *testing.T, mock *mockControlplane.MockProvider) *mockControlplane.MockProvider {
+				return mock
+			},
+			manifest: configMapManifest,
+			wantErr:  "cluster not found",
 		},
 		{
 			name: "err update infra manifests",
@@ -760,7 +928,8 @@ spec:
 			mockControlPlaneClient: func(ctx context.Context, t *testing.T, mock *mockControlplane.MockProvider) *mockControlplane.MockProvider {
 				return mock
 			},
-			wantErr: "failed to update manifests",
+			manifest: clusterManifest,
+			wantErr:  "failed to update manifests",
 		},
 		{
 			name: "err update controlplane manifests",
@@ -776,7 +945,8 @@ spec:
 					Return(nil, errors.New("failed to update manifests"))
 				return mock
 			},
-			wantErr: "failed to update manifests",
+			manifest: clusterManifest,
+			wantErr:  "failed to update manifests",
 		},
 	}
 
@@ -804,11 +974,207 @@ spec:
 
 			if tc.wantErr == "" {
 				assert.NoError(t, err)
-				assert.Equal(t, "echo 'hello'", manifest.PreRunCmd[0])
 				assert.NotNil(t, manifest)
+				assert.Equal(t, "echo 'hello'", manifest.PreRunCmd[0])
+				// separators are normalized and the Cluster is labelled and points at the fake control plane, documents
+				// are kept as is
+				wantCluster := strings.NewReplacer(
+					"test-cluster-control-plane", "fake-control-plane",
+					"metadata:\n", "metadata:\n  labels:\n    cluster.x-k8s.io/cluster-name: test-cluster\n",
+				).Replace(clusterManifest)
+				assert.Equal(t, strings.Replace(configMapManifest, "--- # leading comment", "---", 1)+wantCluster, manifest.ManifestFile.Content)
 			} else {
 				assert.EqualErrorf(t, err, tc.wantErr, "expected error message: %s", tc.wantErr)
 			}
 		})
 	}
 }
+
+func TestCreateTar(t *testing.T) {
+	t.Parallel()
+	type member struct {
+		mode  int64
+		uname string
+		gname string
+		body  string
+	}
+	type test struct {
+		name        string
+		input       []yaml.InitFile
+		wantFiles   []string
+		wantCmds    []string
+		wantMembers map[string]member
+		wantErr     string
+	}
+	tests := []test{
+		{
+			name: "success",
+			input: []yaml.InitFile{
+				{Path: "/etc/test.yaml", Content: "region: {{ ds.meta_data.region }}", Permissions: "0600", Owner: "k3s:admins"},
+				{Path: "/etc/encoded.txt", Content: "H4sIAAAAAAAA/ypJLS4BBAAA//8Mfn/YBAAAAA==", Encoding: "gz+b64", Owner: "k3s"},
+				{Path: "/etc/hosts", Content: "10.0.0.1 test\n", Append: true},
+				{Path: "/etc/remote.txt", Source: yaml.Source{URI: "https://example.com/remote.txt"}},
+			},
+			wantFiles: []string{"/etc/remote.txt", "/tmp/cloud-init-files.tgz"},
+			wantCmds: []string{
+				"tar --same-owner -C / -xpvf /tmp/cloud-init-files.tgz",
+				"tar -xf /tmp/cloud-init-files.tgz --to-command='xargs -0 cloud-init query -f > /$TAR_FILENAME' 'etc/test.yaml' 'tmp/cloud-init-append/etc/hosts'",
+				"cat '/tmp/cloud-init-append/etc/hosts' >> '/etc/hosts' && chmod 0644 '/etc/hosts' && chown root:root '/etc/hosts' && rm '/tmp/cloud-init-append/etc/hosts'",
+			},
+			wantMembers: map[string]member{
+				"etc/test.yaml":                   {mode: 0o600, uname: "k3s", gname: "admins", body: "region: {{ ds.meta_data.region }}"},
+				"etc/encoded.txt":                 {mode: 0o644, uname: "k3s", gname: "root", body: "test"},
+				"tmp/cloud-init-append/etc/hosts": {mode: 0o644, uname: "root", gname: "root", body: "10.0.0.1 test\n"},
+			},
+		},
+		{
+			name:    "err invalid encoding",
+			input:   []yaml.InitFile{{Path: "/etc/encoded.txt", Content: "not base64", Encoding: "b64"}},
+			wantErr: "could not decode base64 content of file /etc/encoded.txt: illegal base64 data at input byte 3",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			writeFiles, cmds, err := createTar(tc.input)
+			if tc.wantErr != "" {
+				assert.EqualErrorf(t, err, tc.wantErr, "expected error message: %s", tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantCmds, cmds)
+			var paths []string
+			for _, file := range writeFiles {
+				paths = append(paths, file.Path)
+			}
+			assert.Equal(t, tc.wantFiles, paths)
+
+			data, err := writeFiles[len(writeFiles)-1].DecodedContent()
+			assert.NoError(t, err)
+			gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+			assert.NoError(t, err)
+			tarReader := tar.NewReader(gzipReader)
+			members := map[string]member{}
+			for {
+				header, err := tarReader.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				assert.NoError(t, err)
+				body, err := io.ReadAll(tarReader)
+				assert.NoError(t, err)
+				members[header.Name] = member{mode: header.Mode, uname: header.Uname, gname: header.Gname, body: string(body)}
+			}
+			assert.Equal(t, tc.wantMembers, members)
+		})
+	}
+}
+
+func TestWriteBackendFiles(t *testing.T) {
+	t.Parallel()
+	// uploadFiles fakes a backend that moves the content of every file to a source
+	uploadFiles := func(_ context.Context, _ string, config *yaml.Config) ([]string, error) {
+		cmds := make([]string, len(config.WriteFiles))
+		for i, file := range config.WriteFiles {
+			cmds[i] = "install " + file.Path + " " + file.Content
+			config.WriteFiles[i].Content = ""
+			config.WriteFiles[i].Source = yaml.Source{URI: "https://backend.test" + file.Path, Headers: map[string]string{"Authorization": "Bearer test"}}
+		}
+		return cmds, nil
+	}
+	input := []yaml.InitFile{
+		{Path: "/tmp/manifest.yaml", Content: "name: \"{{ '{{ .Name }}' }}\""},
+		{Path: "/tmp/ccm.yaml", Content: "region: \"{{ ds.meta_data.region }}\"", Jinja: true},
+		{Path: "/tmp/encoded.txt", Content: "dGVzdA==", Encoding: "b64", Permissions: "0600"},
+		{Path: "/tmp/remote.txt", Source: yaml.Source{URI: "https://example.com/remote.txt"}},
+	}
+	sourceCmds := []string{
+		"printf '%s  %s\\n' a1a33454373325975f961b95ae6ef627f03e926fce293e6d8fc76228858e1ea7 '/tmp/manifest.yaml' | sha256sum -c --status - || " +
+			yaml.FetchScriptPath + " a1a33454373325975f961b95ae6ef627f03e926fce293e6d8fc76228858e1ea7 '/tmp/manifest.yaml' 'https://backend.test/tmp/manifest.yaml' -H 'Authorization: Bearer test' || exit 1",
+		"printf '%s  %s\\n' 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08 '/tmp/encoded.txt' | sha256sum -c --status - || " +
+			yaml.FetchScriptPath + " 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08 '/tmp/encoded.txt' 'https://backend.test/tmp/encoded.txt' -H 'Authorization: Bearer test' || exit 1",
+	}
+	sourceFiles := []yaml.InitFile{
+		{Path: "/tmp/manifest.yaml", Source: yaml.Source{URI: "https://backend.test/tmp/manifest.yaml", Headers: map[string]string{"Authorization": "Bearer test"}}},
+		{Path: "/tmp/ccm.yaml", Content: "region: \"{{ ds.meta_data.region }}\"", Jinja: true},
+		{Path: "/tmp/encoded.txt", Permissions: "0600", Source: yaml.Source{URI: "https://backend.test/tmp/encoded.txt", Headers: map[string]string{"Authorization": "Bearer test"}}},
+		{Path: "/tmp/remote.txt", Source: yaml.Source{URI: "https://example.com/remote.txt"}},
+		yaml.FetchScript(),
+	}
+	type test struct {
+		name         string
+		delivery     string
+		outputFormat string
+		wantCmds     []string
+		wantFiles    []yaml.InitFile
+		wantErr      string
+	}
+	tests := []test{
+		{
+			name:     "runcmd",
+			delivery: types.FileDeliveryRunCmd,
+			wantCmds: []string{
+				"install /tmp/manifest.yaml name: \"{{ '{{ .Name }}' }}\"",
+				"install /tmp/ccm.yaml region: \"{{ ds.meta_data.region }}\"",
+				"install /tmp/encoded.txt dGVzdA==",
+			},
+			wantFiles: []yaml.InitFile{
+				{Path: "/tmp/manifest.yaml"},
+				{Path: "/tmp/ccm.yaml", Jinja: true},
+				{Path: "/tmp/encoded.txt", Encoding: "b64", Permissions: "0600"},
+				{Path: "/tmp/remote.txt", Source: yaml.Source{URI: "https://example.com/remote.txt"}},
+				yaml.FetchScript(),
+			},
+		},
+		{
+			name:      "source",
+			delivery:  types.FileDeliverySource,
+			wantCmds:  sourceCmds,
+			wantFiles: sourceFiles,
+		},
+		{
+			name:         "ignition default",
+			outputFormat: types.OutputFormatIgnition,
+			wantCmds:     sourceCmds,
+			wantFiles:    sourceFiles,
+		},
+		{
+			name:         "err ignition runcmd",
+			delivery:     types.FileDeliveryRunCmd,
+			outputFormat: types.OutputFormatIgnition,
+			wantErr:      "file delivery runcmd requires cloud-init, use source with the ignition output format",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			ctrl := gomock.NewController(t)
+			backendMock := mockBackend.NewMockProvider(ctrl)
+			cloudConfig := yaml.Config{WriteFiles: slices.Clone(input)}
+			values := types.Values{ClusterName: "test-cluster", FileDelivery: tc.delivery, OutputFormat: tc.outputFormat}
+			if tc.wantErr != "" {
+				_, err := writeBackendFiles(ctx, &values, backendMock, &cloudConfig)
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			var uploaded []string
+			backendMock.EXPECT().
+				WriteFiles(ctx, "test-cluster", gomock.Any()).
+				DoAndReturn(func(ctx context.Context, clusterName string, config *yaml.Config) ([]string, error) {
+					for _, file := range config.WriteFiles {
+						uploaded = append(uploaded, file.Content)
+					}
+					return uploadFiles(ctx, clusterName, config)
+				})
+			cmds, err := writeBackendFiles(ctx, &values, backendMock, &cloudConfig)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantCmds, cmds)
+			assert.Equal(t, tc.wantFiles, cloudConfig.WriteFiles)
+			if tc.delivery == types.FileDeliverySource || tc.outputFormat == types.OutputFormatIgnition {
+				// files are uploaded as they are written to disk
+				assert.Equal(t, []string{"name: \"{{ .Name }}\"", "test"}, uploaded)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package cloudinit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"k8s.io/utils/ptr"
+
+	"capi-bootstrap/types"
+	capiYaml "capi-bootstrap/yaml"
+)
+
+const (
+	ignitionVersion = "3.4.0"
+	// runCmdScriptPath is the script the runcmds are written to when using Ignition.
+	runCmdScriptPath = "/var/lib/capi-bootstrap/runcmd.sh"
+	// runCmdUnitName is the systemd unit running runCmdScriptPath once on first boot.
+	runCmdUnitName = "capi-bootstrap-runcmd.service"
+)
+
+var runCmdUnit = fmt.Sprintf(`[Unit]
+Description=Run the capi-bootstrap commands
+Wants=network-online.target
+After=network-online.target
+ConditionPathExists=!%[1]s.done
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/bash %[1]s
+ExecStartPost=/usr/bin/touch %[1]s.done
+
+[Install]
+WantedBy=multi-user.target
+`, runCmdScriptPath)
+
+// ignitionConfig is the subset of the Ignition v3 config spec used for bootstrap machines.
+type ignitionConfig struct {
+	Ignition ignitionMeta    `json:"ignition"`
+	Storage  ignitionStorage `json:"storage"`
+	Systemd  ignitionSystemd `json:"systemd"`
+}
+
+type ignitionMeta struct {
+	Version string `json:"version"`
+}
+
+type ignitionStorage struct {
+	Files []ignitionFile `json:"files,omitempty"`
+}
+
+type ignitionFile struct {
+	Path      string             `json:"path"`
+	Overwrite *bool              `json:"overwrite,omitempty"`
+	User      *ignitionNodeOwner `json:"user,omitempty"`
+	Group     *ignitionNodeOwner `json:"group,omitempty"`
+	Mode      *int               `json:"mode,omitempty"`
+	Contents  *ignitionResource  `json:"contents,omitempty"`
+	Append    []ignitionResource `json:"append,omitempty"`
+}
+
+type ignitionNodeOwner struct {
+	Name string `json:"name"`
+}
+
+type ignitionResource struct {
+	Source      string               `json:"source"`
+	Compression string               `json:"compression,omitempty"`
+	HTTPHeaders []ignitionHTTPHeader `json:"httpHeaders,omitempty"`
+}
+
+type ignitionHTTPHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type ignitionSystemd struct {
+	Units []ignitionUnit `json:"units,omitempty"`
+}
+
+type ignitionUnit struct {
+	Name     string `json:"name"`
+	Enabled  *bool  `json:"enabled,omitempty"`
+	Contents string `json:"contents,omitempty"`
+}
+
+// MarshalUserData returns the user-data for a cloud-config in the requested output format.
+func MarshalUserData(cloudConfig *capiYaml.Config, outputFormat string) ([]byte, error) {
+	switch outputFormat {
+	case "", types.OutputFormatCloudConfig:
+		return MarshalCloudConfig(cloudConfig)
+	case types.OutputFormatIgnition:
+		return MarshalIgnition(cloudConfig)
+	case types.OutputFormatMIME:
+		return MarshalMIME(cloudConfig)
+	default:
+		return nil, fmt.Errorf("unsupported output format %q, options are: %s", outputFormat, strings.Join(types.OutputFormats, ", "))
+	}
+}
+
+// MarshalIgnition converts a cloud-config to an Ignition config, the write_files are written to storage.files and the
+// runcmds are run once by a systemd oneshot unit. Ignition does not render jinja, so escaped templates are unescaped and
+// content that relies on cloud-init instance data is rejected.
+func MarshalIgnition(cloudConfig *capiYaml.Config) ([]byte, error) {
+	config := ignitionConfig{
+		Ignition: ignitionMeta{Version: ignitionVersion},
+	}
+	for _, file := range cloudConfig.WriteFiles {
+		ignFile, err := toIgnitionFile(file)
+		if err != nil {
+			return nil, err
+		}
+		config.Storage.Files = append(config.Storage.Files, ignFile)
+	}
+
+	if len(cloudConfig.RunCmd) != 0 {
+		runCmds := make([]string, len(cloudConfig.RunCmd))
+		for i, cmd := range cloudConfig.RunCmd {
+			runCmds[i] = capiYaml.UnescapeJinja(cmd)
+			if err := checkCloudInitOnly("runcmd "+runCmds[i], runCmds[i]); err != nil {
+				return nil, err
+			}
+		}
+		script := "#!/bin/bash\n" + strings.Join(runCmds, "\n") + "\n"
+		config.Storage.Files = append(config.Storage.Files, ignitionFile{
+			Path:      runCmdScriptPath,
+			Overwrite: ptr.To(true),
+			Mode:      ptr.To(0o700),
+			Contents:  ptr.To(dataURL([]byte(script), "")),
+		})
+		config.Systemd.Units = append(config.Systemd.Units, ignitionUnit{
+			Name:     runCmdUnitName,
+			Enabled:  ptr.To(true),
+			Contents: runCmdUnit,
+		})
+	}
+
+	return json.Marshal(config)
+}
+
+func toIgnitionFile(file capiYaml.InitFile) (ignitionFile, error) {
+	mode, err := file.FileMode()
+	if err != nil {
+		return ignitionFile{}, err
+	}
+	ignFile := ignitionFile{
+		Path: file.Path,
+		Mode: ptr.To(int(mode & fs.ModePerm)),
+	}
+	if file.Owner != "" {
+		user, group, _ := strings.Cut(file.Owner, ":")
+		ignFile.User = &ignitionNodeOwner{Name: user}
+		if group != "" {
+			ignFile.Group = &ignitionNodeOwner{Name: group}
+		}
+	}
+
+	var resource ignitionResource
+	switch {
+	case file.Source.URI != "":
+		resource.Source = file.Source.URI
+		headerNames := make([]string, 0, len(file.Source.Headers))
+		for name := range file.Source.Headers {
+			headerNames = append(headerNames, name)
+		}
+		slices.Sort(headerNames)
+		for _, name := range headerNames {
+			resource.HTTPHeaders = append(resource.HTTPHeaders, ignitionHTTPHeader{Name: name, Value: file.Source.Headers[name]})
+		}
+	default:
+		content := []byte(file.Content)
+		if file.IsBase64() {
+			content, err = base64.StdEncoding.DecodeString(file.Content)
+			if err != nil {
+				return ignitionFile{}, fmt.Errorf("could not decode base64 content of file %s: %s", file.Path, err)
+			}
+		}
+		compression := ""
+		if file.IsGzip() {
+			compression = "gzip"
+		} else {
+			content = []byte(capiYaml.UnescapeJinja(string(content)))
+			if err := checkCloudInitOnly("file "+file.Path, string(content)); err != nil {
+				return ignitionFile{}, err
+			}
+		}
+		resource = dataURL(content, compression)
+	}
+
+	if file.Append {
+		ignFile.Append = []ignitionResource{resource}
+	} else {
+		ignFile.Overwrite = ptr.To(true)
+		ignFile.Contents = &resource
+	}
+	return ignFile, nil
+}
+
+// dataURL returns a resource with content embedded as a base64 data URL.
+func dataURL(content []byte, compression string) ignitionResource {
+	return ignitionResource{
+		Source:      "data:;base64," + base64.StdEncoding.EncodeToString(content),
+		Compression: compression,
+	}
+}
+
+// checkCloudInitOnly returns an error if content relies on cloud-init, which is not available on Ignition machines,
+// as it would be shipped unrendered.
+func checkCloudInitOnly(name, content string) error {
+	if strings.Contains(content, "{{ ds.") || strings.Contains(content, "{{ v1.") {
+		return fmt.Errorf("%s uses cloud-init instance data which is not rendered with Ignition", name)
+	}
+	if strings.Contains(content, "cloud-init query") {
+		return fmt.Errorf("%s uses cloud-init query which is not available with Ignition", name)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package cloudinit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"capi-bootstrap/types"
+	"capi-bootstrap/yaml"
+)
+
+func TestMarshalUserData(t *testing.T) {
+	t.Parallel()
+	type test struct {
+		name         string
+		input        yaml.Config
+		outputFormat string
+		want         string
+		wantErr      string
+	}
+	tests := []test{
+		{
+			name: "success cloud-config",
+			input: yaml.Config{
+				WriteFiles: []yaml.InitFile{{Path: "/tmp/test.txt", Content: "test"}},
+				RunCmd:     []string{"echo test"},
+			},
+			want: `## template: jinja
+#cloud-config
+
+write_files:
+    - path: /tmp/test.txt
+      content: test
+runcmd:
+    - echo test
+`,
+		},
+		{
+			name: "success ignition",
+			input: yaml.Config{
+				WriteFiles: []yaml.InitFile{
+					{Path: "/tmp/test.txt", Content: "name: \"{{ '{{ .Release.Name }}' }}\"", Owner: "core:core", Permissions: "0600"},
+					{Path: "/tmp/append.txt", Content: "dGVzdA==", Encoding: "b64", Append: true},
+					{Path: "/tmp/test.gz", Content: "H4sIAAAAAAAA/ypJLS4BBAAA//8Mfn/YBAAAAA==", Encoding: "gz+b64"},
+					{Path: "/tmp/remote.txt", Source: yaml.Source{URI: "https://example.com/remote.txt", Headers: map[string]string{"Authorization": "Bearer test"}}},
+				},
+				RunCmd: []string{"echo test"},
+			},
+			outputFormat: types.OutputFormatIgnition,
+			want: `{"ignition":{"version":"3.4.0"},"storage":{"files":[` +
+				`{"path":"/tmp/test.txt","overwrite":true,"user":{"name":"core"},"group":{"name":"core"},"mode":384,"contents":{"source":"data:;base64,bmFtZTogInt7IC5SZWxlYXNlLk5hbWUgfX0i"}},` +
+				`{"path":"/tmp/append.txt","mode":420,"append":[{"source":"data:;base64,dGVzdA=="}]},` +
+				`{"path":"/tmp/test.gz","overwrite":true,"mode":420,"contents":{"source":"data:;base64,H4sIAAAAAAAA/ypJLS4BBAAA//8Mfn/YBAAAAA==","compression":"gzip"}},` +
+				`{"path":"/tmp/remote.txt","overwrite":true,"mode":420,"contents":{"source":"https://example.com/remote.txt","httpHeaders":[{"name":"Authorization","value":"Bearer test"}]}},` +
+				`{"path":"/var/lib/capi-bootstrap/runcmd.sh","overwrite":true,"mode":448,"contents":{"source":"data:;base64,IyEvYmluL2Jhc2gKZWNobyB0ZXN0Cg=="}}]},` +
+				`"systemd":{"units":[{"name":"capi-bootstrap-runcmd.service","enabled":true,"contents":"[Unit]\nDescription=Run the capi-bootstrap commands\n` +
+				`Wants=network-online.target\nAfter=network-online.target\nConditionPathExists=!/var/lib/capi-bootstrap/runcmd.sh.done\n\n` +
+				`[Service]\nType=oneshot\nRemainAfterExit=yes\nExecStart=/bin/bash /var/lib/capi-bootstrap/runcmd.sh\n` +
+				`ExecStartPost=/usr/bin/touch /var/lib/capi-bootstrap/runcmd.sh.done\n\n[Install]\nWantedBy=multi-user.target\n"}]}}`,
+		},
+		{
+			name: "err invalid permissions",
+			input: yaml.Config{
+				WriteFiles: []yaml.InitFile{{Path: "/tmp/test.txt", Content: "test", Permissions: "rw"}},
+			},
+			outputFormat: types.OutputFormatIgnition,
+			wantErr:      "invalid permissions \"rw\" for file /tmp/test.txt: strconv.ParseUint: parsing \"rw\": invalid syntax",
+		},
+		{
+			name: "err ignition instance data",
+			input: yaml.Config{
+				WriteFiles: []yaml.InitFile{{Path: "/tmp/ccm.yaml", Content: "region: \"{{ ds.meta_data.region }}\"", Jinja: true}},
+			},
+			outputFormat: types.OutputFormatIgnition,
+			wantErr:      "file /tmp/ccm.yaml uses cloud-init instance data which is not rendered with Ignition",
+		},
+		{
+			name: "err ignition cloud-init query",
+			input: yaml.Config{
+				RunCmd: []string{"cloud-init query -f /tmp/test.tmpl > /tmp/test"},
+			},
+			outputFormat: types.OutputFormatIgnition,
+			wantErr:      "runcmd cloud-init query -f /tmp/test.tmpl > /tmp/test uses cloud-init query which is not available with Ignition",
+		},
+		{
+			name:         "err unsupported format",
+			outputFormat: "butane",
+			wantErr:      "unsupported output format \"butane\", options are: cloud-config, ignition, mime",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			actual, err := MarshalUserData(&tc.input, tc.outputFormat)
+			if tc.wantErr != "" {
+				assert.EqualErrorf(t, err, tc.wantErr, "expected error message: %s", tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.want, string(actual))
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package cloudinit
 
 import (
+	"bytes"
 	"io"
 	"io/fs"
 	"os"
@@ -35,3 +36,12 @@ func (f IoFile) Read(bytes []byte) (int, error) {
 func (IoFile) Close() error {
 	return nil
 }
+
+// BytesFS implements the FS interface but only returns a single file with its contents, unlike IoFS it can be read
+// more than once.
+type BytesFS []byte
+
+// Open returns a File containing the contents of the BytesFS.
+func (b BytesFS) Open(_ string) (fs.File, error) {
+	return IoFile{contents: bytes.NewReader(b)}, nil
+}
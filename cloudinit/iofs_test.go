@@ -1,6 +1,7 @@
 package cloudinit
 
 import (
+	"io/fs"
 	"os"
 	"testing"
 
@@ -29,3 +30,13 @@ func TestIoFS(t *testing.T) {
 	assert.NoError(t, err, "Failed to read file content")
 	assert.Equal(t, string(expectedContent), string(actualContent), "File content does not match expected content")
 }
+
+func TestBytesFS(t *testing.T) {
+	testFS := BytesFS("test input")
+	// the file can be read again, unlike stdin
+	for range 2 {
+		content, err := fs.ReadFile(testFS, "manifest")
+		assert.NoError(t, err, "Failed to read file content")
+		assert.Equal(t, "test input", string(content), "File content does not match expected content")
+	}
+}
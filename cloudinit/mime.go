@@ -0,0 +1,100 @@
+package cloudinit
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+
+	"capi-bootstrap/types"
+	capiYaml "capi-bootstrap/yaml"
+)
+
+const (
+	mimeBoundary = "==CAPI-BOOTSTRAP-BOUNDARY=="
+	// defaultMergeHow appends lists and recursively merges dicts, so extra cloud-configs add to the generated one
+	defaultMergeHow = `
+merge_how:
+- name: list
+  settings: [append]
+- name: dict
+  settings: [recurse_array]
+`
+)
+
+// generateParts returns the MIME parts for the init script, the commands run after it and the user defined parts.
+// cloud-init runs shell script parts in order of their filename after runcmd, so they are named to sort after it.
+func generateParts(values *types.Values, initScript *capiYaml.InitFile, postRunCmds []string) []capiYaml.Part {
+	parts := []capiYaml.Part{{
+		Filename:    "runcmd-init-cluster.sh",
+		ContentType: "text/x-shellscript",
+		Content:     initScript.Content,
+	}}
+	if len(postRunCmds) != 0 {
+		parts = append(parts, capiYaml.Part{
+			Filename:    "runcmd-post-init.sh",
+			ContentType: "text/jinja2",
+			Content:     "## template: jinja\n#!/bin/bash\n" + strings.Join(postRunCmds, "\n") + "\n",
+		})
+	}
+	for i, cloudConfig := range values.CloudInit.CloudConfigParts {
+		if !strings.Contains(cloudConfig, "merge_how") {
+			cloudConfig = strings.TrimRight(cloudConfig, "\n") + "\n" + defaultMergeHow
+		}
+		parts = append(parts, capiYaml.Part{
+			Filename:    fmt.Sprintf("cloud-config-%d.yaml", i),
+			ContentType: "text/cloud-config",
+			Content:     cloudConfig,
+		})
+	}
+	for i, boothook := range values.CloudInit.Boothooks {
+		parts = append(parts, capiYaml.Part{
+			Filename:    fmt.Sprintf("boothook-%d.sh", i),
+			ContentType: "text/cloud-boothook",
+			Content:     boothook,
+		})
+	}
+	return parts
+}
+
+// MarshalMIME returns multi-part MIME user-data with the cloud-config as the first part followed by its Parts.
+func MarshalMIME(cloudConfig *capiYaml.Config) ([]byte, error) {
+	rawCloudConfig, err := MarshalCloudConfig(cloudConfig)
+	if err != nil {
+		return nil, err
+	}
+	parts := append([]capiYaml.Part{{
+		Filename:    "cloud-config.yaml",
+		ContentType: "text/jinja2",
+		Content:     string(rawCloudConfig),
+	}}, cloudConfig.Parts...)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.SetBoundary(mimeBoundary); err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		if strings.Contains(part.Content, mimeBoundary) {
+			return nil, fmt.Errorf("part %s contains the MIME boundary %s", part.Filename, mimeBoundary)
+		}
+		partWriter, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":        {fmt.Sprintf("%s; charset=\"utf-8\"", part.ContentType)},
+			"Content-Disposition": {fmt.Sprintf("attachment; filename=%q", part.Filename)},
+			"Mime-Version":        {"1.0"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not create part %s: %s", part.Filename, err)
+		}
+		if _, err := partWriter.Write([]byte(part.Content)); err != nil {
+			return nil, fmt.Errorf("could not write part %s: %s", part.Filename, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	header := fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q\r\nMIME-Version: 1.0\r\n\r\n", mimeBoundary)
+	return append([]byte(header), body.Bytes()...), nil
+}
@@ -0,0 +1,64 @@
+package cloudinit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"capi-bootstrap/types"
+	"capi-bootstrap/yaml"
+)
+
+func TestMarshalMIME(t *testing.T) {
+	t.Parallel()
+	type test struct {
+		name       string
+		values     types.Values
+		postCmds   []string
+		initScript string
+		want       string
+		wantErr    string
+	}
+	tests := []test{
+		{
+			name:     "success",
+			values:   types.Values{CloudInit: types.CloudInit{CloudConfigParts: []string{"#cloud-config\npackages: [auditd]\n"}, Boothooks: []string{"#!/bin/sh\necho boothook\n"}}},
+			postCmds: []string{"echo done"},
+			want: "Content-Type: multipart/mixed; boundary=\"==CAPI-BOOTSTRAP-BOUNDARY==\"\r\nMIME-Version: 1.0\r\n\r\n" +
+				"--==CAPI-BOOTSTRAP-BOUNDARY==\r\nContent-Disposition: attachment; filename=\"cloud-config.yaml\"\r\nContent-Type: text/jinja2; charset=\"utf-8\"\r\nMime-Version: 1.0\r\n\r\n" +
+				"## template: jinja\n#cloud-config\n\nwrite_files:\n    - path: /tmp/test.txt\n      content: test\nruncmd:\n    - echo test\n" +
+				"\r\n--==CAPI-BOOTSTRAP-BOUNDARY==\r\nContent-Disposition: attachment; filename=\"runcmd-init-cluster.sh\"\r\nContent-Type: text/x-shellscript; charset=\"utf-8\"\r\nMime-Version: 1.0\r\n\r\n" +
+				"#!/bin/bash\necho init\n" +
+				"\r\n--==CAPI-BOOTSTRAP-BOUNDARY==\r\nContent-Disposition: attachment; filename=\"runcmd-post-init.sh\"\r\nContent-Type: text/jinja2; charset=\"utf-8\"\r\nMime-Version: 1.0\r\n\r\n" +
+				"## template: jinja\n#!/bin/bash\necho done\n" +
+				"\r\n--==CAPI-BOOTSTRAP-BOUNDARY==\r\nContent-Disposition: attachment; filename=\"cloud-config-0.yaml\"\r\nContent-Type: text/cloud-config; charset=\"utf-8\"\r\nMime-Version: 1.0\r\n\r\n" +
+				"#cloud-config\npackages: [auditd]\n" + defaultMergeHow +
+				"\r\n--==CAPI-BOOTSTRAP-BOUNDARY==\r\nContent-Disposition: attachment; filename=\"boothook-0.sh\"\r\nContent-Type: text/cloud-boothook; charset=\"utf-8\"\r\nMime-Version: 1.0\r\n\r\n" +
+				"#!/bin/sh\necho boothook\n" +
+				"\r\n--==CAPI-BOOTSTRAP-BOUNDARY==--\r\n",
+		},
+		{
+			name:       "err boundary in part",
+			initScript: mimeBoundary,
+			wantErr:    "part runcmd-init-cluster.sh contains the MIME boundary ==CAPI-BOOTSTRAP-BOUNDARY==",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			initScript := &yaml.InitFile{Path: "/tmp/init-cluster.sh", Content: "#!/bin/bash\necho init\n" + tc.initScript}
+			cloudConfig := yaml.Config{
+				WriteFiles: []yaml.InitFile{{Path: "/tmp/test.txt", Content: "test"}},
+				RunCmd:     []string{"echo test"},
+				Parts:      generateParts(&tc.values, initScript, tc.postCmds),
+			}
+			actual, err := MarshalUserData(&cloudConfig, types.OutputFormatMIME)
+			if tc.wantErr != "" {
+				assert.EqualErrorf(t, err, tc.wantErr, "expected error message: %s", tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.want, string(actual))
+			}
+		})
+	}
+}
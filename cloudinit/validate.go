@@ -0,0 +1,308 @@
+package cloudinit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	capiYaml "capi-bootstrap/yaml"
+)
+
+// jinjaRoots are the top level keys of the cloud-init instance data that can be used in jinja expressions.
+var jinjaRoots = []string{"ds", "v1", "v2", "merged_cfg", "merged_system_cfg", "sys_info"}
+
+// jsonSchema is the subset of JSON schema used by the embedded cloud-init schema.
+type jsonSchema struct {
+	Type                 string                 `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *additionalProperties  `json:"additionalProperties"`
+	Required             []string               `json:"required"`
+	Items                *jsonSchema            `json:"items"`
+	OneOf                []*jsonSchema          `json:"oneOf"`
+	Enum                 []any                  `json:"enum"`
+	Pattern              string                 `json:"pattern"`
+	MinLength            int                    `json:"minLength"`
+}
+
+// additionalProperties is either a boolean or a schema for all properties that are not listed.
+type additionalProperties struct {
+	Allowed bool
+	Schema  *jsonSchema
+}
+
+func (a *additionalProperties) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	return json.Unmarshal(data, &a.Schema)
+}
+
+// ValidateCloudConfig validates a cloud-config against the embedded cloud-init schema, errors for write_files name the
+// path of the file.
+func ValidateCloudConfig(cloudConfig *capiYaml.Config) error {
+	rawCloudConfig, err := MarshalCloudConfig(cloudConfig)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, schemaErr := range validateCloudConfigSchema(rawCloudConfig) {
+		if len(schemaErr.path) > 1 && schemaErr.path[0] == "write_files" {
+			if i, ok := schemaErr.path[1].(int); ok && i < len(cloudConfig.WriteFiles) {
+				errs = append(errs, fmt.Errorf("invalid cloud-config for file %s: %s", cloudConfig.WriteFiles[i].Path, schemaErr))
+				continue
+			}
+		}
+		errs = append(errs, fmt.Errorf("invalid cloud-config: %s", schemaErr))
+	}
+	for i, part := range cloudConfig.Parts {
+		if part.ContentType != "text/cloud-config" {
+			continue
+		}
+		for _, schemaErr := range validateCloudConfigSchema([]byte(part.Content)) {
+			errs = append(errs, fmt.Errorf("invalid cloud-config part %d %s: %s", i, part.Filename, schemaErr))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateJinja checks that every unencoded file and command only uses jinja that cloud-init can render, '{{' that
+// is not meant for cloud-init has to be escaped as "{{ '{{' }}".
+func ValidateJinja(cloudConfig *capiYaml.Config) error {
+	var errs []error
+	for _, file := range cloudConfig.WriteFiles {
+		if file.Encoding != "" && file.Encoding != "text/plain" {
+			continue
+		}
+		if err := checkJinja(file.Content); err != nil {
+			errs = append(errs, fmt.Errorf("file %s: %s", file.Path, err))
+		}
+	}
+	for i, cmd := range cloudConfig.RunCmd {
+		if err := checkJinja(cmd); err != nil {
+			errs = append(errs, fmt.Errorf("runcmd %d: %s", i, err))
+		}
+	}
+	for _, part := range cloudConfig.Parts {
+		if part.ContentType != "text/jinja2" {
+			continue
+		}
+		if err := checkJinja(part.Content); err != nil {
+			errs = append(errs, fmt.Errorf("part %s: %s", part.Filename, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkJinja returns an error with the offset of the first jinja expression, statement or comment in content that
+// is not terminated or does not reference instance data.
+func checkJinja(content string) error {
+	for offset := 0; offset < len(content)-1; offset++ {
+		if content[offset] != '{' {
+			continue
+		}
+		var end string
+		switch content[offset+1] {
+		case '{':
+			end = "}}"
+		case '%':
+			end = "%}"
+		case '#':
+			end = "#}"
+		default:
+			continue
+		}
+		start := offset + 2
+		exprEnd := jinjaEnd(content[start:], end)
+		if exprEnd < 0 {
+			return fmt.Errorf("unterminated jinja %q at offset %d (line %d)", content[offset:offset+2], offset, lineAt(content, offset))
+		}
+		expr := strings.TrimSpace(content[start : start+exprEnd])
+		if end == "}}" && !isInstanceDataExpr(expr) {
+			return fmt.Errorf("jinja expression %q at offset %d (line %d) does not reference instance data, escape it as \"{{ '{{' }}\"", "{{ "+expr+" }}", offset, lineAt(content, offset))
+		}
+		offset = start + exprEnd + len(end) - 1
+	}
+	return nil
+}
+
+// jinjaEnd returns the index of end in content, skipping over quoted strings.
+func jinjaEnd(content string, end string) int {
+	var quote byte
+	for i := 0; i < len(content); i++ {
+		switch {
+		case quote != 0:
+			if content[i] == quote {
+				quote = 0
+			}
+		case content[i] == '\'' || content[i] == '"':
+			quote = content[i]
+		case strings.HasPrefix(content[i:], end):
+			return i
+		}
+	}
+	return -1
+}
+
+func isInstanceDataExpr(expr string) bool {
+	if strings.HasPrefix(expr, "'") || strings.HasPrefix(expr, "\"") {
+		return true
+	}
+	for _, root := range jinjaRoots {
+		if expr == root || strings.HasPrefix(expr, root+".") || strings.HasPrefix(expr, root+"[") || strings.HasPrefix(expr, root+" ") || strings.HasPrefix(expr, root+"|") {
+			return true
+		}
+	}
+	return false
+}
+
+func lineAt(content string, offset int) int {
+	return strings.Count(content[:offset], "\n") + 1
+}
+
+type schemaError struct {
+	path    []any
+	message string
+}
+
+func (e schemaError) Error() string {
+	if len(e.path) == 0 {
+		return e.message
+	}
+	var b strings.Builder
+	for _, p := range e.path {
+		switch p := p.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", p)
+		default:
+			if b.Len() != 0 {
+				b.WriteString(".")
+			}
+			fmt.Fprintf(&b, "%s", p)
+		}
+	}
+	return b.String() + ": " + e.message
+}
+
+func validateCloudConfigSchema(rawCloudConfig []byte) []schemaError {
+	var schema jsonSchema
+	rawSchema, err := files.ReadFile(path.Join("files", "cloud-config-schema.json"))
+	if err != nil {
+		return []schemaError{{message: fmt.Sprintf("could not read schema: %s", err)}}
+	}
+	if err := json.Unmarshal(rawSchema, &schema); err != nil {
+		return []schemaError{{message: fmt.Sprintf("could not parse schema: %s", err)}}
+	}
+
+	var document any
+	if err := yaml.Unmarshal(rawCloudConfig, &document); err != nil {
+		return []schemaError{{message: fmt.Sprintf("could not parse yaml: %s", err)}}
+	}
+	return schema.validate(nil, document)
+}
+
+func (s *jsonSchema) validate(fieldPath []any, value any) []schemaError {
+	newError := func(format string, args ...any) []schemaError {
+		return []schemaError{{path: fieldPath, message: fmt.Sprintf(format, args...)}}
+	}
+	if len(s.OneOf) != 0 {
+		for _, option := range s.OneOf {
+			if len(option.validate(fieldPath, value)) == 0 {
+				return nil
+			}
+		}
+		return newError("does not match any of the allowed types")
+	}
+	if s.Type != "" && jsonType(value) != s.Type {
+		return newError("expected %s but got %s", s.Type, jsonType(value))
+	}
+	if len(s.Enum) != 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return newError("%v is not one of %v", value, s.Enum)
+		}
+	}
+
+	var errs []schemaError
+	switch value := value.(type) {
+	case string:
+		if value == "" && s.MinLength > 0 {
+			return newError("must not be empty")
+		}
+		if len(value) < s.MinLength {
+			return newError("must be at least %d characters", s.MinLength)
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(value) {
+			return newError("%q does not match %s", value, s.Pattern)
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range value {
+				errs = append(errs, s.Items.validate(appendPath(fieldPath, i), item)...)
+			}
+		}
+	case map[string]any:
+		for _, required := range s.Required {
+			if _, ok := value[required]; !ok {
+				errs = append(errs, newError("missing required property %s", required)...)
+			}
+		}
+		for _, key := range sortedKeys(value) {
+			propertySchema, ok := s.Properties[key]
+			switch {
+			case ok:
+				errs = append(errs, propertySchema.validate(appendPath(fieldPath, key), value[key])...)
+			case s.AdditionalProperties == nil:
+			case s.AdditionalProperties.Schema != nil:
+				errs = append(errs, s.AdditionalProperties.Schema.validate(appendPath(fieldPath, key), value[key])...)
+			case !s.AdditionalProperties.Allowed:
+				errs = append(errs, newError("unknown property %s", key)...)
+			}
+		}
+	}
+	return errs
+}
+
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func appendPath(fieldPath []any, element any) []any {
+	return append(append([]any{}, fieldPath...), element)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,123 @@
+package cloudinit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"capi-bootstrap/yaml"
+)
+
+func TestValidateJinja(t *testing.T) {
+	t.Parallel()
+	type test struct {
+		name    string
+		input   yaml.Config
+		wantErr string
+	}
+	tests := []test{
+		{
+			name: "success",
+			input: yaml.Config{
+				WriteFiles: []yaml.InitFile{
+					{Path: "/tmp/region.yaml", Content: "region: \"{{ ds.meta_data.region }}\"\nid: {{ v1.instance_id }}\n"},
+					{Path: "/tmp/escaped.yaml", Content: "name: \"{{ '{{ .Name }}' }}\""},
+					{Path: "/tmp/statement.sh", Content: "{% if v1.region == 'us-ord' %}echo ord{% endif %}"},
+					{Path: "/tmp/encoded.sh", Content: "JHsjYXJyW0BdfQ==", Encoding: "b64"},
+				},
+				RunCmd: []string{"echo {{ ds.meta_data.id }}"},
+			},
+		},
+		{
+			name: "err stray expression",
+			input: yaml.Config{
+				WriteFiles: []yaml.InitFile{{Path: "/tmp/template.yaml", Content: "kind: ConfigMap\ndata:\n  name: {{ .Name }}\n"}},
+			},
+			wantErr: "file /tmp/template.yaml: jinja expression \"{{ .Name }}\" at offset 30 (line 3) does not reference instance data, escape it as \"{{ '{{' }}\"",
+		},
+		{
+			name: "err unterminated comment",
+			input: yaml.Config{
+				RunCmd: []string{"echo ok", "echo ${#ARGS[@]}"},
+			},
+			wantErr: "runcmd 1: unterminated jinja \"{#\" at offset 6 (line 1)",
+		},
+		{
+			name: "err unterminated expression in part",
+			input: yaml.Config{
+				Parts: []yaml.Part{{Filename: "runcmd-post-init.sh", ContentType: "text/jinja2", Content: "## template: jinja\n#!/bin/bash\necho {{ ds.meta_data.id\n"}},
+			},
+			wantErr: "part runcmd-post-init.sh: unterminated jinja \"{{\" at offset 36 (line 3)",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateJinja(&tc.input)
+			if tc.wantErr != "" {
+				assert.EqualErrorf(t, err, tc.wantErr, "expected error message: %s", tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateCloudConfig(t *testing.T) {
+	t.Parallel()
+	type test struct {
+		name    string
+		input   yaml.Config
+		wantErr string
+	}
+	tests := []test{
+		{
+			name: "success",
+			input: yaml.Config{
+				WriteFiles: []yaml.InitFile{
+					{Path: "/tmp/test.txt", Content: "test", Permissions: "0600", Owner: "root:root"},
+					{Path: "/tmp/remote.txt", Source: yaml.Source{URI: "https://example.com/remote.txt", Headers: map[string]string{"Authorization": "Bearer test"}}},
+				},
+				RunCmd: []string{"echo test"},
+				Parts:  []yaml.Part{{Filename: "cloud-config-0.yaml", ContentType: "text/cloud-config", Content: "#cloud-config\npackages: [auditd]\nmerge_how: list(append)+dict(recurse_array)+str()\n"}},
+			},
+		},
+		{
+			name: "err invalid file",
+			input: yaml.Config{
+				WriteFiles: []yaml.InitFile{
+					{Path: "/tmp/test.txt", Content: "test"},
+					{Path: "/tmp/invalid.txt", Content: "test", Permissions: "rw-r--r--", Encoding: "zip"},
+				},
+			},
+			wantErr: "invalid cloud-config for file /tmp/invalid.txt: write_files[1].encoding: zip is not one of [gz gzip gz+base64 gzip+base64 gz+b64 gzip+b64 b64 base64 text/plain]\n" +
+				"invalid cloud-config for file /tmp/invalid.txt: write_files[1].permissions: \"rw-r--r--\" does not match ^0?o?[0-7]{3,4}$",
+		},
+		{
+			name: "err missing path",
+			input: yaml.Config{
+				WriteFiles: []yaml.InitFile{{Content: "test"}},
+			},
+			wantErr: "invalid cloud-config for file : write_files[0].path: must not be empty",
+		},
+		{
+			name: "err invalid part",
+			input: yaml.Config{
+				Parts: []yaml.Part{{Filename: "cloud-config-0.yaml", ContentType: "text/cloud-config", Content: "#cloud-config\nruncmd: echo test\nmerge_how:\n- name: lists\n  settings: [append]\n"}},
+			},
+			wantErr: "invalid cloud-config part 0 cloud-config-0.yaml: merge_how: does not match any of the allowed types\n" +
+				"invalid cloud-config part 0 cloud-config-0.yaml: runcmd: expected array but got string",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateCloudConfig(&tc.input)
+			if tc.wantErr != "" {
+				assert.EqualErrorf(t, err, tc.wantErr, "expected error message: %s", tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
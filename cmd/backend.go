@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var backendCmd = &cobra.Command{
+	Use:   "backend",
+	Short: "manage the files stored in a backend provider",
+	Long:  ``,
+}
+
+func init() {
+	rootCmd.AddCommand(backendCmd)
+}
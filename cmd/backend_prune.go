@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+
+	"capi-bootstrap/providers/backend"
+)
+
+var backendPruneCmd = &cobra.Command{
+	Use:   "prune [cluster name...]",
+	Short: "delete the bootstrap files of clusters from a backend",
+	Long: `delete the files uploaded for the bootstrap machine of clusters, which include the CA keys and the bootstrap
+token. The cluster state is kept, use --all for every cluster in the backend. The github backend only removes the
+files from the head of its branch, they stay in the git history.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		all, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			return err
+		}
+		if all == (len(args) != 0) {
+			return errors.New("please specify cluster names or --all")
+		}
+		return nil
+	},
+	RunE: runBackendPrune,
+}
+
+func init() {
+	backendPruneCmd.Flags().Bool("all", false,
+		"prune the bootstrap files of all clusters in the backend")
+	backendCmd.AddCommand(backendPruneCmd)
+}
+
+func runBackendPrune(cmd *cobra.Command, clusterNames []string) error {
+	ctx := cmd.Context()
+
+	backendProvider := backend.NewProvider(clusterOpts.backend)
+	if backendProvider == nil {
+		return errors.New("backend provider not specified, options are: " + strings.Join(backend.ListProviders(), ","))
+	}
+	if err := backendProvider.PreCmd(ctx, ""); err != nil {
+		return err
+	}
+	return pruneClusters(ctx, backendProvider, clusterNames)
+}
+
+// pruneClusters purges the files of clusterNames from backendProvider, or of all its clusters if none are given.
+func pruneClusters(ctx context.Context, backendProvider backend.Provider, clusterNames []string) error {
+	if len(clusterNames) == 0 {
+		clusters, err := backendProvider.ListClusters(ctx)
+		if err != nil {
+			return err
+		}
+		for name := range clusters {
+			clusterNames = append(clusterNames, name)
+		}
+		slices.Sort(clusterNames)
+	}
+
+	for _, clusterName := range clusterNames {
+		if err := backendProvider.PurgeFiles(ctx, clusterName); err != nil {
+			return fmt.Errorf("could not purge files of cluster %s: %s", clusterName, err)
+		}
+		klog.Infof("purged the bootstrap files of cluster %s", clusterName)
+	}
+	return nil
+}
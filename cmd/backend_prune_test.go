@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
+
+	mockBackend "capi-bootstrap/providers/backend/mock"
+)
+
+func TestBackendPruneArgs(t *testing.T) {
+	type test struct {
+		name    string
+		all     bool
+		args    []string
+		wantErr string
+	}
+	tests := []test{
+		{name: "success clusters", args: []string{"test-cluster"}},
+		{name: "success all", all: true},
+		{name: "err none", wantErr: "please specify cluster names or --all"},
+		{name: "err clusters and all", all: true, args: []string{"test-cluster"}, wantErr: "please specify cluster names or --all"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cmd := &cobra.Command{}
+			cmd.Flags().Bool("all", tc.all, "")
+			err := backendPruneCmd.Args(cmd, tc.args)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPruneClusters(t *testing.T) {
+	type test struct {
+		name         string
+		clusterNames []string
+		mockBackend  func(ctx context.Context, mock *mockBackend.MockProvider)
+		wantErr      string
+	}
+	tests := []test{
+		{
+			name:         "success clusters",
+			clusterNames: []string{"test-cluster"},
+			mockBackend: func(ctx context.Context, mock *mockBackend.MockProvider) {
+				mock.EXPECT().PurgeFiles(ctx, "test-cluster").Return(nil)
+			},
+		},
+		{
+			name: "success all",
+			mockBackend: func(ctx context.Context, mock *mockBackend.MockProvider) {
+				mock.EXPECT().ListClusters(ctx).Return(map[string]*v1.Config{"test-cluster-b": {}, "test-cluster-a": {}}, nil)
+				gomock.InOrder(
+					mock.EXPECT().PurgeFiles(ctx, "test-cluster-a").Return(nil),
+					mock.EXPECT().PurgeFiles(ctx, "test-cluster-b").Return(nil),
+				)
+			},
+		},
+		{
+			name: "err list clusters",
+			mockBackend: func(ctx context.Context, mock *mockBackend.MockProvider) {
+				mock.EXPECT().ListClusters(ctx).Return(nil, errors.New("access denied"))
+			},
+			wantErr: "access denied",
+		},
+		{
+			name:         "err purge",
+			clusterNames: []string{"test-cluster-a", "test-cluster-b"},
+			mockBackend: func(ctx context.Context, mock *mockBackend.MockProvider) {
+				mock.EXPECT().PurgeFiles(ctx, "test-cluster-a").Return(errors.New("access denied"))
+			},
+			wantErr: "could not purge files of cluster test-cluster-a: access denied",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			ctrl := gomock.NewController(t)
+			backendProvider := mockBackend.NewMockProvider(ctrl)
+			tc.mockBackend(ctx, backendProvider)
+			err := pruneClusters(ctx, backendProvider, tc.clusterNames)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -1,13 +1,24 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
-	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 
 	"capi-bootstrap/cloudinit"
@@ -16,6 +27,7 @@ import (
 	"capi-bootstrap/providers/infrastructure"
 	"capi-bootstrap/state"
 	"capi-bootstrap/types"
+	"capi-bootstrap/utils"
 	capiYaml "capi-bootstrap/yaml"
 )
 
@@ -38,85 +50,132 @@ type clusterOptions struct {
 	workerMachineCount       int64
 
 	url string
+
+	debug            bool
+	runCmds          []string
+	writeFiles       []string
+	cloudConfigParts []string
+	boothooks        []string
+
+	addons []string
+
+	certificateAuthorities []string
+	bootstrapTokenFile     string
+	bootstrapTokenSecret   string
+
+	componentVersions map[string]string
+
+	outputFormat  string
+	tarWriteFiles bool
+	fileDelivery  string
+
+	wait        bool
+	waitTimeout time.Duration
+	keepFiles   bool
 }
 
 var clusterOpts = &clusterOptions{}
 
 func init() {
-	clusterCmd.Flags().StringVarP(&clusterOpts.manifest, "manifest", "m", "",
-		"The file containing cluster manifest to use for bootstrap cluster")
+	addClusterFlags(clusterCmd.Flags())
 
-	clusterCmd.Flags().StringVar(&clusterOpts.kubernetesVersion, "kubernetes-version", "",
-		"The Kubernetes version to use for the workload cluster. If unspecified, the value from OS environment variables or the $XDG_CONFIG_HOME/cluster-api/clusterctl.yaml config file will be used.")
+	// flags for the wait phase
+	clusterCmd.Flags().BoolVar(&clusterOpts.wait, "wait", true,
+		"Wait until the bootstrap machine has created the cluster, then delete the files uploaded to the backend. "+
+			"With --wait=false the files, which include the CA keys, are left in the backend until they are deleted with backend prune.")
+	clusterCmd.Flags().DurationVar(&clusterOpts.waitTimeout, "wait-timeout", 30*time.Minute,
+		"How long to wait for the bootstrap machine to create the cluster.")
+	clusterCmd.Flags().BoolVar(&clusterOpts.keepFiles, "keep-files", false,
+		"Keep the files uploaded to the backend after waiting, they can be deleted later with backend prune.")
 
-	clusterCmd.Flags().Int64Var(&clusterOpts.controlPlaneMachineCount, "control-plane-machine-count", 1,
-		"The number of control plane machines for the workload cluster.")
-	// Remove default from hard coded text if the default is ever changed from 0 since cobra would then add it
-	clusterCmd.Flags().Int64Var(&clusterOpts.workerMachineCount, "worker-machine-count", 0,
-		"The number of worker machines for the workload cluster. (default 0)")
+	// flags for the config map source
+	rootCmd.AddCommand(clusterCmd)
+}
+
+// addClusterFlags adds the flags used to build the values of a cluster.
+func addClusterFlags(flags *pflag.FlagSet) {
+	addManifestFlags(flags)
 
 	// flags for the repository source
-	clusterCmd.Flags().StringVarP(&clusterOpts.infrastructure, "infrastructure", "i", "",
+	flags.StringVarP(&clusterOpts.infrastructure, "infrastructure", "i", "",
 		"The infrastructure provider to read the workload cluster template from. If unspecified, the default infrastructure provider will be used.")
-	clusterCmd.Flags().StringVarP(&clusterOpts.controlPlane, "controlplane", "c", "",
+	flags.StringVarP(&clusterOpts.controlPlane, "controlplane", "c", "",
 		"The control plane provider to use for this cluster.")
-	clusterCmd.Flags().StringVarP(&clusterOpts.capi, "capi", "", "",
+	flags.StringVarP(&clusterOpts.capi, "capi", "", "",
 		"The CAPI provider configuration that should be used.")
 
-	// flags for the url source
-	clusterCmd.Flags().StringVar(&clusterOpts.url, "from", "",
-		"The URL to read the workload cluster template from. If unspecified, the infrastructure provider repository URL will be used. If set to '-', the workload cluster template is read from stdin.")
+	// flags for the cloud-init additions
+	flags.BoolVar(&clusterOpts.debug, "debug", false,
+		"Install debugging tools (k9s and shell helpers) on the bootstrap machine.")
+	flags.StringArrayVar(&clusterOpts.runCmds, "runcmd", nil,
+		"An extra command to run on the bootstrap machine after the cluster has been initialized. Can be specified multiple times.")
+	flags.StringArrayVar(&clusterOpts.writeFiles, "write-file", nil,
+		"An extra file to write to the bootstrap machine in the form <remote path>=<local path>. Can be specified multiple times.")
+	flags.StringArrayVar(&clusterOpts.cloudConfigParts, "cloud-config-part", nil,
+		"A local cloud-config file to merge with the generated cloud-config, this requires the mime output format. Can be specified multiple times.")
+	flags.StringArrayVar(&clusterOpts.boothooks, "boothook", nil,
+		"A local script to run as a cloud-init boothook, this requires the mime output format. Can be specified multiple times.")
+
+	// flags for the add-ons
+	flags.StringArrayVar(&clusterOpts.addons, "addon", nil,
+		"An add-on to install on the bootstrap cluster as comma separated key=value pairs, "+
+			"e.g. name=ingress-nginx,chart=ingress-nginx,repo=https://kubernetes.github.io/ingress-nginx,version=4.11.2,values=./values.yaml. "+
+			"Supported keys are name, namespace, chart, repo, version, values (a local file), manifest (a local file or URL) and crs. Can be specified multiple times.")
+
+	// flags for the existing CAs and bootstrap token
+	flags.StringArrayVar(&clusterOpts.certificateAuthorities, "ca", nil,
+		"An existing CA key pair to use instead of a generated one as comma separated key=value pairs, "+
+			"e.g. purpose=server-ca,cert=./server-ca.crt,key=./server-ca.key or purpose=client-ca,secret=pki/client-ca to read tls.crt and tls.key from a Secret in the cluster of the current kubeconfig context. "+
+			"Purposes are server-ca, client-ca, etcd-ca and request-header-ca. Can be specified multiple times.")
+	flags.StringVar(&clusterOpts.bootstrapTokenFile, "bootstrap-token-file", "",
+		"A local file with an existing bootstrap token to use instead of a generated one.")
+	flags.StringVar(&clusterOpts.bootstrapTokenSecret, "bootstrap-token-secret", "",
+		"A Secret in the form <namespace>/<name> in the cluster of the current kubeconfig context with an existing bootstrap token in its value key.")
+
+	// flags for the component versions
+	flags.StringToStringVar(&clusterOpts.componentVersions, "component-version", nil,
+		"Pin the version of a component installed on the bootstrap cluster, e.g. cert-manager=v1.15.3. "+
+			"Components are cert-manager, capi-operator, capi, k3s-provider, capl and linode-ccm.")
+
+	// flags for the user data
+	flags.StringVar(&clusterOpts.outputFormat, "output-format", "",
+		"The format of the user data for the bootstrap machine, one of cloud-config, ignition or mime. "+
+			"If unspecified, the format requested by the infrastructure provider or cloud-config will be used, or mime if there are extra cloud-config or boothook parts.")
+	flags.BoolVar(&clusterOpts.tarWriteFiles, "tar-write-files", false,
+		"Bundle all files into a single tarball to reduce the size of the user data, files keep their permissions and owner. "+
+			"Requires cloud-init, it can't be used with the ignition output format.")
+	flags.StringVar(&clusterOpts.fileDelivery, "file-delivery", "",
+		"How files uploaded to the backend are delivered, either runcmd to install them with generated commands or source to let cloud-init fetch them. "+
+			"With source, files that use instance data are kept in the user data so they can be rendered with jinja. "+
+			"If unspecified, runcmd will be used, or source with the ignition output format, which doesn't support runcmd.")
+}
 
-	// flags for the config map source
-	rootCmd.AddCommand(clusterCmd)
+// addManifestFlags adds the flags used to read the manifest of a cluster.
+func addManifestFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&clusterOpts.manifest, "manifest", "m", "",
+		"The file containing cluster manifest to use for bootstrap cluster. If set to '-', the manifest is read from stdin.")
+
+	flags.StringVar(&clusterOpts.kubernetesVersion, "kubernetes-version", "",
+		"The Kubernetes version to use for the workload cluster. If unspecified, the version in the manifest or the KUBERNETES_VERSION environment variable for a cluster template will be used.")
+
+	flags.Int64Var(&clusterOpts.controlPlaneMachineCount, "control-plane-machine-count", 1,
+		"The number of control plane machines for the workload cluster. If unspecified, the replicas in the manifest are kept.")
+	// Remove default from hard coded text if the default is ever changed from 0 since cobra would then add it
+	flags.Int64Var(&clusterOpts.workerMachineCount, "worker-machine-count", 0,
+		"The number of worker machines for the workload cluster, set on all MachineDeployments. If unspecified, the replicas in the manifest are kept. (default 0)")
+
+	// flags for the url source
+	flags.StringVar(&clusterOpts.url, "from", "",
+		"The URL or file to read the workload cluster template from, its ${VAR} variables are substituted from the environment like clusterctl generate cluster. If set to '-', the workload cluster template is read from stdin.")
 }
 
 func runBootstrapCluster(cmd *cobra.Command, _ []string) error {
 	ctx := cmd.Context()
 
-	manifestFile, err := cmd.Flags().GetString("manifest")
+	values, infrastructureProvider, controlPlaneProvider, err := parseCluster(cmd)
 	if err != nil {
 		return err
 	}
-	manifestFileName := filepath.Base(manifestFile)
-	values := &types.Values{
-		ManifestFile: manifestFileName,
-	}
-	if os.Getenv("AUTHORIZED_KEYS") != "" {
-		keys := os.Getenv("AUTHORIZED_KEYS")
-		values.SSHAuthorizedKeys = strings.Split(keys, ",")
-		klog.V(4).Infof("using ssh public key(s) %s", values.SSHAuthorizedKeys)
-	} else {
-		klog.V(4).Infof("no ssh public key(s) were specified")
-	}
-	values.ManifestFS = os.DirFS(filepath.Dir(manifestFile))
-	if manifestFileName == "-" {
-		values.ManifestFS = cloudinit.IoFS{Reader: cmd.InOrStdin()}
-	}
-
-	capiManifests, err := cloudinit.GenerateCapiManifests(ctx, values, nil, nil, false)
-	if err != nil {
-		return fmt.Errorf("could not parse manifest %s: %s", values.ManifestFile, err)
-	}
-
-	values.Manifests = strings.Split(capiManifests.ManifestFile.Content, "---")
-
-	clusterSpec := capiYaml.GetClusterDef(values.Manifests)
-	if clusterSpec == nil {
-		return errors.New("cluster not found")
-	}
-	values.Namespace = clusterSpec.Namespace
-
-	infrastructureProvider := infrastructure.NewProvider(clusterSpec.Spec.InfrastructureRef.Kind)
-	if infrastructureProvider == nil {
-		return errors.New("infrastructure provider not found for " + clusterSpec.Spec.InfrastructureRef.Kind)
-	}
-	controlPlaneProvider := controlplane.NewProvider(clusterSpec.Spec.ControlPlaneRef.Kind)
-	if controlPlaneProvider == nil {
-		return errors.New("ControlPlane provider not found for " + clusterSpec.Spec.ControlPlaneRef.Kind)
-	}
-	values.ClusterName = clusterSpec.Name
-	values.ClusterKind = clusterSpec.Spec.InfrastructureRef.Kind
 
 	backendProvider := backend.NewProvider(clusterOpts.backend)
 	if backendProvider == nil {
@@ -152,6 +211,16 @@ func runBootstrapCluster(cmd *cobra.Command, _ []string) error {
 	if err != nil {
 		return err
 	}
+	clusterState.Values = values
+	clusterState.Backend = backendProvider
+	clusterState.ControlPlane = controlPlaneProvider
+	clusterState.Infrastructure = infrastructureProvider
+
+	// the state is written as soon as the infrastructure resources like the cluster token and the firewall exist, so
+	// they are deleted with the cluster if any of the following steps fails
+	if err := writeClusterState(ctx, backendProvider, clusterState); err != nil {
+		return err
+	}
 
 	cloudConfig, err := cloudinit.GenerateCloudInit(ctx, values, infrastructureProvider, controlPlaneProvider, backendProvider)
 	if err != nil {
@@ -166,15 +235,384 @@ func runBootstrapCluster(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 
-	clusterState.Values = values
-	clusterState.Backend = backendProvider
-	clusterState.ControlPlane = controlPlaneProvider
-	clusterState.Infrastructure = infrastructureProvider
+	if err := writeClusterState(ctx, backendProvider, clusterState); err != nil {
+		return err
+	}
+
+	if !clusterOpts.wait {
+		warnFilesKept(values.ClusterName)
+		return nil
+	}
+	if err := waitForBootstrap(ctx, values, backendProvider); err != nil {
+		warnFilesKept(values.ClusterName)
+		return err
+	}
+	return nil
+}
 
-	c, err := clusterState.ToConfig()
+// warnFilesKept warns that the files uploaded for the bootstrap machine of a cluster are still in the backend.
+func warnFilesKept(clusterName string) {
+	klog.Warningf("the bootstrap files of cluster %[1]s, which include the CA keys and the bootstrap token, were left in the backend, "+
+		"delete them with `backend prune %[1]s` once the cluster has been created", clusterName)
+}
+
+// writeClusterState writes the state of a cluster to its backend.
+func writeClusterState(ctx context.Context, backendProvider backend.Provider, clusterState *state.State) error {
+	config, err := clusterState.ToConfig()
 	if err != nil {
 		return err
 	}
+	return backendProvider.WriteConfig(ctx, clusterState.Values.ClusterName, config)
+}
 
-	return backendProvider.WriteConfig(ctx, values.ClusterName, c)
+// waitForBootstrap waits until the bootstrap machine has created the cluster and purges the files uploaded for it,
+// which include the CA keys and the bootstrap token, unless they should be kept.
+func waitForBootstrap(ctx context.Context, values *types.Values, backendProvider backend.Provider) error {
+	kubeconfig, err := capiYaml.Marshal(values.Kubeconfig)
+	if err != nil {
+		return err
+	}
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return err
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	namespace := values.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	klog.Infof("waiting up to %s for the bootstrap machine to create cluster %s", clusterOpts.waitTimeout, values.ClusterName)
+	waitCtx, cancel := context.WithTimeout(ctx, clusterOpts.waitTimeout)
+	defer cancel()
+	if err := utils.WaitForCluster(waitCtx, client, namespace, values.ClusterName, 10*time.Second); err != nil {
+		return err
+	}
+	klog.Infof("cluster %s has been created on the bootstrap cluster", values.ClusterName)
+
+	if clusterOpts.keepFiles {
+		warnFilesKept(values.ClusterName)
+		return nil
+	}
+	if err := backendProvider.PurgeFiles(ctx, values.ClusterName); err != nil {
+		return fmt.Errorf("could not purge files of cluster %s: %s", values.ClusterName, err)
+	}
+	klog.Infof("purged the bootstrap files of cluster %s from the backend", values.ClusterName)
+	return nil
+}
+
+// parseCluster builds the values for a cluster from the flags and its manifest, and finds the providers it uses.
+func parseCluster(cmd *cobra.Command) (*types.Values, infrastructure.Provider, controlplane.Provider, error) {
+	manifest, manifestName, err := readClusterManifest(cmd)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	values := &types.Values{
+		ManifestFile: manifestName,
+		// the manifest is kept in memory since it's read again to generate the cloud-config
+		ManifestFS: cloudinit.BytesFS(manifest),
+	}
+	if os.Getenv("AUTHORIZED_KEYS") != "" {
+		keys := os.Getenv("AUTHORIZED_KEYS")
+		values.SSHAuthorizedKeys = strings.Split(keys, ",")
+		klog.V(4).Infof("using ssh public key(s) %s", values.SSHAuthorizedKeys)
+	} else {
+		klog.V(4).Infof("no ssh public key(s) were specified")
+	}
+	values.CloudInit, err = cloudInitExtras(appConfig.CloudInit)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	values.Addons = slices.Clone(appConfig.Addons)
+	for _, addonFlag := range clusterOpts.addons {
+		addon, err := parseAddon(addonFlag)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		values.Addons = append(values.Addons, addon)
+	}
+	values.CertificateAuthorities, err = certificateAuthorities(cmd.Context())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	values.BootstrapToken, err = bootstrapToken(cmd.Context())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	values.Versions = appConfig.Versions
+	for component, version := range clusterOpts.componentVersions {
+		if err := values.Versions.Set(component, version); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if clusterOpts.outputFormat != "" && !slices.Contains(types.OutputFormats, clusterOpts.outputFormat) {
+		return nil, nil, nil, fmt.Errorf("unsupported output format %q, options are: %s", clusterOpts.outputFormat, strings.Join(types.OutputFormats, ", "))
+	}
+	values.OutputFormat = clusterOpts.outputFormat
+	values.TarWriteFiles = clusterOpts.tarWriteFiles
+	if clusterOpts.fileDelivery != "" && clusterOpts.fileDelivery != types.FileDeliveryRunCmd && clusterOpts.fileDelivery != types.FileDeliverySource {
+		return nil, nil, nil, fmt.Errorf("unsupported file delivery %q, options are: %s, %s", clusterOpts.fileDelivery, types.FileDeliveryRunCmd, types.FileDeliverySource)
+	}
+	values.FileDelivery = clusterOpts.fileDelivery
+	if values.OutputFormat == "" && (len(values.CloudInit.CloudConfigParts) != 0 || len(values.CloudInit.Boothooks) != 0) {
+		values.OutputFormat = types.OutputFormatMIME
+	}
+	// the manifests are parsed before UpdateCluster points the controlPlaneRef away from the control plane
+	values.Manifests, err = loadManifest(cmd.Context(), values)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	clusterSpec := capiYaml.GetClusterDef(values.Manifests)
+	if clusterSpec == nil {
+		return nil, nil, nil, errors.New("cluster not found")
+	}
+	values.Namespace = clusterSpec.Namespace
+	if values.Namespace == "" {
+		values.Namespace = metav1.NamespaceDefault
+	}
+	if clusterSpec.Spec.ControlPlaneRef == nil || clusterSpec.Spec.InfrastructureRef == nil {
+		return nil, nil, nil, fmt.Errorf("cluster %s needs a controlPlaneRef and an infrastructureRef", clusterSpec.Name)
+	}
+	values.ControlPlaneName = clusterSpec.Spec.ControlPlaneRef.Name
+	values.Topology = clusterSpec.Spec.Topology != nil
+	values.InfrastructureName = clusterSpec.Spec.InfrastructureRef.Name
+
+	infrastructureProvider := infrastructure.NewProvider(clusterSpec.Spec.InfrastructureRef.Kind)
+	if infrastructureProvider == nil {
+		return nil, nil, nil, errors.New("infrastructure provider not found for " + clusterSpec.Spec.InfrastructureRef.Kind)
+	}
+	controlPlaneProvider := controlplane.NewProvider(clusterSpec.Spec.ControlPlaneRef.Kind)
+	if controlPlaneProvider == nil {
+		return nil, nil, nil, errors.New("ControlPlane provider not found for " + clusterSpec.Spec.ControlPlaneRef.Kind)
+	}
+	values.ClusterName = clusterSpec.Name
+	values.ClusterKind = clusterSpec.Spec.InfrastructureRef.Kind
+
+	return values, infrastructureProvider, controlPlaneProvider, nil
+}
+
+// cloudInitExtras merges the cloud-init additions from the config file with the ones passed as flags.
+func cloudInitExtras(config types.CloudInit) (types.CloudInit, error) {
+	extras := types.CloudInit{
+		Debug:            config.Debug || clusterOpts.debug,
+		RunCmd:           append(slices.Clone(config.RunCmd), clusterOpts.runCmds...),
+		WriteFiles:       slices.Clone(config.WriteFiles),
+		CloudConfigParts: slices.Clone(config.CloudConfigParts),
+		Boothooks:        slices.Clone(config.Boothooks),
+	}
+	for _, writeFile := range clusterOpts.writeFiles {
+		remotePath, localPath, found := strings.Cut(writeFile, "=")
+		if !found || remotePath == "" || localPath == "" {
+			return extras, fmt.Errorf("invalid write-file %q, expected <remote path>=<local path>", writeFile)
+		}
+		content, err := os.ReadFile(localPath)
+		if err != nil {
+			return extras, fmt.Errorf("could not read write-file %s: %s", localPath, err)
+		}
+		extras.WriteFiles = append(extras.WriteFiles, capiYaml.InitFile{
+			Path:    remotePath,
+			Content: string(content),
+		})
+	}
+	for _, cloudConfigPart := range clusterOpts.cloudConfigParts {
+		content, err := os.ReadFile(cloudConfigPart)
+		if err != nil {
+			return extras, fmt.Errorf("could not read cloud-config part %s: %s", cloudConfigPart, err)
+		}
+		extras.CloudConfigParts = append(extras.CloudConfigParts, string(content))
+	}
+	for _, boothook := range clusterOpts.boothooks {
+		content, err := os.ReadFile(boothook)
+		if err != nil {
+			return extras, fmt.Errorf("could not read boothook %s: %s", boothook, err)
+		}
+		extras.Boothooks = append(extras.Boothooks, string(content))
+	}
+	if extras.Debug {
+		klog.V(4).Infof("debug profile enabled")
+	}
+	return extras, nil
+}
+
+// parseAddon parses an add-on from a comma separated list of key=value pairs.
+func parseAddon(addonFlag string) (types.Addon, error) {
+	var addon types.Addon
+	for _, field := range strings.Split(addonFlag, ",") {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			return addon, fmt.Errorf("invalid addon field %q, expected key=value", field)
+		}
+		switch key {
+		case "name":
+			addon.Name = value
+		case "namespace":
+			addon.Namespace = value
+		case "chart":
+			addon.Chart = value
+		case "repo":
+			addon.Repo = value
+		case "version":
+			addon.Version = value
+		case "values":
+			helmValues, err := os.ReadFile(value)
+			if err != nil {
+				return addon, fmt.Errorf("could not read addon values %s: %s", value, err)
+			}
+			addon.Values = string(helmValues)
+		case "manifest":
+			addon.Manifest = value
+		case "crs":
+			crs, err := strconv.ParseBool(value)
+			if err != nil {
+				return addon, fmt.Errorf("invalid addon crs value %q: %s", value, err)
+			}
+			addon.ClusterResourceSet = crs
+		default:
+			return addon, fmt.Errorf("unknown addon field %q", key)
+		}
+	}
+	if addon.Name == "" {
+		return addon, fmt.Errorf("addon %q is missing a name", addonFlag)
+	}
+	return addon, nil
+}
+
+// certificateAuthorities reads the existing CA key pairs from their sources in the config file and the --ca flags, a
+// flag replaces the source of its purpose from the config file.
+func certificateAuthorities(ctx context.Context) (map[string]types.CertificateAuthority, error) {
+	sources := maps.Clone(appConfig.CertificateAuthorities)
+	for _, caFlag := range clusterOpts.certificateAuthorities {
+		purpose, source, err := parseCertificateAuthority(caFlag)
+		if err != nil {
+			return nil, err
+		}
+		if sources == nil {
+			sources = map[string]types.CertificateAuthoritySource{}
+		}
+		sources[purpose] = source
+	}
+	if len(sources) == 0 {
+		return nil, nil
+	}
+	cas := make(map[string]types.CertificateAuthority, len(sources))
+	for purpose, source := range sources {
+		ca, err := readCertificateAuthority(ctx, source)
+		if err != nil {
+			return nil, fmt.Errorf("could not read certificate authority %s: %s", purpose, err)
+		}
+		cas[purpose] = ca
+	}
+	return cas, nil
+}
+
+// parseCertificateAuthority parses the purpose and source of a CA from a comma separated list of key=value pairs.
+func parseCertificateAuthority(caFlag string) (string, types.CertificateAuthoritySource, error) {
+	var purpose string
+	var source types.CertificateAuthoritySource
+	for _, field := range strings.Split(caFlag, ",") {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			return "", source, fmt.Errorf("invalid ca field %q, expected key=value", field)
+		}
+		switch key {
+		case "purpose":
+			purpose = value
+		case "cert":
+			source.CertFile = value
+		case "key":
+			source.KeyFile = value
+		case "secret":
+			source.Secret = value
+		default:
+			return "", source, fmt.Errorf("unknown ca field %q", key)
+		}
+	}
+	if !slices.Contains(types.CAPurposes, purpose) {
+		return "", source, fmt.Errorf("ca %q needs a purpose, options are: %s", caFlag, strings.Join(types.CAPurposes, ", "))
+	}
+	return purpose, source, nil
+}
+
+// readCertificateAuthority reads a CA key pair either from local files or from a Secret.
+func readCertificateAuthority(ctx context.Context, source types.CertificateAuthoritySource) (types.CertificateAuthority, error) {
+	var ca types.CertificateAuthority
+	switch {
+	case source.Secret != "" && source.CertFile == "" && source.KeyFile == "":
+		data, err := readSecret(ctx, source.Secret)
+		if err != nil {
+			return ca, err
+		}
+		ca.Cert, ca.Key = data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey]
+		if len(ca.Cert) == 0 || len(ca.Key) == 0 {
+			return ca, fmt.Errorf("secret %s needs a %s and a %s", source.Secret, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+		}
+	case source.Secret == "" && source.CertFile != "" && source.KeyFile != "":
+		var err error
+		if ca.Cert, err = os.ReadFile(source.CertFile); err != nil {
+			return ca, err
+		}
+		if ca.Key, err = os.ReadFile(source.KeyFile); err != nil {
+			return ca, err
+		}
+	default:
+		return ca, errors.New("either a cert and a key file or a secret are required")
+	}
+	return ca, nil
+}
+
+// bootstrapToken reads the existing bootstrap token from its source in the config file or the flags, it returns an
+// empty token if there is no source.
+func bootstrapToken(ctx context.Context) (string, error) {
+	source := appConfig.BootstrapToken
+	if clusterOpts.bootstrapTokenFile != "" || clusterOpts.bootstrapTokenSecret != "" {
+		source = types.TokenSource{File: clusterOpts.bootstrapTokenFile, Secret: clusterOpts.bootstrapTokenSecret}
+	}
+	var token []byte
+	var err error
+	switch {
+	case source.File == "" && source.Secret == "":
+		return "", nil
+	case source.File != "" && source.Secret != "":
+		return "", errors.New("the bootstrap token can be read either from a file or a secret")
+	case source.File != "":
+		token, err = os.ReadFile(source.File)
+	default:
+		var data map[string][]byte
+		data, err = readSecret(ctx, source.Secret)
+		token = data["value"]
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read bootstrap token: %s", err)
+	}
+	if len(bytes.TrimSpace(token)) == 0 {
+		return "", errors.New("bootstrap token is empty")
+	}
+	return string(bytes.TrimSpace(token)), nil
+}
+
+// readSecret returns the data of the Secret referenced as <namespace>/<name> in the cluster of the current kubeconfig
+// context.
+func readSecret(ctx context.Context, ref string) (map[string][]byte, error) {
+	namespace, name, found := strings.Cut(ref, "/")
+	if !found || namespace == "" || name == "" {
+		return nil, fmt.Errorf("invalid secret reference %q, expected <namespace>/<name>", ref)
+	}
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get secret %s: %s", ref, err)
+	}
+	return secret.Data, nil
 }
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/yamlprocessor"
+
+	"capi-bootstrap/cloudinit"
+	capiYaml "capi-bootstrap/yaml"
+)
+
+// readClusterManifest reads the manifest of the cluster from --manifest, or the cluster template from --from with its
+// variables substituted, and applies the Kubernetes version and machine count flags to it. It returns the manifest
+// and its name.
+func readClusterManifest(cmd *cobra.Command) ([]byte, string, error) {
+	var manifest []byte
+	var name string
+	var err error
+	switch {
+	case clusterOpts.manifest != "" && clusterOpts.url != "":
+		return nil, "", errors.New("only one of --manifest and --from can be set")
+	case clusterOpts.manifest == "-":
+		name = "-"
+		manifest, err = io.ReadAll(cmd.InOrStdin())
+	case clusterOpts.manifest != "":
+		name = filepath.Base(clusterOpts.manifest)
+		manifest, err = os.ReadFile(clusterOpts.manifest)
+	case clusterOpts.url != "":
+		name, manifest, err = readClusterTemplate(cmd)
+	default:
+		return nil, "", errors.New("one of --manifest or --from is required")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	overrides := capiYaml.ClusterOverrides{KubernetesVersion: clusterOpts.kubernetesVersion}
+	if cmd.Flags().Changed("control-plane-machine-count") {
+		overrides.ControlPlaneReplicas = &clusterOpts.controlPlaneMachineCount
+	}
+	if cmd.Flags().Changed("worker-machine-count") {
+		overrides.WorkerReplicas = &clusterOpts.workerMachineCount
+	}
+	if overrides == (capiYaml.ClusterOverrides{}) {
+		return manifest, name, nil
+	}
+	parsedManifest, err := capiYaml.ParseManifest(string(manifest))
+	if err != nil {
+		return nil, "", fmt.Errorf("could not parse manifest %s: %s", name, err)
+	}
+	if err := capiYaml.ApplyClusterOverrides(parsedManifest, overrides); err != nil {
+		return nil, "", fmt.Errorf("could not update manifest %s: %s", name, err)
+	}
+	manifest, err = parsedManifest.Marshal()
+	if err != nil {
+		return nil, "", err
+	}
+	return manifest, name, nil
+}
+
+// readClusterTemplate reads the cluster template from a URL, a local file or stdin and substitutes its variables like
+// clusterctl generate cluster does.
+func readClusterTemplate(cmd *cobra.Command) (string, []byte, error) {
+	var template []byte
+	var err error
+	name := clusterOpts.url
+	if clusterOpts.url == "-" {
+		template, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		name = path.Base(clusterOpts.url)
+		template, err = cloudinit.ReadManifest(cmd.Context(), clusterOpts.url)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("could not read cluster template %s: %s", clusterOpts.url, err)
+	}
+
+	processor := yamlprocessor.NewSimpleProcessor()
+	variables, err := processor.GetVariableMap(template)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not parse the variables of cluster template %s: %s", clusterOpts.url, err)
+	}
+	var missing []string
+	for variable, defaultValue := range variables {
+		if _, err := templateVariable(variable); err != nil && defaultValue == nil {
+			missing = append(missing, variable)
+		}
+	}
+	if len(missing) != 0 {
+		slices.Sort(missing)
+		return "", nil, fmt.Errorf("cluster template %s requires variables that are not set: %s", clusterOpts.url, strings.Join(missing, ", "))
+	}
+	manifest, err := processor.Process(template, templateVariable)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not substitute the variables of cluster template %s: %s", clusterOpts.url, err)
+	}
+	return name, manifest, nil
+}
+
+// templateVariable returns the value of a cluster template variable from the environment. Like clusterctl generate
+// cluster, the machine counts and the Kubernetes version are taken from the flags.
+func templateVariable(name string) (string, error) {
+	switch name {
+	case "KUBERNETES_VERSION":
+		if clusterOpts.kubernetesVersion != "" {
+			return clusterOpts.kubernetesVersion, nil
+		}
+	case "CONTROL_PLANE_MACHINE_COUNT":
+		return strconv.FormatInt(clusterOpts.controlPlaneMachineCount, 10), nil
+	case "WORKER_MACHINE_COUNT":
+		return strconv.FormatInt(clusterOpts.workerMachineCount, 10), nil
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("variable %s is not set", name)
+}
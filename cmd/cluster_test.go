@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
+
+	mockBackend "capi-bootstrap/providers/backend/mock"
+	"capi-bootstrap/providers/controlplane/k3s"
+	"capi-bootstrap/providers/infrastructure/linode"
+	"capi-bootstrap/state"
+	"capi-bootstrap/types"
+)
+
+func TestWriteClusterState(t *testing.T) {
+	type test struct {
+		name    string
+		err     error
+		wantErr string
+	}
+	tests := []test{
+		{name: "success"},
+		{name: "err write config", err: errors.New("backend error"), wantErr: "backend error"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			ctrl := gomock.NewController(t)
+			backendMock := mockBackend.NewMockProvider(ctrl)
+			infra := linode.NewInfrastructure()
+			infra.ClusterTokenID = 456
+			infra.FirewallID = 321
+			infra.FirewallCreated = true
+			clusterState, err := state.NewState(&v1.Config{CurrentContext: "test-cluster-admin@test-cluster"})
+			require.NoError(t, err)
+			clusterState.Values = &types.Values{ClusterName: "test-cluster"}
+			clusterState.ControlPlane = k3s.NewControlPlane()
+			clusterState.Infrastructure = infra
+
+			var written *v1.Config
+			backendMock.EXPECT().
+				WriteConfig(ctx, "test-cluster", gomock.Any()).
+				DoAndReturn(func(_ context.Context, _ string, config *v1.Config) error {
+					written = config
+					return tc.err
+				})
+			err = writeClusterState(ctx, backendMock, clusterState)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			// the written state has the IDs of the resources to delete with the cluster
+			readState, err := state.NewState(written)
+			require.NoError(t, err)
+			assert.Equal(t, "test-cluster", readState.Values.ClusterName)
+			readInfra, ok := readState.Infrastructure.(*linode.Infrastructure)
+			require.True(t, ok)
+			assert.Equal(t, 456, readInfra.ClusterTokenID)
+			assert.Equal(t, 321, readInfra.FirewallID)
+			assert.True(t, readInfra.FirewallCreated)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthenticationv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+	"k8s.io/klog/v2"
+
+	"capi-bootstrap/providers/backend"
+)
+
+// credentialExpiryMargin is how long a cached credential has to be valid for at least to be used.
+const credentialExpiryMargin = time.Minute
+
+var credentialCmd = &cobra.Command{
+	Use:   "credential [cluster name]",
+	Short: "print a client certificate for a cluster as a client-go exec credential",
+	Long: `print a client certificate for a user of a cluster in the client.authentication.k8s.io/v1 ExecCredential format.
+Certificates are issued from the client CA kept in the cluster state in the backend, recorded in the audit entries of
+the cluster and cached until they expire. Kubeconfigs using this command are written by get kubeconfig --exec.
+Groups starting with system: are rejected. Access to the backend is equivalent to cluster-admin, since the cluster
+state holds the client CA key.`,
+	Args: func(_ *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("please specify a cluster name")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCredential(cmd, args[0])
+	},
+}
+
+func init() {
+	credentialCmd.Flags().String("user", "",
+		"the user of the client certificate")
+	credentialCmd.Flags().StringArray("group", nil,
+		"a group of the user of the client certificate. Can be specified multiple times.")
+	credentialCmd.Flags().Duration("ttl", time.Hour,
+		"how long an issued client certificate is valid")
+	rootCmd.AddCommand(credentialCmd)
+}
+
+func runCredential(cmd *cobra.Command, clusterName string) error {
+	ctx := cmd.Context()
+	userName, _ := cmd.Flags().GetString("user")
+	groups, _ := cmd.Flags().GetStringArray("group")
+	ttl, _ := cmd.Flags().GetDuration("ttl")
+	if userName == "" {
+		return errors.New("please specify a user")
+	}
+	if err := checkGroups(groups); err != nil {
+		return err
+	}
+	if err := checkExecInfo(os.Getenv("KUBERNETES_EXEC_INFO")); err != nil {
+		return err
+	}
+
+	cachePath, err := credentialCachePath(clusterOpts.backend, clusterName, userName, groups)
+	if err != nil {
+		return err
+	}
+	if cached := readCachedCredential(cachePath, time.Now()); cached != nil {
+		return printCredential(cached)
+	}
+
+	backendProvider := backend.NewProvider(clusterOpts.backend)
+	if backendProvider == nil {
+		return errors.New("backend provider not specified, options are: " + strings.Join(backend.ListProviders(), ","))
+	}
+	if err := backendProvider.PreCmd(ctx, clusterName); err != nil {
+		return err
+	}
+	config, err := backendProvider.Read(ctx, clusterName)
+	if err != nil {
+		return err
+	}
+	issued, entry, err := issueUserKubeconfig(ctx, clusterName, config, userName, groups, ttl)
+	if err != nil {
+		return err
+	}
+	if err := backendProvider.WriteAuditEntry(ctx, clusterName, entry); err != nil {
+		return fmt.Errorf("could not write audit entry: %s", err)
+	}
+
+	credential := &clientauthenticationv1.ExecCredential{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: clientauthenticationv1.SchemeGroupVersion.String(),
+			Kind:       "ExecCredential",
+		},
+		Status: &clientauthenticationv1.ExecCredentialStatus{
+			ExpirationTimestamp:   &metav1.Time{Time: entry.Expires},
+			ClientCertificateData: string(issued.AuthInfos[0].AuthInfo.ClientCertificateData),
+			ClientKeyData:         string(issued.AuthInfos[0].AuthInfo.ClientKeyData),
+		},
+	}
+	if err := writeCachedCredential(cachePath, credential); err != nil {
+		klog.Warningf("could not cache the credential: %s", err)
+	}
+	return printCredential(credential)
+}
+
+// checkExecInfo returns an error if client-go requests an ExecCredential version other than v1 in execInfo, which is
+// the content of KUBERNETES_EXEC_INFO.
+func checkExecInfo(execInfo string) error {
+	if execInfo == "" {
+		return nil
+	}
+	var request clientauthenticationv1.ExecCredential
+	if err := json.Unmarshal([]byte(execInfo), &request); err != nil {
+		return fmt.Errorf("could not decode KUBERNETES_EXEC_INFO: %s", err)
+	}
+	if request.APIVersion != clientauthenticationv1.SchemeGroupVersion.String() {
+		return fmt.Errorf("unsupported exec credential version %q, only %s is supported", request.APIVersion, clientauthenticationv1.SchemeGroupVersion)
+	}
+	return nil
+}
+
+// credentialCachePath returns the path the credential of a user is cached at, the name of the file is derived from
+// the backend, the cluster, the user and the groups.
+func credentialCachePath(backendName, clusterName, userName string, groups []string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	groups = slices.Sorted(slices.Values(groups))
+	hash := sha256.Sum256([]byte(strings.Join(append([]string{backendName, clusterName, userName}, groups...), "\n")))
+	name := fmt.Sprintf("%s-%s.json", clusterName, hex.EncodeToString(hash[:8]))
+	return filepath.Join(cacheDir, "cluster-api", "bootstrap", "credentials", name), nil
+}
+
+// readCachedCredential returns the credential cached at path, or nil if there is none or it expires within
+// credentialExpiryMargin of now.
+func readCachedCredential(path string, now time.Time) *clientauthenticationv1.ExecCredential {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var credential clientauthenticationv1.ExecCredential
+	if err := json.Unmarshal(content, &credential); err != nil {
+		klog.V(4).Infof("ignoring invalid cached credential %s: %s", path, err)
+		return nil
+	}
+	if credential.Status == nil || credential.Status.ExpirationTimestamp == nil ||
+		credential.Status.ExpirationTimestamp.Time.Before(now.Add(credentialExpiryMargin)) {
+		return nil
+	}
+	return &credential
+}
+
+// writeCachedCredential writes credential to path, which is only readable by the current user.
+func writeCachedCredential(path string, credential *clientauthenticationv1.ExecCredential) error {
+	content, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0o600)
+}
+
+func printCredential(credential *clientauthenticationv1.ExecCredential) error {
+	content, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(content))
+	return nil
+}
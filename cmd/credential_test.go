@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCredential(t *testing.T) {
+	type test struct {
+		name     string
+		user     string
+		groups   []string
+		execInfo string
+		wantErr  string
+	}
+	tests := []test{
+		{name: "err no user", wantErr: "please specify a user"},
+		{name: "err privileged group", user: "alice", groups: []string{"system:masters"}, wantErr: "group system:masters bypasses RBAC, use the admin kubeconfig instead"},
+		{
+			name:     "err exec info version",
+			user:     "alice",
+			execInfo: `{"apiVersion":"client.authentication.k8s.io/v1beta1","kind":"ExecCredential"}`,
+			wantErr:  "unsupported exec credential version \"client.authentication.k8s.io/v1beta1\", only client.authentication.k8s.io/v1 is supported",
+		},
+	}
+	for _, tc := range tests {
+		// KUBERNETES_EXEC_INFO is set for the process, so the cases can't run in parallel
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("KUBERNETES_EXEC_INFO", tc.execInfo)
+			cmd := &cobra.Command{}
+			cmd.Flags().String("user", tc.user, "")
+			cmd.Flags().StringArray("group", tc.groups, "")
+			cmd.Flags().Duration("ttl", time.Hour, "")
+			cmd.SetContext(context.Background())
+			assert.EqualError(t, runCredential(cmd, "test-cluster"), tc.wantErr)
+		})
+	}
+}
+
+func TestCheckExecInfo(t *testing.T) {
+	type test struct {
+		name     string
+		execInfo string
+		wantErr  string
+	}
+	tests := []test{
+		{name: "success not set"},
+		{name: "success v1", execInfo: `{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential","spec":{"interactive":false}}`},
+		{
+			name:     "err v1beta1",
+			execInfo: `{"apiVersion":"client.authentication.k8s.io/v1beta1","kind":"ExecCredential"}`,
+			wantErr:  "unsupported exec credential version \"client.authentication.k8s.io/v1beta1\", only client.authentication.k8s.io/v1 is supported",
+		},
+		{
+			name:     "err invalid",
+			execInfo: "{",
+			wantErr:  "could not decode KUBERNETES_EXEC_INFO: unexpected end of JSON input",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := checkExecInfo(tc.execInfo)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCredentialCachePath(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cacheDir)
+	path, err := credentialCachePath("s3", "test-cluster", "alice", []string{"developers", "admins"})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(cacheDir, "cluster-api", "bootstrap", "credentials"), filepath.Dir(path))
+	assert.Regexp(t, `^test-cluster-[0-9a-f]{16}\.json$`, filepath.Base(path))
+
+	type test struct {
+		name        string
+		backendName string
+		clusterName string
+		userName    string
+		groups      []string
+		wantSame    bool
+	}
+	tests := []test{
+		{name: "same groups in other order", backendName: "s3", clusterName: "test-cluster", userName: "alice", groups: []string{"admins", "developers"}, wantSame: true},
+		{name: "other backend", backendName: "github", clusterName: "test-cluster", userName: "alice", groups: []string{"developers", "admins"}},
+		{name: "other user", backendName: "s3", clusterName: "test-cluster", userName: "bob", groups: []string{"developers", "admins"}},
+		{name: "other groups", backendName: "s3", clusterName: "test-cluster", userName: "alice", groups: []string{"developers"}},
+	}
+	for _, tc := range tests {
+		// XDG_CACHE_HOME is set for the process, so the cases can't run in parallel
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := credentialCachePath(tc.backendName, tc.clusterName, tc.userName, tc.groups)
+			require.NoError(t, err)
+			if tc.wantSame {
+				assert.Equal(t, path, got)
+			} else {
+				assert.NotEqual(t, path, got)
+			}
+		})
+	}
+}
+
+func TestReadCachedCredential(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	credential := func(expires string) string {
+		return `{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential","status":{"expirationTimestamp":"` +
+			expires + `","clientCertificateData":"cert","clientKeyData":"key"}}`
+	}
+	type test struct {
+		name    string
+		content string
+		want    bool
+	}
+	tests := []test{
+		{name: "success valid", content: credential("2025-06-01T13:00:00Z"), want: true},
+		{name: "success expires after margin", content: credential("2025-06-01T12:01:01Z"), want: true},
+		{name: "missing"},
+		{name: "expires within margin", content: credential("2025-06-01T12:00:59Z")},
+		{name: "expired", content: credential("2025-06-01T11:00:00Z")},
+		{name: "no status", content: `{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential"}`},
+		{name: "no expiry", content: `{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential","status":{"clientCertificateData":"cert"}}`},
+		{name: "corrupt", content: `{"apiVersion":"client.authentication.k8s.io/v1","kind":`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "test-cluster.json")
+			if tc.content != "" {
+				require.NoError(t, os.WriteFile(path, []byte(tc.content), 0o600))
+			}
+			got := readCachedCredential(path, now)
+			if !tc.want {
+				assert.Nil(t, got)
+				return
+			}
+			require.NotNil(t, got)
+			assert.Equal(t, "cert", got.Status.ClientCertificateData)
+			assert.Equal(t, "key", got.Status.ClientKeyData)
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var credentialsCmd = &cobra.Command{
+	Use:   "credentials",
+	Short: "manage the credentials of a cluster kept in its state",
+	Long:  ``,
+}
+
+func init() {
+	rootCmd.AddCommand(credentialsCmd)
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	"sigs.k8s.io/cluster-api/util/certs"
+	"sigs.k8s.io/cluster-api/util/secret"
+
+	"capi-bootstrap/providers/backend"
+	"capi-bootstrap/state"
+	"capi-bootstrap/types"
+	"capi-bootstrap/utils"
+	capiYaml "capi-bootstrap/yaml"
+)
+
+var credentialsRotateCmd = &cobra.Command{
+	Use:   "rotate [cluster name]",
+	Short: "issue a new admin client certificate for a cluster",
+	Long: `issue a new admin client certificate that expires after --ttl from the client CA kept in the state of a
+cluster, update the <cluster>-kubeconfig Secret in the cluster and the kubeconfig in the backend, then list the expiry
+of every certificate kept in the state. Client certificates can't be revoked, the previous certificate stays valid
+until it expires and only replacing the client CA invalidates it. Nothing renews the new certificate, the cluster has
+to be rotated again before it expires.`,
+	Args: func(_ *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("please specify a cluster name")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCredentialsRotate(cmd, args[0])
+	},
+}
+
+func init() {
+	credentialsRotateCmd.Flags().Duration("ttl", 90*24*time.Hour,
+		"how long the new admin client certificate is valid")
+	credentialsCmd.AddCommand(credentialsRotateCmd)
+}
+
+func runCredentialsRotate(cmd *cobra.Command, clusterName string) error {
+	ctx := cmd.Context()
+
+	backendProvider := backend.NewProvider(clusterOpts.backend)
+	if backendProvider == nil {
+		return errors.New("backend provider not specified, options are: " + strings.Join(backend.ListProviders(), ","))
+	}
+	if err := backendProvider.PreCmd(ctx, clusterName); err != nil {
+		return err
+	}
+
+	config, err := backendProvider.Read(ctx, clusterName)
+	if err != nil {
+		return err
+	}
+	clusterState, err := state.NewState(config)
+	if err != nil {
+		return err
+	}
+	if clusterState.Values == nil || clusterState.ControlPlane == nil {
+		return fmt.Errorf("the state of cluster %s has no control plane", clusterName)
+	}
+	previousCerts := clientCertificates(config)
+
+	values := clusterState.Values
+	ttl, _ := cmd.Flags().GetDuration("ttl")
+	if err := clusterState.ControlPlane.RotateKubeconfig(ctx, values, ttl); err != nil {
+		return fmt.Errorf("could not rotate the kubeconfig of cluster %s: %s", clusterName, err)
+	}
+	client, err := kubeconfigClient(values.Kubeconfig)
+	if err != nil {
+		return err
+	}
+	if err := updateKubeconfigSecret(ctx, client, values); err != nil {
+		return fmt.Errorf("could not update the kubeconfig secret of cluster %s: %s", clusterName, err)
+	}
+
+	rotatedState, err := state.NewState(values.Kubeconfig)
+	if err != nil {
+		return err
+	}
+	rotatedState.Values = values
+	rotatedState.Backend = clusterState.Backend
+	rotatedState.ControlPlane = clusterState.ControlPlane
+	rotatedState.Infrastructure = clusterState.Infrastructure
+	c, err := rotatedState.ToConfig()
+	if err != nil {
+		return err
+	}
+	if err := backendProvider.WriteConfig(ctx, clusterName, c); err != nil {
+		return err
+	}
+	out := cmd.OutOrStdout()
+	if _, err := fmt.Fprintf(out, "rotated the admin client certificate of cluster %s\n", clusterName); err != nil {
+		return err
+	}
+
+	certificates, err := clusterState.ControlPlane.GetCertificates(ctx)
+	if err != nil {
+		return err
+	}
+	maps.Copy(certificates, clientCertificates(values.Kubeconfig))
+	return printRotatedCertificates(out, previousCerts, certificates, time.Now())
+}
+
+// printRotatedCertificates warns on w that the previous client certificates are still valid and until when, followed
+// by a table of the certificates.
+func printRotatedCertificates(w io.Writer, previousCerts, certificates map[string][]byte, now time.Time) error {
+	for _, name := range slices.Sorted(maps.Keys(previousCerts)) {
+		cert, err := certs.DecodeCertPEM(previousCerts[name])
+		if err != nil {
+			return fmt.Errorf("could not decode the previous client certificate of %s: %s", name, err)
+		}
+		if _, err := fmt.Fprintf(w, "WARNING: the previous client certificate of %s is still valid and grants admin access until %s, "+
+			"it can't be revoked, only rotating the client CA invalidates it\n", name, cert.NotAfter.UTC().Format(time.RFC3339)); err != nil {
+			return err
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 1, '\t', 0)
+	if err := utils.TabWriteCertificates(tw, certificates, now); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+// clientCertificates returns the PEM encoded client certificates of config by the name of their user.
+func clientCertificates(config *v1.Config) map[string][]byte {
+	certificates := map[string][]byte{}
+	for _, authInfo := range config.AuthInfos {
+		if len(authInfo.AuthInfo.ClientCertificateData) != 0 {
+			certificates[authInfo.Name] = authInfo.AuthInfo.ClientCertificateData
+		}
+	}
+	return certificates
+}
+
+// kubeconfigClient returns a client for the cluster of config.
+func kubeconfigClient(config *v1.Config) (kubernetes.Interface, error) {
+	kubeconfig, err := capiYaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// updateKubeconfigSecret replaces the kubeconfig in the <cluster>-kubeconfig Secret with values.Kubeconfig.
+func updateKubeconfigSecret(ctx context.Context, client kubernetes.Interface, values *types.Values) error {
+	kubeconfig, err := capiYaml.Marshal(values.Kubeconfig)
+	if err != nil {
+		return err
+	}
+	namespace := values.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	kubeconfigSecret, err := client.CoreV1().Secrets(namespace).Get(ctx, secret.Name(values.ClusterName, secret.Kubeconfig), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if kubeconfigSecret.Data == nil {
+		kubeconfigSecret.Data = map[string][]byte{}
+	}
+	kubeconfigSecret.Data[secret.KubeconfigDataName] = kubeconfig
+	_, err = client.CoreV1().Secrets(namespace).Update(ctx, kubeconfigSecret, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/k3s-io/cluster-api-k3s/bootstrap/api/v1beta1"
+	secrets "github.com/k3s-io/cluster-api-k3s/pkg/secret"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	"sigs.k8s.io/cluster-api/util/certs"
+
+	"capi-bootstrap/types"
+)
+
+func TestClientCertificates(t *testing.T) {
+	t.Parallel()
+	config := &v1.Config{AuthInfos: []v1.NamedAuthInfo{
+		{Name: "test-cluster-admin", AuthInfo: v1.AuthInfo{ClientCertificateData: []byte("cert")}},
+		{Name: "test-token", AuthInfo: v1.AuthInfo{Token: "token"}},
+	}}
+	assert.Equal(t, map[string][]byte{"test-cluster-admin": []byte("cert")}, clientCertificates(config))
+}
+
+func TestPrintRotatedCertificates(t *testing.T) {
+	certificates := secrets.NewCertificatesForInitialControlPlane(&v1beta1.KThreesConfigSpec{})
+	require.NoError(t, certificates.Generate())
+	certPEM := certificates.GetByPurpose(secrets.ClientClusterCA).KeyPair.Cert
+	cert, err := certs.DecodeCertPEM(certPEM)
+	require.NoError(t, err)
+	expires := cert.NotAfter.UTC().Format(time.RFC3339)
+
+	type test struct {
+		name          string
+		previousCerts map[string][]byte
+		want          string
+		wantErr       string
+	}
+	table := "Name\t\t\tSubject\t\tExpires\t\t\tRemaining\n" +
+		"client-ca\t\tCN=kubernetes\t" + expires + "\texpired\n" +
+		"test-cluster-admin\tCN=kubernetes\t" + expires + "\texpired\n"
+	tests := []test{
+		{
+			name:          "success",
+			previousCerts: map[string][]byte{"test-cluster-admin": certPEM},
+			want: "WARNING: the previous client certificate of test-cluster-admin is still valid and grants admin access until " + expires +
+				", it can't be revoked, only rotating the client CA invalidates it\n" + table,
+		},
+		{
+			name: "success no previous certificate",
+			want: table,
+		},
+		{
+			name:          "err invalid previous certificate",
+			previousCerts: map[string][]byte{"test-cluster-admin": []byte("invalid")},
+			wantErr:       "could not decode the previous client certificate of test-cluster-admin: unable to decode PEM data",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			current := map[string][]byte{"client-ca": certPEM, "test-cluster-admin": certPEM}
+			buf := &bytes.Buffer{}
+			err := printRotatedCertificates(buf, tc.previousCerts, current, cert.NotAfter)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, buf.String())
+		})
+	}
+}
+
+func TestUpdateKubeconfigSecret(t *testing.T) {
+	type test struct {
+		name      string
+		namespace string
+		existing  []runtime.Object
+		wantErr   string
+	}
+	tests := []test{
+		{
+			name: "success default namespace",
+			existing: []runtime.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-kubeconfig", Namespace: metav1.NamespaceDefault},
+			}},
+		},
+		{
+			name:      "success namespace",
+			namespace: "capl",
+			existing: []runtime.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-kubeconfig", Namespace: "capl"},
+				Data:       map[string][]byte{"value": []byte("old"), "other": []byte("kept")},
+			}},
+		},
+		{
+			name:    "err not found",
+			wantErr: "secrets \"test-cluster-kubeconfig\" not found",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			client := fake.NewClientset(tc.existing...)
+			values := &types.Values{
+				ClusterName: "test-cluster",
+				Namespace:   tc.namespace,
+				Kubeconfig:  &v1.Config{CurrentContext: "test-cluster-admin@test-cluster"},
+			}
+			err := updateKubeconfigSecret(ctx, client, values)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			namespace := tc.namespace
+			if namespace == "" {
+				namespace = metav1.NamespaceDefault
+			}
+			kubeconfigSecret, err := client.CoreV1().Secrets(namespace).Get(ctx, "test-cluster-kubeconfig", metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Contains(t, string(kubeconfigSecret.Data["value"]), "current-context: test-cluster-admin@test-cluster")
+			assert.Equal(t, tc.existing[0].(*corev1.Secret).Data["other"], kubeconfigSecret.Data["other"])
+		})
+	}
+}
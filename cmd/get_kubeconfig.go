@@ -1,20 +1,37 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
+	"os/user"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clientauthenticationv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cluster-api/util/certs"
 
 	"capi-bootstrap/providers/backend"
+	"capi-bootstrap/state"
+	"capi-bootstrap/types"
 	"capi-bootstrap/yaml"
 )
 
 var getKubeconfigCmd = &cobra.Command{
 	Use:   "kubeconfig",
 	Short: "get kubeconfig for a cluster",
-	Long:  ``,
+	Long: `get the admin kubeconfig for a cluster, or issue a kubeconfig for a user with --user or for a ServiceAccount
+with --service-account. Issued kubeconfigs expire after --ttl, have no cluster state and are recorded in the audit
+entries of the cluster in the backend. With --exec the kubeconfig runs the credential command instead, which issues
+client certificates for the user when they are needed.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return runGetKubeconfig(cmd, args[0])
 	},
@@ -29,6 +46,16 @@ var getKubeconfigCmd = &cobra.Command{
 func init() {
 	getKubeconfigCmd.Flags().StringP("backend", "b", "",
 		"backend to use for retrieving the kubeconfig")
+	getKubeconfigCmd.Flags().String("user", "",
+		"issue a client certificate for this user from the client CA of the cluster")
+	getKubeconfigCmd.Flags().StringArray("group", nil,
+		"a group of the user of the client certificate, RBAC bindings can refer to it. Can be specified multiple times.")
+	getKubeconfigCmd.Flags().String("service-account", "",
+		"issue a token for the ServiceAccount <namespace>/<name> through the admin kubeconfig")
+	getKubeconfigCmd.Flags().Duration("ttl", 24*time.Hour,
+		"how long an issued client certificate or token is valid")
+	getKubeconfigCmd.Flags().Bool("exec", false,
+		"write a kubeconfig for --user that runs the credential command to get short-lived client certificates instead of embedding one")
 	getCmd.AddCommand(getKubeconfigCmd)
 }
 
@@ -50,6 +77,17 @@ func runGetKubeconfig(cmd *cobra.Command, clusterName string) error {
 	if err != nil {
 		return err
 	}
+	if execPlugin, _ := cmd.Flags().GetBool("exec"); execPlugin {
+		config, err = execKubeconfig(cmd, clusterName, backendName, config)
+		if err != nil {
+			return err
+		}
+	} else if cmd.Flags().Changed("user") || cmd.Flags().Changed("service-account") {
+		config, err = issueKubeconfig(cmd, clusterName, config, backendProvider)
+		if err != nil {
+			return err
+		}
+	}
 	kconf, err = yaml.Marshal(config)
 	if err != nil {
 		return err
@@ -58,3 +96,199 @@ func runGetKubeconfig(cmd *cobra.Command, clusterName string) error {
 	fmt.Println(string(kconf))
 	return nil
 }
+
+// issueKubeconfig returns a kubeconfig with a new client certificate or ServiceAccount token, which is recorded as an
+// audit entry in the backend before it is returned.
+func issueKubeconfig(cmd *cobra.Command, clusterName string, config *v1.Config, backendProvider backend.Provider) (*v1.Config, error) {
+	userName, _ := cmd.Flags().GetString("user")
+	groups, _ := cmd.Flags().GetStringArray("group")
+	serviceAccount, _ := cmd.Flags().GetString("service-account")
+	ttl, _ := cmd.Flags().GetDuration("ttl")
+	if (userName == "") == (serviceAccount == "") {
+		return nil, errors.New("please specify either a user or a service account")
+	}
+	if serviceAccount != "" && len(groups) != 0 {
+		return nil, errors.New("groups can only be set for a user")
+	}
+	if err := checkGroups(groups); err != nil {
+		return nil, err
+	}
+
+	var entry types.AuditEntry
+	var issued *v1.Config
+	if userName != "" {
+		var err error
+		issued, entry, err = issueUserKubeconfig(cmd.Context(), clusterName, config, userName, groups, ttl)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		client, err := kubeconfigClient(config)
+		if err != nil {
+			return nil, err
+		}
+		var expires time.Time
+		issued, expires, err = serviceAccountKubeconfig(cmd.Context(), client, config, serviceAccount, ttl)
+		if err != nil {
+			return nil, fmt.Errorf("could not issue a token for service account %s: %s", serviceAccount, err)
+		}
+		entry = types.AuditEntry{
+			Time:       time.Now().UTC(),
+			IssuedBy:   localUser(),
+			Credential: types.CredentialToken,
+			User:       serviceAccount,
+			Expires:    expires.UTC(),
+		}
+	}
+
+	if err := backendProvider.WriteAuditEntry(cmd.Context(), clusterName, entry); err != nil {
+		return nil, fmt.Errorf("could not write audit entry: %s", err)
+	}
+	klog.Infof("issued a %s for %s that expires at %s", entry.Credential, entry.User, entry.Expires.Format(time.RFC3339))
+	return issued, nil
+}
+
+// checkGroups returns an error if a group is privileged. Members of system:masters bypass RBAC and other system:
+// groups are reserved for the components of Kubernetes, client certificates issued for them can't be revoked.
+func checkGroups(groups []string) error {
+	for _, group := range groups {
+		if group == "system:masters" {
+			return errors.New("group system:masters bypasses RBAC, use the admin kubeconfig instead")
+		}
+		if strings.HasPrefix(group, "system:") {
+			return fmt.Errorf("group %s is reserved for Kubernetes components", group)
+		}
+	}
+	return nil
+}
+
+// issueUserKubeconfig returns a kubeconfig with a new client certificate for user and groups, which is issued by the
+// control plane provider of the cluster state in config, and the audit entry for it.
+func issueUserKubeconfig(ctx context.Context, clusterName string, config *v1.Config, userName string, groups []string, ttl time.Duration) (*v1.Config, types.AuditEntry, error) {
+	entry := types.AuditEntry{
+		Time:       time.Now().UTC(),
+		IssuedBy:   localUser(),
+		Credential: types.CredentialCertificate,
+		User:       userName,
+		Groups:     groups,
+	}
+	clusterState, err := state.NewState(config)
+	if err != nil {
+		return nil, entry, err
+	}
+	if clusterState.Values == nil || clusterState.ControlPlane == nil {
+		return nil, entry, fmt.Errorf("the state of cluster %s has no control plane", clusterName)
+	}
+	issued, err := clusterState.ControlPlane.GetUserKubeconfig(ctx, clusterState.Values, userName, groups, ttl)
+	if err != nil {
+		return nil, entry, fmt.Errorf("could not issue a client certificate for user %s: %s", userName, err)
+	}
+	cert, err := certs.DecodeCertPEM(issued.AuthInfos[0].AuthInfo.ClientCertificateData)
+	if err != nil {
+		return nil, entry, err
+	}
+	entry.Serial = cert.SerialNumber.Text(16)
+	entry.Expires = cert.NotAfter.UTC()
+	return issued, entry, nil
+}
+
+// execKubeconfig returns a kubeconfig for the cluster of config that runs the credential command of this executable to
+// get client certificates for the user, it contains no credentials.
+func execKubeconfig(cmd *cobra.Command, clusterName, backendName string, config *v1.Config) (*v1.Config, error) {
+	userName, _ := cmd.Flags().GetString("user")
+	groups, _ := cmd.Flags().GetStringArray("group")
+	ttl, _ := cmd.Flags().GetDuration("ttl")
+	if userName == "" || cmd.Flags().Changed("service-account") {
+		return nil, errors.New("please specify a user for --exec, service accounts are not supported")
+	}
+	if err := checkGroups(groups); err != nil {
+		return nil, err
+	}
+	cluster, err := currentCluster(config)
+	if err != nil {
+		return nil, err
+	}
+	command, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"credential", clusterName, "--backend", backendName, "--user", userName}
+	for _, group := range groups {
+		args = append(args, "--group", group)
+	}
+	args = append(args, "--ttl", ttl.String())
+	if cmd.Flags().Changed("config") {
+		args = append(args, "--config", configFile)
+	}
+	if profile != "" {
+		args = append(args, "--profile", profile)
+	}
+
+	contextName := fmt.Sprintf("%s@%s", userName, cluster.Name)
+	return &v1.Config{
+		Clusters: []v1.NamedCluster{cluster},
+		AuthInfos: []v1.NamedAuthInfo{{
+			Name: userName,
+			AuthInfo: v1.AuthInfo{Exec: &v1.ExecConfig{
+				APIVersion:      clientauthenticationv1.SchemeGroupVersion.String(),
+				Command:         command,
+				Args:            args,
+				InteractiveMode: v1.NeverExecInteractiveMode,
+			}},
+		}},
+		Contexts:       []v1.NamedContext{{Name: contextName, Context: v1.Context{Cluster: cluster.Name, AuthInfo: userName}}},
+		CurrentContext: contextName,
+	}, nil
+}
+
+// serviceAccountKubeconfig requests a token for the ServiceAccount referenced as <namespace>/<name> with client, which
+// uses the admin kubeconfig, and returns a kubeconfig for the cluster of adminConfig using it together with the expiry
+// of the token.
+func serviceAccountKubeconfig(ctx context.Context, client kubernetes.Interface, adminConfig *v1.Config, ref string, ttl time.Duration) (*v1.Config, time.Time, error) {
+	namespace, name, found := strings.Cut(ref, "/")
+	if !found || namespace == "" || name == "" {
+		return nil, time.Time{}, fmt.Errorf("invalid service account reference %q, expected <namespace>/<name>", ref)
+	}
+	cluster, err := currentCluster(adminConfig)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	tokenRequest, err := client.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, name, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: ptr.To(int64(ttl.Seconds()))},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	userName := fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)
+	contextName := fmt.Sprintf("%s@%s", name, cluster.Name)
+	return &v1.Config{
+		Clusters:       []v1.NamedCluster{cluster},
+		AuthInfos:      []v1.NamedAuthInfo{{Name: userName, AuthInfo: v1.AuthInfo{Token: tokenRequest.Status.Token}}},
+		Contexts:       []v1.NamedContext{{Name: contextName, Context: v1.Context{Cluster: cluster.Name, AuthInfo: userName}}},
+		CurrentContext: contextName,
+	}, tokenRequest.Status.ExpirationTimestamp.Time, nil
+}
+
+// currentCluster returns the cluster of the current context of config.
+func currentCluster(config *v1.Config) (v1.NamedCluster, error) {
+	for _, namedContext := range config.Contexts {
+		if namedContext.Name != config.CurrentContext {
+			continue
+		}
+		for _, cluster := range config.Clusters {
+			if cluster.Name == namedContext.Context.Cluster {
+				return cluster, nil
+			}
+		}
+	}
+	return v1.NamedCluster{}, fmt.Errorf("cluster of context %q not found in the kubeconfig", config.CurrentContext)
+}
+
+// localUser returns the name of the user running the command for audit entries.
+func localUser() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,347 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/k3s-io/cluster-api-k3s/bootstrap/api/v1beta1"
+	secrets "github.com/k3s-io/cluster-api-k3s/pkg/secret"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
+
+	mockBackend "capi-bootstrap/providers/backend/mock"
+	"capi-bootstrap/providers/controlplane/k3s"
+	"capi-bootstrap/state"
+	"capi-bootstrap/types"
+)
+
+// testAdminConfig returns an admin kubeconfig for test-cluster with a k3s control plane in its cluster state.
+func testAdminConfig(t *testing.T) *v1.Config {
+	t.Helper()
+	certificates := secrets.NewCertificatesForInitialControlPlane(&v1beta1.KThreesConfigSpec{})
+	require.NoError(t, certificates.Generate())
+	controlPlane := k3s.NewControlPlane()
+	controlPlane.Certs = certificates
+	clusterState, err := state.NewState(&v1.Config{
+		Clusters:       []v1.NamedCluster{{Name: "test-cluster", Cluster: v1.Cluster{Server: "https://10.0.0.1:6443"}}},
+		AuthInfos:      []v1.NamedAuthInfo{{Name: "test-cluster-admin", AuthInfo: v1.AuthInfo{Token: "test-admin-token"}}},
+		Contexts:       []v1.NamedContext{{Name: "test-cluster-admin@test-cluster", Context: v1.Context{Cluster: "test-cluster", AuthInfo: "test-cluster-admin"}}},
+		CurrentContext: "test-cluster-admin@test-cluster",
+	})
+	require.NoError(t, err)
+	clusterState.Values = &types.Values{ClusterName: "test-cluster", ClusterEndpoint: "10.0.0.1"}
+	clusterState.ControlPlane = controlPlane
+	config, err := clusterState.ToConfig()
+	require.NoError(t, err)
+	return config
+}
+
+func TestCheckGroups(t *testing.T) {
+	type test struct {
+		name    string
+		groups  []string
+		wantErr string
+	}
+	tests := []test{
+		{name: "success no groups"},
+		{name: "success groups", groups: []string{"developers", "system-admins"}},
+		{name: "err system:masters", groups: []string{"developers", "system:masters"}, wantErr: "group system:masters bypasses RBAC, use the admin kubeconfig instead"},
+		{name: "err system group", groups: []string{"system:nodes"}, wantErr: "group system:nodes is reserved for Kubernetes components"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := checkGroups(tc.groups)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestIssueKubeconfig(t *testing.T) {
+	config := testAdminConfig(t)
+	type test struct {
+		name           string
+		user           string
+		groups         []string
+		serviceAccount string
+		mockBackend    func(ctx context.Context, mock *mockBackend.MockProvider)
+		wantErr        string
+	}
+	tests := []test{
+		{
+			name:   "success user",
+			user:   "alice",
+			groups: []string{"developers"},
+			mockBackend: func(ctx context.Context, mock *mockBackend.MockProvider) {
+				mock.EXPECT().WriteAuditEntry(ctx, "test-cluster", gomock.Cond(func(x any) bool {
+					entry := x.(types.AuditEntry)
+					return entry.Credential == types.CredentialCertificate && entry.User == "alice" &&
+						assert.ObjectsAreEqual([]string{"developers"}, entry.Groups) && entry.Serial != "" &&
+						entry.Expires.After(time.Now().Add(23*time.Hour))
+				})).Return(nil)
+			},
+		},
+		{
+			name:    "err no user or service account",
+			wantErr: "please specify either a user or a service account",
+		},
+		{
+			name:           "err user and service account",
+			user:           "alice",
+			serviceAccount: "default/deployer",
+			wantErr:        "please specify either a user or a service account",
+		},
+		{
+			name:           "err service account groups",
+			serviceAccount: "default/deployer",
+			groups:         []string{"developers"},
+			wantErr:        "groups can only be set for a user",
+		},
+		{
+			name:    "err privileged group",
+			user:    "alice",
+			groups:  []string{"system:masters"},
+			wantErr: "group system:masters bypasses RBAC, use the admin kubeconfig instead",
+		},
+		{
+			name: "err audit entry",
+			user: "alice",
+			mockBackend: func(ctx context.Context, mock *mockBackend.MockProvider) {
+				mock.EXPECT().WriteAuditEntry(ctx, "test-cluster", gomock.Any()).Return(errors.New("access denied"))
+			},
+			wantErr: "could not write audit entry: access denied",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			ctrl := gomock.NewController(t)
+			backendProvider := mockBackend.NewMockProvider(ctrl)
+			if tc.mockBackend != nil {
+				tc.mockBackend(ctx, backendProvider)
+			}
+			cmd := &cobra.Command{}
+			cmd.Flags().String("user", tc.user, "")
+			cmd.Flags().StringArray("group", tc.groups, "")
+			cmd.Flags().String("service-account", tc.serviceAccount, "")
+			cmd.Flags().Duration("ttl", 24*time.Hour, "")
+			cmd.SetContext(ctx)
+			issued, err := issueKubeconfig(cmd, "test-cluster", config, backendProvider)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "alice@test-cluster", issued.CurrentContext)
+			assert.NotEmpty(t, issued.AuthInfos[0].AuthInfo.ClientCertificateData)
+		})
+	}
+}
+
+func TestServiceAccountKubeconfig(t *testing.T) {
+	expires := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	adminConfig := &v1.Config{
+		Clusters:       []v1.NamedCluster{{Name: "test-cluster", Cluster: v1.Cluster{Server: "https://10.0.0.1:6443"}}},
+		Contexts:       []v1.NamedContext{{Name: "test-cluster-admin@test-cluster", Context: v1.Context{Cluster: "test-cluster"}}},
+		CurrentContext: "test-cluster-admin@test-cluster",
+	}
+	type test struct {
+		name     string
+		ref      string
+		config   *v1.Config
+		reaction k8stesting.ReactionFunc
+		want     *v1.Config
+		wantErr  string
+	}
+	tests := []test{
+		{
+			name:   "success",
+			ref:    "default/deployer",
+			config: adminConfig,
+			reaction: func(action k8stesting.Action) (bool, runtime.Object, error) {
+				request := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+				if action.GetNamespace() != "default" || action.GetSubresource() != "token" ||
+					*request.Spec.ExpirationSeconds != 3600 {
+					return true, nil, errors.New("unexpected token request")
+				}
+				return true, &authenticationv1.TokenRequest{Status: authenticationv1.TokenRequestStatus{
+					Token:               "test-token",
+					ExpirationTimestamp: metav1.NewTime(expires),
+				}}, nil
+			},
+			want: &v1.Config{
+				Clusters: adminConfig.Clusters,
+				AuthInfos: []v1.NamedAuthInfo{{
+					Name:     "system:serviceaccount:default:deployer",
+					AuthInfo: v1.AuthInfo{Token: "test-token"},
+				}},
+				Contexts: []v1.NamedContext{{
+					Name:    "deployer@test-cluster",
+					Context: v1.Context{Cluster: "test-cluster", AuthInfo: "system:serviceaccount:default:deployer"},
+				}},
+				CurrentContext: "deployer@test-cluster",
+			},
+		},
+		{
+			name:    "err invalid reference",
+			ref:     "deployer",
+			config:  adminConfig,
+			wantErr: "invalid service account reference \"deployer\", expected <namespace>/<name>",
+		},
+		{
+			name:    "err no current cluster",
+			ref:     "default/deployer",
+			config:  &v1.Config{CurrentContext: "test-cluster-admin@test-cluster"},
+			wantErr: "cluster of context \"test-cluster-admin@test-cluster\" not found in the kubeconfig",
+		},
+		{
+			name:   "err create token",
+			ref:    "default/deployer",
+			config: adminConfig,
+			reaction: func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("serviceaccounts \"deployer\" not found")
+			},
+			wantErr: "serviceaccounts \"deployer\" not found",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			client := fake.NewClientset()
+			if tc.reaction != nil {
+				client.PrependReactor("create", "serviceaccounts", tc.reaction)
+			}
+			issued, gotExpires, err := serviceAccountKubeconfig(context.Background(), client, tc.config, tc.ref, time.Hour)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, issued)
+			assert.Equal(t, expires, gotExpires.UTC())
+		})
+	}
+}
+
+func TestCurrentCluster(t *testing.T) {
+	cluster := v1.NamedCluster{Name: "test-cluster", Cluster: v1.Cluster{Server: "https://10.0.0.1:6443"}}
+	type test struct {
+		name    string
+		config  *v1.Config
+		want    v1.NamedCluster
+		wantErr string
+	}
+	tests := []test{
+		{
+			name: "success",
+			config: &v1.Config{
+				Clusters: []v1.NamedCluster{{Name: "other-cluster"}, cluster},
+				Contexts: []v1.NamedContext{
+					{Name: "other", Context: v1.Context{Cluster: "other-cluster"}},
+					{Name: "admin@test-cluster", Context: v1.Context{Cluster: "test-cluster"}},
+				},
+				CurrentContext: "admin@test-cluster",
+			},
+			want: cluster,
+		},
+		{
+			name: "err context not found",
+			config: &v1.Config{
+				Clusters:       []v1.NamedCluster{cluster},
+				Contexts:       []v1.NamedContext{{Name: "admin@test-cluster", Context: v1.Context{Cluster: "test-cluster"}}},
+				CurrentContext: "other",
+			},
+			wantErr: "cluster of context \"other\" not found in the kubeconfig",
+		},
+		{
+			name: "err cluster not found",
+			config: &v1.Config{
+				Contexts:       []v1.NamedContext{{Name: "admin@test-cluster", Context: v1.Context{Cluster: "test-cluster"}}},
+				CurrentContext: "admin@test-cluster",
+			},
+			wantErr: "cluster of context \"admin@test-cluster\" not found in the kubeconfig",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := currentCluster(tc.config)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestExecKubeconfig(t *testing.T) {
+	config := testAdminConfig(t)
+	type test struct {
+		name           string
+		user           string
+		groups         []string
+		serviceAccount string
+		wantArgs       []string
+		wantErr        string
+	}
+	tests := []test{
+		{
+			name:     "success",
+			user:     "alice",
+			groups:   []string{"developers", "admins"},
+			wantArgs: []string{"credential", "test-cluster", "--backend", "s3", "--user", "alice", "--group", "developers", "--group", "admins", "--ttl", "1h0m0s"},
+		},
+		{name: "err no user", wantErr: "please specify a user for --exec, service accounts are not supported"},
+		{
+			name:           "err service account",
+			user:           "alice",
+			serviceAccount: "default/deployer",
+			wantErr:        "please specify a user for --exec, service accounts are not supported",
+		},
+		{
+			name:    "err privileged group",
+			user:    "alice",
+			groups:  []string{"system:masters"},
+			wantErr: "group system:masters bypasses RBAC, use the admin kubeconfig instead",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cmd := &cobra.Command{}
+			cmd.Flags().String("user", tc.user, "")
+			cmd.Flags().StringArray("group", tc.groups, "")
+			cmd.Flags().String("service-account", "", "")
+			cmd.Flags().Duration("ttl", time.Hour, "")
+			if tc.serviceAccount != "" {
+				require.NoError(t, cmd.Flags().Set("service-account", tc.serviceAccount))
+			}
+			issued, err := execKubeconfig(cmd, "test-cluster", "s3", config)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "alice@test-cluster", issued.CurrentContext)
+			assert.Equal(t, tc.wantArgs, issued.AuthInfos[0].AuthInfo.Exec.Args)
+			assert.Empty(t, issued.AuthInfos[0].AuthInfo.ClientCertificateData)
+		})
+	}
+}
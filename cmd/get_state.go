@@ -1,17 +1,45 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
 	"github.com/spf13/cobra"
+	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
+
+	"capi-bootstrap/providers/backend"
+	"capi-bootstrap/state"
+	"capi-bootstrap/types"
+	"capi-bootstrap/yaml"
 )
 
+// redactedValue replaces the secrets in the printed state.
+const redactedValue = "REDACTED"
+
+// redactedState is the part of a state.State that is printed. The providers are only printed by their name, as they
+// hold the CA key pairs of the cluster and the credentials of the backend.
+type redactedState struct {
+	Values         *types.Values
+	Infrastructure string
+	Backend        string
+	ControlPlane   string
+}
+
 var getStateCmd = &cobra.Command{
 	Use:   "state",
 	Short: "get the state file for a cluster",
-	Long:  ``,
+	Long: `get the state file for a cluster without its secrets. The bootstrap token is redacted and the providers are
+only shown by their name, the state kept in the backend also holds the CA key pairs of the cluster.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return runGetState(cmd, args[0])
 	},
 	Args: func(_ *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("please specify a cluster name")
+		}
 		return nil
 	},
 }
@@ -23,5 +51,76 @@ func init() {
 }
 
 func runGetState(cmd *cobra.Command, clusterName string) error {
-	return nil
+	backendName, err := cmd.Flags().GetString("backend")
+	if err != nil {
+		return err
+	}
+	backendProvider := backend.NewProvider(backendName)
+	if backendProvider == nil {
+		return errors.New("backend provider not specified, options are: " + strings.Join(backend.ListProviders(), ","))
+	}
+	if err := backendProvider.PreCmd(cmd.Context(), clusterName); err != nil {
+		return err
+	}
+
+	config, err := backendProvider.Read(cmd.Context(), clusterName)
+	if err != nil {
+		return err
+	}
+	return printState(cmd.OutOrStdout(), config)
+}
+
+// printState writes the redacted state kept in config as yaml to w.
+func printState(w io.Writer, config *v1.Config) error {
+	clusterState, err := state.NewState(config)
+	if err != nil {
+		return err
+	}
+	redacted, err := redactState(clusterState)
+	if err != nil {
+		return err
+	}
+	rawState, err := yaml.Marshal(redacted)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(rawState))
+	return err
+}
+
+func redactState(clusterState *state.State) (*redactedState, error) {
+	redacted := &redactedState{}
+	if clusterState.Values != nil {
+		values := *clusterState.Values
+		if values.BootstrapToken != "" {
+			values.BootstrapToken = redactedValue
+		}
+		redacted.Values = &values
+	}
+	var err error
+	if redacted.Infrastructure, err = providerName(clusterState.Infrastructure); err != nil {
+		return nil, err
+	}
+	if redacted.Backend, err = providerName(clusterState.Backend); err != nil {
+		return nil, err
+	}
+	if redacted.ControlPlane, err = providerName(clusterState.ControlPlane); err != nil {
+		return nil, err
+	}
+	return redacted, nil
+}
+
+// providerName returns the name a provider is stored with in the state, or an empty string if it is not set.
+func providerName(provider any) (string, error) {
+	raw, err := json.Marshal(provider)
+	if err != nil {
+		return "", err
+	}
+	named := struct {
+		Name string
+	}{}
+	if err := json.Unmarshal(raw, &named); err != nil {
+		return "", err
+	}
+	return named.Name, nil
 }
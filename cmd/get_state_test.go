@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/k3s-io/cluster-api-k3s/bootstrap/api/v1beta1"
+	secrets "github.com/k3s-io/cluster-api-k3s/pkg/secret"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
+
+	"capi-bootstrap/providers/backend/s3"
+	"capi-bootstrap/providers/controlplane/k3s"
+	"capi-bootstrap/state"
+	"capi-bootstrap/types"
+)
+
+func TestPrintState(t *testing.T) {
+	certificates := secrets.NewCertificatesForInitialControlPlane(&v1beta1.KThreesConfigSpec{})
+	require.NoError(t, certificates.Generate())
+	controlPlane := k3s.NewControlPlane()
+	controlPlane.Certs = certificates
+	backendProvider := s3.NewBackend()
+	backendProvider.AccessKey = "test-access-key"
+	backendProvider.SecretKey = "test-secret-key"
+
+	type test struct {
+		name       string
+		state      *state.State
+		want       []string
+		wantAbsent []string
+	}
+	tests := []test{
+		{
+			name: "success redacted",
+			state: &state.State{
+				Values: &types.Values{
+					ClusterName:    "test-cluster",
+					BootstrapToken: "test-token",
+					Versions:       types.Versions{CertManager: "v1.15.3"},
+				},
+				Backend:      backendProvider,
+				ControlPlane: controlPlane,
+			},
+			want: []string{
+				"ClusterName: test-cluster",
+				"BootstrapToken: " + redactedValue,
+				"CertManager: v1.15.3",
+				"Backend: s3",
+				"ControlPlane: KThreesControlPlane",
+				"Infrastructure: \"\"",
+			},
+			wantAbsent: []string{"PRIVATE KEY", "CERTIFICATE", "test-token", "test-access-key", "test-secret-key"},
+		},
+		{
+			name:  "success empty state",
+			state: &state.State{},
+			want:  []string{"Values: null", "Backend: \"\""},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			clusterState, err := state.NewState(&v1.Config{})
+			require.NoError(t, err)
+			clusterState.Values = tc.state.Values
+			clusterState.Backend = tc.state.Backend
+			clusterState.ControlPlane = tc.state.ControlPlane
+			config, err := clusterState.ToConfig()
+			require.NoError(t, err)
+
+			buf := &bytes.Buffer{}
+			require.NoError(t, printState(buf, config))
+			for _, want := range tc.want {
+				assert.Contains(t, buf.String(), want)
+			}
+			for _, absent := range tc.wantAbsent {
+				assert.NotContains(t, buf.String(), absent)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+
+	"capi-bootstrap/cloudinit"
+	"capi-bootstrap/providers/backend/noop"
+	"capi-bootstrap/types"
+	capiYaml "capi-bootstrap/yaml"
+)
+
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "render the cloud-config and files for a bootstrap machine without creating any resources",
+	Long: `Render the cloud-config and every file written by it for the bootstrap machine of a cluster.
+No infrastructure is created and no files are uploaded to a backend, all files are kept inline in the cloud-config.
+With --out the files are written at their target path as cloud-init writes them, including the files bundled by
+--tar-write-files. The runcmd is written to runcmd.sh and the parts of MIME user data to the parts directory.
+Without --out only the cloud-config is printed to stdout.`,
+	RunE: runRender,
+}
+
+type renderOptions struct {
+	out      string
+	endpoint string
+}
+
+var renderOpts = &renderOptions{}
+
+func init() {
+	addClusterFlags(renderCmd.Flags())
+
+	renderCmd.Flags().StringVarP(&renderOpts.out, "out", "o", "",
+		"The directory to write the cloud-config and the bootstrap files to. If unspecified, only the cloud-config is printed to stdout.")
+	renderCmd.Flags().StringVar(&renderOpts.endpoint, "endpoint", "127.0.0.1",
+		"The control plane endpoint to render the files with, since no load balancer is created.")
+
+	rootCmd.AddCommand(renderCmd)
+}
+
+func runRender(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+
+	values, infrastructureProvider, controlPlaneProvider, err := parseCluster(cmd)
+	if err != nil {
+		return err
+	}
+	if values.ClusterName == "" {
+		return errors.New("cluster name is empty")
+	}
+	values.DryRun = true
+	values.ClusterEndpoint = renderOpts.endpoint
+
+	if err := infrastructureProvider.PreCmd(ctx, values); err != nil {
+		return err
+	}
+
+	if err := infrastructureProvider.PreDeploy(ctx, values); err != nil {
+		return err
+	}
+
+	if err := controlPlaneProvider.PreDeploy(ctx, values); err != nil {
+		return err
+	}
+
+	cloudConfig, err := cloudinit.GenerateCloudConfig(ctx, values, infrastructureProvider, controlPlaneProvider, noop.NewBackend())
+	if err != nil {
+		return err
+	}
+	userData, err := cloudinit.MarshalUserData(cloudConfig, values.OutputFormat)
+	if err != nil {
+		return err
+	}
+
+	if renderOpts.out == "" {
+		_, err := cmd.OutOrStdout().Write(userData)
+		return err
+	}
+
+	if err := os.MkdirAll(renderOpts.out, 0o755); err != nil {
+		return fmt.Errorf("could not create output directory %s: %s", renderOpts.out, err)
+	}
+	cloudConfigPath := filepath.Join(renderOpts.out, "cloud-config.yaml")
+	switch values.OutputFormat {
+	case types.OutputFormatIgnition:
+		cloudConfigPath = filepath.Join(renderOpts.out, "config.ign")
+	case types.OutputFormatMIME:
+		cloudConfigPath = filepath.Join(renderOpts.out, "user-data.mime")
+	}
+	if err := os.WriteFile(cloudConfigPath, userData, 0o644); err != nil {
+		return fmt.Errorf("could not write user data %s: %s", cloudConfigPath, err)
+	}
+	klog.Infof("wrote %s", cloudConfigPath)
+
+	for _, file := range slices.Concat(cloudConfig.WriteFiles, cloudConfig.TarFiles) {
+		if err := writeRenderedFile(renderOpts.out, file); err != nil {
+			return err
+		}
+	}
+	return writeRenderedScripts(renderOpts.out, cloudConfig)
+}
+
+// writeRenderedFile writes file to its target path under dir, the owner of the file is not preserved. Unencoded files
+// are unescaped, as cloud-init renders them on the bootstrap machine. Paths that leave dir are rejected.
+func writeRenderedFile(dir string, file capiYaml.InitFile) error {
+	filePath := filepath.Join(dir, filepath.FromSlash(file.Path))
+	rel, err := filepath.Rel(dir, filePath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("path of file %s is outside of the output directory", file.Path)
+	}
+	content, err := file.DecodedContent()
+	if err != nil {
+		return err
+	}
+	if !file.IsBase64() && !file.IsGzip() {
+		content = []byte(capiYaml.UnescapeJinja(string(content)))
+	}
+	mode, err := file.FileMode()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return fmt.Errorf("could not create directory for %s: %s", filePath, err)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if file.Append {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(filePath, flags, mode)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %s", filePath, err)
+	}
+	if _, err := f.Write(content); err != nil {
+		return errors.Join(fmt.Errorf("could not write %s: %s", filePath, err), f.Close())
+	}
+	// the mode passed to OpenFile is only used for new files and is subject to the umask
+	if err := f.Chmod(mode); err != nil {
+		return errors.Join(fmt.Errorf("could not set permissions on %s: %s", filePath, err), f.Close())
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not close %s: %s", filePath, err)
+	}
+	klog.V(4).Infof("wrote %s", filePath)
+	return nil
+}
+
+// writeRenderedScripts writes the runcmd of cloudConfig to runcmd.sh and its MIME parts to the parts directory under
+// dir. The runcmd and the jinja parts are unescaped, as cloud-init renders them on the bootstrap machine.
+func writeRenderedScripts(dir string, cloudConfig *capiYaml.Config) error {
+	runCmds := make([]string, len(cloudConfig.RunCmd))
+	for i, cmd := range cloudConfig.RunCmd {
+		runCmds[i] = capiYaml.UnescapeJinja(cmd)
+	}
+	scripts := []capiYaml.InitFile{{
+		Path:        "runcmd.sh",
+		Content:     "#!/bin/sh\n" + strings.Join(runCmds, "\n") + "\n",
+		Permissions: "0755",
+	}}
+	for _, part := range cloudConfig.Parts {
+		script := capiYaml.InitFile{
+			Path:        path.Join("parts", part.Filename),
+			Content:     part.Content,
+			Permissions: "0755",
+		}
+		if part.ContentType != "text/jinja2" {
+			// only jinja parts are rendered, other parts are encoded so they are written as they are
+			script.Content = base64.StdEncoding.EncodeToString([]byte(part.Content))
+			script.Encoding = "b64"
+		}
+		scripts = append(scripts, script)
+	}
+	for _, script := range scripts {
+		if err := writeRenderedFile(dir, script); err != nil {
+			return err
+		}
+	}
+	return nil
+}
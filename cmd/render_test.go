@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	capiYaml "capi-bootstrap/yaml"
+)
+
+func TestWriteRenderedFile(t *testing.T) {
+	type test struct {
+		name     string
+		existing string
+		file     capiYaml.InitFile
+		want     string
+		wantMode os.FileMode
+		wantErr  string
+	}
+	tests := []test{
+		{
+			name:     "success unescaped",
+			file:     capiYaml.InitFile{Path: "/etc/test.yaml", Content: "name: \"{{ '{{ .Release.Name }}' }}\"\nregion: {{ ds.meta_data.region }}\n"},
+			want:     "name: \"{{ .Release.Name }}\"\nregion: {{ ds.meta_data.region }}\n",
+			wantMode: 0o644,
+		},
+		{
+			name:     "success encoded",
+			file:     capiYaml.InitFile{Path: "/etc/test.txt", Content: "e3sgJ3t7IH19JyB9fQ==", Encoding: "b64", Permissions: "0600"},
+			want:     "{{ '{{ }}' }}",
+			wantMode: 0o600,
+		},
+		{
+			name:     "success overwrite",
+			existing: "old\n",
+			file:     capiYaml.InitFile{Path: "/etc/hosts", Content: "new\n", Permissions: "0640"},
+			want:     "new\n",
+			wantMode: 0o640,
+		},
+		{
+			name:     "success append",
+			existing: "127.0.0.1 localhost\n",
+			file:     capiYaml.InitFile{Path: "/etc/hosts", Content: "10.0.0.1 test\n", Append: true},
+			want:     "127.0.0.1 localhost\n10.0.0.1 test\n",
+			wantMode: 0o644,
+		},
+		{
+			name:    "err invalid encoding",
+			file:    capiYaml.InitFile{Path: "/etc/test.txt", Content: "not base64", Encoding: "b64"},
+			wantErr: "could not decode base64 content of file /etc/test.txt: illegal base64 data at input byte 3",
+		},
+		{
+			name:    "err path outside of the output directory",
+			file:    capiYaml.InitFile{Path: "/etc/../../test.txt", Content: "test"},
+			wantErr: "path of file /etc/../../test.txt is outside of the output directory",
+		},
+		{
+			name:    "err relative path outside of the output directory",
+			file:    capiYaml.InitFile{Path: "../test.txt", Content: "test"},
+			wantErr: "path of file ../test.txt is outside of the output directory",
+		},
+		{
+			name:    "err output directory",
+			file:    capiYaml.InitFile{Path: "/", Content: "test"},
+			wantErr: "path of file / is outside of the output directory",
+		},
+		{
+			name:    "err invalid permissions",
+			file:    capiYaml.InitFile{Path: "/etc/test.txt", Permissions: "rwx"},
+			wantErr: `invalid permissions "rwx" for file /etc/test.txt: strconv.ParseUint: parsing "rwx": invalid syntax`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, filepath.FromSlash(tc.file.Path))
+			if tc.existing != "" {
+				require.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0o755))
+				require.NoError(t, os.WriteFile(filePath, []byte(tc.existing), 0o600))
+			}
+			err := writeRenderedFile(dir, tc.file)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			content, err := os.ReadFile(filePath)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, string(content))
+			info, err := os.Stat(filePath)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantMode, info.Mode().Perm())
+		})
+	}
+}
+
+func TestWriteRenderedScripts(t *testing.T) {
+	type test struct {
+		name        string
+		cloudConfig *capiYaml.Config
+		want        map[string]string
+	}
+	tests := []test{
+		{
+			name: "success runcmd",
+			cloudConfig: &capiYaml.Config{RunCmd: []string{
+				"bash /tmp/init-cluster.sh",
+				"echo \"{{ '{{ .Values }}' }}\" > /tmp/test",
+			}},
+			want: map[string]string{
+				"runcmd.sh": "#!/bin/sh\nbash /tmp/init-cluster.sh\necho \"{{ .Values }}\" > /tmp/test\n",
+			},
+		},
+		{
+			name: "success parts",
+			cloudConfig: &capiYaml.Config{Parts: []capiYaml.Part{
+				{Filename: "runcmd-init-cluster.sh", ContentType: "text/x-shellscript", Content: "#!/bin/bash\necho \"{{ '{{ .Values }}' }}\"\n"},
+				{Filename: "runcmd-post-init.sh", ContentType: "text/jinja2", Content: "## template: jinja\n#!/bin/bash\necho \"{{ '{{ .Values }}' }}\"\n"},
+			}},
+			want: map[string]string{
+				"runcmd.sh":                    "#!/bin/sh\n\n",
+				"parts/runcmd-init-cluster.sh": "#!/bin/bash\necho \"{{ '{{ .Values }}' }}\"\n",
+				"parts/runcmd-post-init.sh":    "## template: jinja\n#!/bin/bash\necho \"{{ .Values }}\"\n",
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			require.NoError(t, writeRenderedScripts(dir, tc.cloudConfig))
+			for name, want := range tc.want {
+				content, err := os.ReadFile(filepath.Join(dir, name))
+				require.NoError(t, err)
+				assert.Equal(t, want, string(content))
+			}
+		})
+	}
+}
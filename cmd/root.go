@@ -23,6 +23,8 @@ var (
 	configFile        string
 	profile           string
 	configFileDefault = filepath.Join("$XDG_CONFIG_HOME", "cluster-api", "bootstrap.yaml")
+	// appConfig is the configuration loaded from configFile
+	appConfig types.Config
 )
 
 var rootCmd = &cobra.Command{
@@ -80,6 +82,7 @@ func loadConfig(cmd *cobra.Command, args []string) error {
 	if err := yaml.Unmarshal(c, &config); err != nil {
 		return err
 	}
+	appConfig = config
 
 	// precedence: arg > profile > default
 	defaults := config.Defaults
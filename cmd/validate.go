@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	capi "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	"capi-bootstrap/cloudinit"
+	"capi-bootstrap/providers/controlplane"
+	"capi-bootstrap/providers/infrastructure"
+	"capi-bootstrap/types"
+	capiYaml "capi-bootstrap/yaml"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "validate the manifest of a cluster against the CAPI and provider API types",
+	Long: `Validate the manifest of a cluster without creating any resources. Every document of a kind known to CAPI or
+a supported provider is strictly decoded, unknown fields and fields of the wrong type are errors. References from the
+Cluster to the control plane, its machine template and the VPC have to resolve to objects in the manifest.
+The manifest is also validated at the start of cluster.`,
+	RunE: runValidate,
+}
+
+func init() {
+	addManifestFlags(validateCmd.Flags())
+
+	rootCmd.AddCommand(validateCmd)
+}
+
+func runValidate(cmd *cobra.Command, _ []string) error {
+	manifest, manifestName, err := readClusterManifest(cmd)
+	if err != nil {
+		return err
+	}
+	values := &types.Values{
+		ManifestFile: manifestName,
+		ManifestFS:   cloudinit.BytesFS(manifest),
+	}
+	if _, err := loadManifest(cmd.Context(), values); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(cmd.OutOrStdout(), "manifest %s is valid\n", manifestName)
+	return err
+}
+
+// loadManifest templates the manifest of values, parses it, resolves the topology of the Cluster and validates it.
+func loadManifest(ctx context.Context, values *types.Values) (*capiYaml.Manifest, error) {
+	manifestFile, err := capiYaml.ConstructFile(values.ManifestFile, values.ManifestFile, values.ManifestFS, values, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse manifest %s: %s", values.ManifestFile, err)
+	}
+	manifest, err := capiYaml.ParseManifest(manifestFile.Content)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse manifest %s: %s", values.ManifestFile, err)
+	}
+	if err := capiYaml.ResolveTopology(ctx, manifest); err != nil {
+		return nil, err
+	}
+	if err := validateManifest(manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s:\n%s", values.ManifestFile, err)
+	}
+	return manifest, nil
+}
+
+// validateManifest validates the manifest against the API types and references of CAPI and all providers.
+func validateManifest(manifest *capiYaml.Manifest) error {
+	scheme := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{capi.AddToScheme, infrastructure.AddToScheme, controlplane.AddToScheme} {
+		if err := addToScheme(scheme); err != nil {
+			return err
+		}
+	}
+	references := slices.Concat(capiYaml.ClusterReferences, infrastructure.References(), controlplane.References())
+	return capiYaml.ValidateManifest(manifest, scheme, references)
+}
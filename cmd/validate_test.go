@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"capi-bootstrap/cloudinit"
+	"capi-bootstrap/types"
+)
+
+const testValidManifest = `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: "[[[ .ClusterName ]]]"
+spec:
+  controlPlaneRef:
+    apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+    kind: KThreesControlPlane
+    name: test-cluster-control-plane
+  infrastructureRef:
+    apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+    kind: LinodeCluster
+    name: test-cluster
+---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+kind: KThreesControlPlane
+metadata:
+  name: test-cluster-control-plane
+spec:
+  machineTemplate:
+    infrastructureRef:
+      apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+      kind: LinodeMachineTemplate
+      name: test-cluster-control-plane
+---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeMachineTemplate
+metadata:
+  name: test-cluster-control-plane
+spec:
+  template:
+    spec:
+      type: g6-standard-2
+---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeCluster
+metadata:
+  name: test-cluster
+spec:
+  region: us-ord
+`
+
+func TestLoadManifest(t *testing.T) {
+	type test struct {
+		name     string
+		manifest string
+		wantErr  string
+	}
+	tests := []test{
+		{name: "success", manifest: testValidManifest},
+		{
+			name: "success firewallRef",
+			manifest: strings.Replace(testValidManifest, "      type: g6-standard-2\n", `      type: g6-standard-2
+      firewallRef:
+        apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+        kind: LinodeFirewall
+        name: test-cluster
+---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeFirewall
+metadata:
+  name: test-cluster
+`, 1),
+		},
+		{
+			name:     "err template",
+			manifest: strings.Replace(testValidManifest, "[[[ .ClusterName ]]]", "[[[ .Unknown ]]]", 1),
+			wantErr:  "could not parse manifest test.yaml: failed to execute template test.yaml, template: test.yaml:5:13: executing \"test.yaml\" at <.Unknown>: can't evaluate field Unknown in type *types.Values",
+		},
+		{
+			name:     "err unknown field",
+			manifest: strings.Replace(testValidManifest, "region: us-ord", "region: us-ord\n  zone: a", 1),
+			wantErr:  "invalid manifest test.yaml:\ndocument 3 (LinodeCluster test-cluster): unknown field \"spec.zone\"",
+		},
+		{
+			name:     "err reference",
+			manifest: strings.Replace(testValidManifest, "name: test-cluster-control-plane\nspec:\n  template", "name: other\nspec:\n  template", 1),
+			wantErr:  "invalid manifest test.yaml:\ndocument 1 (KThreesControlPlane test-cluster-control-plane): spec.machineTemplate.infrastructureRef: LinodeMachineTemplate test-cluster-control-plane not found in the manifest",
+		},
+		{
+			name:     "err firewallRef",
+			manifest: strings.Replace(testValidManifest, "      type: g6-standard-2\n", "      type: g6-standard-2\n      firewallRef:\n        name: test-cluster\n", 1),
+			wantErr:  "invalid manifest test.yaml:\ndocument 2 (LinodeMachineTemplate test-cluster-control-plane): spec.template.spec.firewallRef: LinodeFirewall test-cluster not found in the manifest",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			values := &types.Values{
+				ClusterName:  "test-cluster",
+				ManifestFile: "test.yaml",
+				ManifestFS:   cloudinit.BytesFS([]byte(tc.manifest)),
+			}
+			manifest, err := loadManifest(context.Background(), values)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, manifest)
+		})
+	}
+}
+
+func TestRunValidate(t *testing.T) {
+	type test struct {
+		name     string
+		manifest string
+		url      string
+		stdin    string
+		want     string
+		wantErr  string
+	}
+	tests := []test{
+		{name: "success", manifest: "-", stdin: testValidManifest, want: "manifest - is valid\n"},
+		{name: "err no manifest", wantErr: "one of --manifest or --from is required"},
+		{name: "err manifest and from", manifest: "-", url: "template.yaml", wantErr: "only one of --manifest and --from can be set"},
+	}
+	for _, tc := range tests {
+		// the flags of the manifest are kept in clusterOpts, so the cases can't run in parallel
+		t.Run(tc.name, func(t *testing.T) {
+			manifest, url := clusterOpts.manifest, clusterOpts.url
+			t.Cleanup(func() { clusterOpts.manifest, clusterOpts.url = manifest, url })
+
+			cmd := &cobra.Command{}
+			// defining the flags resets clusterOpts to their defaults
+			addManifestFlags(cmd.Flags())
+			clusterOpts.manifest, clusterOpts.url = tc.manifest, tc.url
+			out := &bytes.Buffer{}
+			cmd.SetOut(out)
+			cmd.SetIn(strings.NewReader(tc.stdin))
+			cmd.SetContext(context.Background())
+			err := runValidate(cmd, nil)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, out.String())
+		})
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"k8s.io/klog/v2"
 	k8syaml "sigs.k8s.io/yaml"
 
+	"capi-bootstrap/types"
 	capiYaml "capi-bootstrap/yaml"
 )
 
@@ -134,6 +135,47 @@ func (b *Backend) Read(ctx context.Context, clusterName string) (*v1.Config, err
 func (b *Backend) Delete(ctx context.Context, clusterName string) error {
 	klog.V(4).Infof("[github backend] trying to delete state files in remote repo: %s", clusterName)
 
+	if err := b.deletePath(ctx, path.Join("clusters", clusterName), fmt.Sprintf("deleting state files for cluster %s", clusterName)); err != nil {
+		return err
+	}
+
+	klog.Infof("[github backend] deleted all state files from branch %s in github repo %s/%s ", clusterName, b.Org, b.Repo)
+
+	return nil
+}
+
+// PurgeFiles deletes the files uploaded for the bootstrap machine of a cluster, the cluster state is kept. The files are
+// only removed from the head of the branch, they stay in its git history.
+func (b *Backend) PurgeFiles(ctx context.Context, clusterName string) error {
+	klog.V(4).Infof("[github backend] trying to purge bootstrap files in remote repo: %s", clusterName)
+
+	if err := b.deletePath(ctx, path.Join("clusters", clusterName, "files"), fmt.Sprintf("purging bootstrap files for cluster %s", clusterName)); err != nil {
+		return err
+	}
+
+	klog.Infof("[github backend] purged bootstrap files for cluster %s from branch %s in github repo %s/%s", clusterName, b.branchName, b.Org, b.Repo)
+	klog.Warningf("[github backend] the bootstrap files of cluster %s are kept in the git history of github repo %s/%s, "+
+		"rewrite the history of branch %s to remove them", clusterName, b.Org, b.Repo, b.branchName)
+
+	return nil
+}
+
+// WriteAuditEntry commits entry below the audit directory of a cluster, the name of the file is the time of the entry.
+func (b *Backend) WriteAuditEntry(ctx context.Context, clusterName string, entry types.AuditEntry) error {
+	y, err := k8syaml.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	remotePath := path.Join("clusters", clusterName, "audit", entry.FileName())
+	if _, err := b.uploadFile(ctx, string(y), remotePath, clusterName); err != nil {
+		return fmt.Errorf("failed to write cluster %s audit entry: %v", clusterName, err)
+	}
+	return nil
+}
+
+// deletePath commits the removal of remotePath and everything below it to the branch, nothing is committed if it
+// doesn't exist.
+func (b *Backend) deletePath(ctx context.Context, remotePath string, message string) error {
 	branch, _, err := b.client.Repositories.GetBranch(ctx, b.Org, b.Repo, b.branchName, 2)
 	if err != nil {
 		return err
@@ -144,14 +186,20 @@ func (b *Backend) Delete(ctx context.Context, clusterName string) error {
 		return err
 	}
 
+	found := false
 	for _, entry := range tree.Entries {
-		if strings.HasPrefix(entry.GetPath(), path.Join("clusters", clusterName)) {
+		if entry.GetPath() == remotePath || strings.HasPrefix(entry.GetPath(), remotePath+"/") {
 			// set content and sha to nil, which tells git you are deleting this file
 			entry.SHA = nil
 			entry.Content = nil
+			found = true
 			continue
 		}
 	}
+	if !found {
+		klog.V(4).Infof("[github backend] %s does not exist in remote repo %s/%s", remotePath, b.Org, b.Repo)
+		return nil
+	}
 
 	nt, _, err := b.client.Git.CreateTree(ctx, b.Org, b.Repo, b.branch.Commit.GetSHA(), tree.Entries)
 	if err != nil {
@@ -173,8 +221,9 @@ func (b *Backend) Delete(ctx context.Context, clusterName string) error {
 			Email: b.user.Email,
 			Login: b.user.Login,
 		},
-		Parents: b.branch.GetCommit().Parents,
-		Message: PointerTo(fmt.Sprintf("deleting state files for cluster %s", clusterName)),
+		// the new commit follows the head of the branch, the parents of the head would drop its changes
+		Parents: []*github.Commit{{SHA: b.branch.GetCommit().SHA}},
+		Message: PointerTo(message),
 		// Verification: nil, // TODO sign commits
 	}
 
@@ -191,13 +240,8 @@ func (b *Backend) Delete(ctx context.Context, clusterName string) error {
 		},
 	}
 
-	if _, _, err = b.client.Git.UpdateRef(ctx, b.Org, b.Repo, ref, true); err != nil {
-		return err
-	}
-
-	klog.Infof("[github backend] deleted all state files from branch %s in github repo %s/%s ", clusterName, b.Org, b.Repo)
-
-	return nil
+	_, _, err = b.client.Git.UpdateRef(ctx, b.Org, b.Repo, ref, true)
+	return err
 }
 
 func (b *Backend) ListClusters(ctx context.Context) (map[string]*v1.Config, error) {
@@ -288,10 +332,19 @@ func (b *Backend) writeFile(ctx context.Context, clusterName string, cloudInitFi
 		return "", nil, fmt.Errorf("couldn't upload object: %v", err)
 	}
 
-	cloudInitFile.Content = ""
 	klog.V(4).Infof("[github backend] updated existing state file %s for cluster %s in remote repo %s/%s", remotePath, clusterName, b.Org, b.Repo)
 
-	downloadCmd := fmt.Sprintf("curl -sL -H 'Accept: application/vnd.github.raw+json' -H 'Authorization: Bearer %s' -H 'X-GitHub-Api-Version: 2022-11-28' '%s' | xargs -0 cloud-init query -f > %s", b.Token, downloadURL, cloudInitFile.Path)
+	headers := map[string]string{
+		"Accept":               "application/vnd.github.raw+json",
+		"Authorization":        "Bearer " + b.Token,
+		"X-GitHub-Api-Version": "2022-11-28",
+	}
+	downloadCmd, err := cloudInitFile.InstallCmd(downloadURL, headers)
+	if err != nil {
+		return "", nil, err
+	}
+	cloudInitFile.Content = ""
+	cloudInitFile.Source = capiYaml.Source{URI: downloadURL, Headers: headers}
 	return downloadCmd, &cloudInitFile, nil
 }
 
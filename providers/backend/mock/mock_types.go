@@ -10,6 +10,7 @@
 package mock_backend
 
 import (
+	types "capi-bootstrap/types"
 	yaml "capi-bootstrap/yaml"
 	context "context"
 	reflect "reflect"
@@ -84,6 +85,20 @@ func (mr *MockProviderMockRecorder) PreCmd(ctx, clusterName any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PreCmd", reflect.TypeOf((*MockProvider)(nil).PreCmd), ctx, clusterName)
 }
 
+// PurgeFiles mocks base method.
+func (m *MockProvider) PurgeFiles(ctx context.Context, clusterName string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeFiles", ctx, clusterName)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PurgeFiles indicates an expected call of PurgeFiles.
+func (mr *MockProviderMockRecorder) PurgeFiles(ctx, clusterName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeFiles", reflect.TypeOf((*MockProvider)(nil).PurgeFiles), ctx, clusterName)
+}
+
 // Read mocks base method.
 func (m *MockProvider) Read(ctx context.Context, clusterName string) (*v1.Config, error) {
 	m.ctrl.T.Helper()
@@ -99,6 +114,20 @@ func (mr *MockProviderMockRecorder) Read(ctx, clusterName any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Read", reflect.TypeOf((*MockProvider)(nil).Read), ctx, clusterName)
 }
 
+// WriteAuditEntry mocks base method.
+func (m *MockProvider) WriteAuditEntry(ctx context.Context, clusterName string, entry types.AuditEntry) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteAuditEntry", ctx, clusterName, entry)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteAuditEntry indicates an expected call of WriteAuditEntry.
+func (mr *MockProviderMockRecorder) WriteAuditEntry(ctx, clusterName, entry any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteAuditEntry", reflect.TypeOf((*MockProvider)(nil).WriteAuditEntry), ctx, clusterName, entry)
+}
+
 // WriteConfig mocks base method.
 func (m *MockProvider) WriteConfig(ctx context.Context, clusterName string, config *v1.Config) error {
 	m.ctrl.T.Helper()
@@ -0,0 +1,56 @@
+package noop
+
+import (
+	"context"
+	"errors"
+
+	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
+
+	"capi-bootstrap/types"
+	capiYaml "capi-bootstrap/yaml"
+)
+
+var ErrNoState = errors.New("the noop backend does not store any cluster state")
+
+// NewBackend returns a backend that does not store anything, all files are kept inline in the cloud-config.
+func NewBackend() *Backend {
+	return &Backend{
+		Name: "noop",
+	}
+}
+
+type Backend struct {
+	Name string
+}
+
+func (b *Backend) PreCmd(_ context.Context, _ string) error {
+	return nil
+}
+
+func (b *Backend) Read(_ context.Context, _ string) (*v1.Config, error) {
+	return nil, ErrNoState
+}
+
+func (b *Backend) WriteConfig(_ context.Context, _ string, _ *v1.Config) error {
+	return nil
+}
+
+func (b *Backend) WriteFiles(_ context.Context, _ string, _ *capiYaml.Config) ([]string, error) {
+	return nil, nil
+}
+
+func (b *Backend) Delete(_ context.Context, _ string) error {
+	return nil
+}
+
+func (b *Backend) PurgeFiles(_ context.Context, _ string) error {
+	return nil
+}
+
+func (b *Backend) ListClusters(_ context.Context) (map[string]*v1.Config, error) {
+	return map[string]*v1.Config{}, nil
+}
+
+func (b *Backend) WriteAuditEntry(_ context.Context, _ string, _ types.AuditEntry) error {
+	return nil
+}
@@ -21,12 +21,21 @@ import (
 	"k8s.io/utils/ptr"
 	k8syaml "sigs.k8s.io/yaml"
 
+	"capi-bootstrap/types"
 	capiYaml "capi-bootstrap/yaml"
 )
 
+const (
+	// defaultPresignExpiry is how long the presigned URLs of uploaded files are valid if AWS_PRESIGN_EXPIRY is not set
+	defaultPresignExpiry = time.Hour
+	// maxPresignExpiry is the longest expiry S3 accepts for presigned URLs
+	maxPresignExpiry = 7 * 24 * time.Hour
+)
+
 func NewBackend() *Backend {
 	return &Backend{
-		Name: "s3",
+		Name:          "s3",
+		PresignExpiry: defaultPresignExpiry,
 	}
 }
 
@@ -49,6 +58,7 @@ type Backend struct {
 	BucketName    string
 	AccessKey     string
 	SecretKey     string
+	PresignExpiry time.Duration `json:"-"`
 	Client        S3Client      `json:"-"`
 	PresignClient PresignClient `json:"-"`
 }
@@ -67,6 +77,18 @@ func (b *Backend) PreCmd(_ context.Context, _ string) error {
 		return errors.New("AWS_SECRET_KEY environment variable not set")
 	}
 
+	b.PresignExpiry = defaultPresignExpiry
+	if expiry := os.Getenv("AWS_PRESIGN_EXPIRY"); expiry != "" {
+		presignExpiry, err := time.ParseDuration(expiry)
+		if err != nil {
+			return fmt.Errorf("invalid AWS_PRESIGN_EXPIRY %q: %s", expiry, err)
+		}
+		if presignExpiry <= 0 || presignExpiry > maxPresignExpiry {
+			return fmt.Errorf("invalid AWS_PRESIGN_EXPIRY %q: must be between 1s and %s", expiry, maxPresignExpiry)
+		}
+		b.PresignExpiry = presignExpiry
+	}
+
 	b.Endpoint = os.Getenv("AWS_ENDPOINT")
 	b.Region = os.Getenv("AWS_REGION")
 	b.Client = s3.New(s3.Options{
@@ -153,13 +175,17 @@ func (b *Backend) writeFile(ctx context.Context, clusterName string, cloudInitFi
 			Bucket: &b.BucketName,
 			Key:    &filePath,
 		},
-		s3.WithPresignExpires(time.Hour*1), // URL expires in 1 hour
+		s3.WithPresignExpires(b.PresignExpiry),
 	)
 	if err != nil {
 		return "nil", nil, fmt.Errorf("couldn't get presigned URL for object: %v", err)
 	}
+	downloadCmd, err := cloudInitFile.InstallCmd(request.URL, nil)
+	if err != nil {
+		return "", nil, err
+	}
 	cloudInitFile.Content = ""
-	downloadCmd := fmt.Sprintf("curl -s '%s' | xargs -0 cloud-init query -f > %s", request.URL, cloudInitFile.Path)
+	cloudInitFile.Source = capiYaml.Source{URI: request.URL}
 	return downloadCmd, &cloudInitFile, nil
 }
 
@@ -210,26 +236,58 @@ func (b *Backend) WriteFiles(ctx context.Context, clusterName string, cloudInitC
 }
 
 func (b *Backend) Delete(ctx context.Context, clusterName string) error {
-	clusterDir := path.Join("clusters", clusterName)
-	objects, err := b.Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
-		Bucket: &b.BucketName,
-		Prefix: &clusterDir,
-	})
+	// the trailing slash keeps the objects of clusters whose name starts with clusterName
+	return b.deleteObjects(ctx, path.Join("clusters", clusterName)+"/")
+}
+
+// PurgeFiles deletes the files uploaded for the bootstrap machine of a cluster, the cluster state is kept.
+func (b *Backend) PurgeFiles(ctx context.Context, clusterName string) error {
+	return b.deleteObjects(ctx, path.Join("clusters", clusterName, "files")+"/")
+}
+
+// WriteAuditEntry uploads entry below the audit prefix of a cluster, the name of the object is the time of the entry.
+func (b *Backend) WriteAuditEntry(ctx context.Context, clusterName string, entry types.AuditEntry) error {
+	y, err := k8syaml.Marshal(entry)
 	if err != nil {
-		return fmt.Errorf("couldn't list objects: %v", err)
-	}
-	objectsToDelete := make([]s3types.ObjectIdentifier, *objects.KeyCount)
-	for i, object := range objects.Contents {
-		objectsToDelete[i] = s3types.ObjectIdentifier{Key: object.Key}
+		return err
 	}
-	_, err = b.Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
-		Bucket: &b.BucketName,
-		Delete: &s3types.Delete{
-			Objects: objectsToDelete,
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("couldn't delete objects: %v", err)
+	filePath := path.Join("clusters", clusterName, "audit", entry.FileName())
+	if err := b.uploadFile(ctx, string(y), filePath); err != nil {
+		return fmt.Errorf("couldn't upload object: %v", err)
 	}
 	return nil
 }
+
+// deleteObjects deletes all objects with the prefix, a page of objects at a time.
+func (b *Backend) deleteObjects(ctx context.Context, prefix string) error {
+	var continuationToken *string
+	for {
+		objects, err := b.Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:            &b.BucketName,
+			Prefix:            &prefix,
+			ContinuationToken: continuationToken,
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't list objects: %v", err)
+		}
+		objectsToDelete := make([]s3types.ObjectIdentifier, len(objects.Contents))
+		for i, object := range objects.Contents {
+			objectsToDelete[i] = s3types.ObjectIdentifier{Key: object.Key}
+		}
+		if len(objectsToDelete) != 0 {
+			_, err = b.Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+				Bucket: &b.BucketName,
+				Delete: &s3types.Delete{
+					Objects: objectsToDelete,
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("couldn't delete objects: %v", err)
+			}
+		}
+		if !ptr.Deref(objects.IsTruncated, false) {
+			return nil
+		}
+		continuationToken = objects.NextContinuationToken
+	}
+}
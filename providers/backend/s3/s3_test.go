@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"testing"
+	"time"
 
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -17,6 +18,7 @@ import (
 	"k8s.io/utils/ptr"
 
 	mockClient "capi-bootstrap/providers/backend/s3/mock"
+	"capi-bootstrap/types"
 	capiYaml "capi-bootstrap/yaml"
 )
 
@@ -28,7 +30,9 @@ func TestS3_PreCmd(t *testing.T) {
 		bucketName  string
 		endpoint    string
 		region      string
+		expiry      string
 		clusterName string
+		wantExpiry  time.Duration
 		err         string
 	}
 
@@ -41,11 +45,22 @@ func TestS3_PreCmd(t *testing.T) {
 			endpoint:    "test-endpoint.com",
 			region:      "us-east",
 			clusterName: "test-cluster",
+			wantExpiry:  time.Hour,
 			err:         "",
 		},
+		{
+			name:       "success presign expiry",
+			accessKey:  "access_key",
+			secretKey:  "secret_key",
+			bucketName: "test-bucket",
+			expiry:     "15m",
+			wantExpiry: 15 * time.Minute,
+		},
 		{name: "err no bucket name", err: "AWS_BUCKET_NAME environment variable not set"},
 		{name: "err no access key", bucketName: "test", err: "AWS_ACCESS_KEY environment variable not set"},
 		{name: "err no secret key", bucketName: "test", accessKey: "test-key", err: "AWS_SECRET_KEY environment variable not set"},
+		{name: "err invalid presign expiry", bucketName: "test", accessKey: "test-key", secretKey: "test-secret", expiry: "1 hour", err: "invalid AWS_PRESIGN_EXPIRY \"1 hour\": time: unknown unit \" hour\" in duration \"1 hour\""},
+		{name: "err presign expiry too long", bucketName: "test", accessKey: "test-key", secretKey: "test-secret", expiry: "720h", err: "invalid AWS_PRESIGN_EXPIRY \"720h\": must be between 1s and 168h0m0s"},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -54,6 +69,7 @@ func TestS3_PreCmd(t *testing.T) {
 			t.Setenv("AWS_REGION", tc.region)
 			t.Setenv("AWS_ACCESS_KEY", tc.accessKey)
 			t.Setenv("AWS_SECRET_KEY", tc.secretKey)
+			t.Setenv("AWS_PRESIGN_EXPIRY", tc.expiry)
 			ctx := context.Background()
 			testBackend := Backend{}
 			err := testBackend.PreCmd(ctx, tc.clusterName)
@@ -65,6 +81,7 @@ func TestS3_PreCmd(t *testing.T) {
 				assert.Equal(t, testBackend.SecretKey, tc.secretKey)
 				assert.Equal(t, testBackend.Region, tc.region)
 				assert.Equal(t, testBackend.BucketName, tc.bucketName)
+				assert.Equal(t, tc.wantExpiry, testBackend.PresignExpiry)
 				assert.NotNil(t, testBackend.Client)
 				assert.NotNil(t, testBackend.PresignClient)
 			}
@@ -238,6 +255,76 @@ users: null
 	}
 }
 
+func TestS3_WriteAuditEntry(t *testing.T) {
+	type test struct {
+		name       string
+		wantErr    string
+		mockClient func(ctx context.Context, t *testing.T, mock *mockClient.MockS3Client) *mockClient.MockS3Client
+	}
+	tests := []test{
+		{
+			name: "success",
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockS3Client) *mockClient.MockS3Client {
+				mock.EXPECT().
+					PutObject(ctx, gomock.Cond(func(x any) bool {
+						assert.Equal(t, `test-bucket`, *x.(*s3.PutObjectInput).Bucket)
+						assert.Equal(t, `clusters/test-cluster/audit/20240601T120000.000000000Z-certificate.yaml`, *x.(*s3.PutObjectInput).Key)
+						entry, err := io.ReadAll(x.(*s3.PutObjectInput).Body)
+						assert.NoError(t, err)
+						assert.Equal(t, `Credential: certificate
+Expires: "2024-06-02T12:00:00Z"
+Groups:
+- developers
+IssuedBy: admin
+Serial: 2a
+Time: "2024-06-01T12:00:00Z"
+User: alice
+`, string(entry))
+						return true
+					})).
+					Return(nil, nil)
+				return mock
+			},
+		},
+		{
+			name: "err upload failure",
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockS3Client) *mockClient.MockS3Client {
+				mock.EXPECT().
+					PutObject(ctx, gomock.Any()).
+					Return(nil, errors.New("s3 failure"))
+				return mock
+			},
+			wantErr: "couldn't upload object: s3 failure",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctrl := gomock.NewController(t)
+			mock := mockClient.NewMockS3Client(ctrl)
+			ctx := context.Background()
+			testBackend := NewBackend()
+			testBackend.BucketName = "test-bucket"
+			testBackend.Client = tc.mockClient(ctx, t, mock)
+			issued := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+			err := testBackend.WriteAuditEntry(ctx, "test-cluster", types.AuditEntry{
+				Time:       issued,
+				IssuedBy:   "admin",
+				Credential: types.CredentialCertificate,
+				User:       "alice",
+				Groups:     []string{"developers"},
+				Serial:     "2a",
+				Expires:    issued.Add(24 * time.Hour),
+			})
+			if tc.wantErr != "" {
+				assert.EqualErrorf(t, err, tc.wantErr, "expected error message: %s", tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
 func TestS3_WriteFiles(t *testing.T) {
 	type test struct {
 		name              string
@@ -332,8 +419,11 @@ func TestS3_WriteFiles(t *testing.T) {
 					Content: "This is test file 1",
 				},
 					{
-						Path:    "/tmp/test2.yaml",
-						Content: "This is test file 2",
+						Path:        "/tmp/test2.yaml",
+						Content:     "VGhpcyBpcyB0ZXN0IGZpbGUgMg==",
+						Encoding:    "b64",
+						Owner:       "k3s:k3s",
+						Permissions: "0600",
 					}},
 				RunCmd: []string{"echo hello"},
 			}
@@ -342,9 +432,15 @@ func TestS3_WriteFiles(t *testing.T) {
 				assert.EqualErrorf(t, err, tc.wantErr, "expected error message: %s", tc.wantErr)
 			} else {
 				assert.NoError(t, err)
-				assert.Equal(t, []string{"curl -s 'signed.test.com/tmp/test1.yaml' | xargs -0 cloud-init query -f > /tmp/test1.yaml", "curl -s 'signed.test.com/tmp/test2.yaml' | xargs -0 cloud-init query -f > /tmp/test2.yaml"}, newCmds)
+				assert.Equal(t, []string{
+					"tmp=\"$(mktemp)\" && /usr/local/bin/capi-bootstrap-fetch 6bc06e3ca33e34e1ae526af6165c9e7903eaacc653431612a3f865b3eda806ef \"$tmp\" 'signed.test.com/tmp/test1.yaml' && " +
+						"install -D -m 0644 -o 'root' -g 'root' /dev/null '/tmp/test1.yaml' && xargs -0 cloud-init query -f < \"$tmp\" > '/tmp/test1.yaml' && rm -f \"$tmp\" || exit 1",
+					"tmp=\"$(mktemp)\" && /usr/local/bin/capi-bootstrap-fetch 3e50fe7d9f7e18a0f1cc0147ee74560a852ce81a1da556dd0fc4a8c8d61b32b5 \"$tmp\" 'signed.test.com/tmp/test2.yaml' && " +
+						"install -D -m 0600 -o 'k3s' -g 'k3s' /dev/null '/tmp/test2.yaml' && base64 -d \"$tmp\" > '/tmp/test2.yaml' && rm -f \"$tmp\" || exit 1",
+				}, newCmds)
 				for _, file := range cloudInitFile.WriteFiles {
 					assert.Empty(t, file.Content)
+					assert.Equal(t, "signed.test.com"+file.Path, file.Source.URI)
 				}
 			}
 		})
@@ -368,7 +464,7 @@ func TestS3_Delete(t *testing.T) {
 				mock.EXPECT().
 					ListObjectsV2(ctx, gomock.Cond(func(x any) bool {
 						assert.Equal(t, `test-bucket`, *x.(*s3.ListObjectsV2Input).Bucket)
-						assert.Equal(t, `clusters/test-cluster`, *x.(*s3.ListObjectsV2Input).Prefix)
+						assert.Equal(t, `clusters/test-cluster/`, *x.(*s3.ListObjectsV2Input).Prefix)
 						return true
 					})).
 					Return(&s3.ListObjectsV2Output{
@@ -456,6 +552,94 @@ func TestS3_Delete(t *testing.T) {
 	}
 }
 
+func TestS3_PurgeFiles(t *testing.T) {
+	type test struct {
+		name        string
+		bucketName  string
+		clusterName string
+		wantErr     string
+		mockClient  func(ctx context.Context, t *testing.T, mock *mockClient.MockS3Client) *mockClient.MockS3Client
+	}
+	tests := []test{
+		{
+			name:        "success",
+			bucketName:  "test-bucket",
+			clusterName: "test-cluster",
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockS3Client) *mockClient.MockS3Client {
+				mock.EXPECT().
+					ListObjectsV2(ctx, gomock.Cond(func(x any) bool {
+						assert.Equal(t, `clusters/test-cluster/files/`, *x.(*s3.ListObjectsV2Input).Prefix)
+						return x.(*s3.ListObjectsV2Input).ContinuationToken == nil
+					})).
+					Return(&s3.ListObjectsV2Output{
+						Contents:              []s3Types.Object{{Key: ptr.To("clusters/test-cluster/files/tmp/file1.yaml")}},
+						IsTruncated:           ptr.To(true),
+						NextContinuationToken: ptr.To("next"),
+					}, nil)
+				mock.EXPECT().
+					ListObjectsV2(ctx, gomock.Cond(func(x any) bool {
+						return ptr.Deref(x.(*s3.ListObjectsV2Input).ContinuationToken, "") == "next"
+					})).
+					Return(&s3.ListObjectsV2Output{
+						Contents: []s3Types.Object{{Key: ptr.To("clusters/test-cluster/files/tmp/file2.yaml")}},
+					}, nil)
+				mock.EXPECT().
+					DeleteObjects(ctx, gomock.Cond(func(x any) bool {
+						assert.Equal(t, `test-bucket`, *x.(*s3.DeleteObjectsInput).Bucket)
+						return true
+					})).
+					Return(&s3.DeleteObjectsOutput{}, nil).Times(2)
+				return mock
+			},
+		},
+		{
+			name:        "success already purged",
+			bucketName:  "test-bucket",
+			clusterName: "test-cluster",
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockS3Client) *mockClient.MockS3Client {
+				mock.EXPECT().
+					ListObjectsV2(ctx, gomock.Any()).
+					Return(&s3.ListObjectsV2Output{KeyCount: ptr.To(int32(0))}, nil)
+				return mock
+			},
+		},
+		{
+			name:        "err delete objects",
+			bucketName:  "test-bucket",
+			clusterName: "test-cluster",
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockS3Client) *mockClient.MockS3Client {
+				mock.EXPECT().
+					ListObjectsV2(ctx, gomock.Any()).
+					Return(&s3.ListObjectsV2Output{
+						Contents: []s3Types.Object{{Key: ptr.To("clusters/test-cluster/files/tmp/file1.yaml")}},
+					}, nil)
+				mock.EXPECT().
+					DeleteObjects(ctx, gomock.Any()).
+					Return(nil, errors.New("s3 error"))
+				return mock
+			},
+			wantErr: "couldn't delete objects: s3 error",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctrl := gomock.NewController(t)
+			mock := mockClient.NewMockS3Client(ctrl)
+			ctx := context.Background()
+			testBackend := NewBackend()
+			testBackend.BucketName = tc.bucketName
+			testBackend.Client = tc.mockClient(ctx, t, mock)
+			err := testBackend.PurgeFiles(ctx, tc.clusterName)
+			if tc.wantErr != "" {
+				assert.EqualErrorf(t, err, tc.wantErr, "expected error message: %s", tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
 func TestS3_List(t *testing.T) {
 	type test struct {
 		name        string
@@ -5,6 +5,7 @@ import (
 
 	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
 
+	"capi-bootstrap/types"
 	capiYaml "capi-bootstrap/yaml"
 )
 
@@ -14,5 +15,9 @@ type Provider interface {
 	WriteConfig(ctx context.Context, clusterName string, config *v1.Config) error
 	WriteFiles(ctx context.Context, clusterName string, cloudInitFile *capiYaml.Config) ([]string, error)
 	Delete(ctx context.Context, clusterName string) error
+	// PurgeFiles deletes the files uploaded by WriteFiles once the bootstrap machine no longer needs them.
+	PurgeFiles(ctx context.Context, clusterName string) error
 	ListClusters(context.Context) (map[string]*v1.Config, error)
+	// WriteAuditEntry stores entry next to the state of a cluster, entries are never overwritten.
+	WriteAuditEntry(ctx context.Context, clusterName string, entry types.AuditEntry) error
 }
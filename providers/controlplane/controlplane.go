@@ -1,6 +1,11 @@
 package controlplane
 
-import "capi-bootstrap/providers/controlplane/k3s"
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"capi-bootstrap/providers/controlplane/k3s"
+	capiYaml "capi-bootstrap/yaml"
+)
 
 func NewProvider(name string) Provider {
 	switch name {
@@ -10,3 +15,13 @@ func NewProvider(name string) Provider {
 		return nil
 	}
 }
+
+// AddToScheme registers the API types of all control plane providers, manifests are validated against them.
+func AddToScheme(scheme *runtime.Scheme) error {
+	return k3s.AddToScheme(scheme)
+}
+
+// References returns the references between the objects of all control plane providers.
+func References() []capiYaml.Reference {
+	return k3s.References
+}
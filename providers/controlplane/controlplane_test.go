@@ -4,6 +4,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 
 	"capi-bootstrap/providers/controlplane/k3s"
 )
@@ -27,3 +30,12 @@ func TestNewProvider(t *testing.T) {
 		})
 	}
 }
+
+func TestAddToScheme(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, AddToScheme(scheme))
+	assert.True(t, scheme.Recognizes(schema.GroupVersionKind{Group: "controlplane.cluster.x-k8s.io", Version: "v1beta1", Kind: "KThreesControlPlane"}))
+	for _, kind := range []string{"KThreesConfig", "KThreesConfigTemplate"} {
+		assert.True(t, scheme.Recognizes(schema.GroupVersionKind{Group: "bootstrap.cluster.x-k8s.io", Version: "v1beta1", Kind: kind}), kind)
+	}
+}
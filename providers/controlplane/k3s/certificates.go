@@ -0,0 +1,167 @@
+package k3s
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"math/big"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/k3s-io/cluster-api-k3s/bootstrap/api/v1beta1"
+	secrets "github.com/k3s-io/cluster-api-k3s/pkg/secret"
+	"sigs.k8s.io/cluster-api/util/certs"
+
+	"capi-bootstrap/types"
+)
+
+// caPurposes maps the purposes of types.CertificateAuthority to the purposes of the k3s certificates.
+var caPurposes = map[string]secrets.Purpose{
+	types.CAServer:        secrets.ClusterCA,
+	types.CAClient:        secrets.ClientClusterCA,
+	types.CAEtcd:          secrets.EtcdCA,
+	types.CARequestHeader: secrets.FrontProxyCA,
+}
+
+// newCertificates returns the CA certificates for the initial control plane. The CAs supplied in cas are validated and
+// used as they are, all other CAs are generated. k3s generates a request-header CA itself unless one is supplied.
+func newCertificates(config *v1beta1.KThreesConfigSpec, cas map[string]types.CertificateAuthority, now time.Time) (secrets.Certificates, error) {
+	certificates := secrets.NewCertificatesForInitialControlPlane(config)
+	if _, ok := cas[types.CARequestHeader]; ok {
+		certificates = append(certificates, &secrets.Certificate{
+			Purpose:  secrets.FrontProxyCA,
+			CertFile: filepath.Join(secrets.DefaultCertificatesDir, "request-header-ca.crt"),
+			KeyFile:  filepath.Join(secrets.DefaultCertificatesDir, "request-header-ca.key"),
+		})
+	}
+	var errs []error
+	for _, purpose := range slices.Sorted(maps.Keys(cas)) {
+		k3sPurpose, ok := caPurposes[purpose]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown certificate authority %q, options are: %s", purpose, strings.Join(types.CAPurposes, ", ")))
+			continue
+		}
+		cert := certificates.GetByPurpose(k3sPurpose)
+		if cert == nil {
+			errs = append(errs, fmt.Errorf("certificate authority %s is not used by the control plane", purpose))
+			continue
+		}
+		if err := validateCertificateAuthority(cas[purpose], now); err != nil {
+			errs = append(errs, fmt.Errorf("invalid certificate authority %s: %s", purpose, err))
+			continue
+		}
+		cert.KeyPair = &certs.KeyPair{Cert: cas[purpose].Cert, Key: cas[purpose].Key}
+	}
+	if len(errs) != 0 {
+		return nil, errors.Join(errs...)
+	}
+	for _, cert := range certificates {
+		if cert.KeyPair != nil {
+			continue
+		}
+		if err := cert.Generate(); err != nil {
+			return nil, err
+		}
+	}
+	return certificates, nil
+}
+
+// validateCertificateAuthority returns an error if the certificate of ca isn't a CA that is valid at now, or if the
+// key doesn't belong to the certificate. The certificate can be followed by the chain of its issuers.
+func validateCertificateAuthority(ca types.CertificateAuthority, now time.Time) error {
+	keyPair, err := tls.X509KeyPair(ca.Cert, ca.Key)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return fmt.Errorf("certificate %s is not a CA", cert.Subject)
+	}
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return fmt.Errorf("certificate %s can not sign certificates", cert.Subject)
+	}
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("certificate %s is not valid before %s", cert.Subject, cert.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("certificate %s expired at %s", cert.Subject, cert.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// validateToken returns an error if token can't be used as a k3s token. Secure tokens of the form
+// K10<CA hash>::<user>:<password> have to match the server CA if one is supplied.
+func validateToken(token string, serverCA *types.CertificateAuthority) error {
+	if strings.ContainsFunc(token, unicode.IsSpace) {
+		return errors.New("token can not contain whitespace")
+	}
+	caHash, credentials, found := strings.Cut(strings.TrimPrefix(token, "K10"), "::")
+	if !strings.HasPrefix(token, "K10") || !found {
+		return nil
+	}
+	if _, password, _ := strings.Cut(credentials, ":"); password == "" {
+		return errors.New("secure token needs to end with <user>:<password>")
+	}
+	if serverCA == nil {
+		return nil
+	}
+	hash := sha256.Sum256(serverCA.Cert)
+	if caHash != hex.EncodeToString(hash[:]) {
+		return fmt.Errorf("secure token doesn't match the %s certificate authority", types.CAServer)
+	}
+	return nil
+}
+
+// newClientCertificate issues a client certificate for user and groups from the CA, which is valid for ttl from now.
+// The certificate can't outlive the CA.
+func newClientCertificate(caCert *x509.Certificate, caKey crypto.Signer, user string, groups []string, now time.Time, ttl time.Duration) (*rsa.PrivateKey, *x509.Certificate, error) {
+	if user == "" {
+		return nil, nil, errors.New("a user is required for a client certificate")
+	}
+	if ttl <= 0 {
+		return nil, nil, fmt.Errorf("invalid ttl %s, it needs to be positive", ttl)
+	}
+	notAfter := now.Add(ttl)
+	if notAfter.After(caCert.NotAfter) {
+		return nil, nil, fmt.Errorf("ttl %s exceeds the expiry of the client CA at %s", ttl, caCert.NotAfter.Format(time.RFC3339))
+	}
+	key, err := certs.NewPrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: user, Organization: groups},
+		NotBefore:    now,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, cert, nil
+}
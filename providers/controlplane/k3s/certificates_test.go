@@ -0,0 +1,183 @@
+package k3s
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/k3s-io/cluster-api-k3s/bootstrap/api/v1beta1"
+	secrets "github.com/k3s-io/cluster-api-k3s/pkg/secret"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/cluster-api/util/certs"
+
+	"capi-bootstrap/types"
+)
+
+var testNow = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestCA returns a self-signed key pair that is valid for a year from notBefore.
+func newTestCA(t *testing.T, isCA bool, notBefore time.Time) types.CertificateAuthority {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "internal-pki"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return types.CertificateAuthority{
+		Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func TestNewCertificates(t *testing.T) {
+	ca := newTestCA(t, true, testNow.AddDate(0, -1, 0))
+	otherCA := newTestCA(t, true, testNow.AddDate(0, -1, 0))
+	type test struct {
+		name      string
+		input     map[string]types.CertificateAuthority
+		wantCerts int
+		wantErr   string
+	}
+	tests := []test{
+		{name: "success generated", wantCerts: 3},
+		{
+			name:      "success supplied",
+			input:     map[string]types.CertificateAuthority{types.CAServer: ca, types.CARequestHeader: ca},
+			wantCerts: 4,
+		},
+		{
+			name:    "err unknown purpose",
+			input:   map[string]types.CertificateAuthority{"front-proxy-ca": ca},
+			wantErr: `unknown certificate authority "front-proxy-ca", options are: server-ca, client-ca, etcd-ca, request-header-ca`,
+		},
+		{
+			name:    "err key mismatch",
+			input:   map[string]types.CertificateAuthority{types.CAClient: {Cert: ca.Cert, Key: otherCA.Key}},
+			wantErr: "invalid certificate authority client-ca: tls: private key does not match public key",
+		},
+		{
+			name:    "err not a CA",
+			input:   map[string]types.CertificateAuthority{types.CAEtcd: newTestCA(t, false, testNow.AddDate(0, -1, 0))},
+			wantErr: "invalid certificate authority etcd-ca: certificate CN=internal-pki is not a CA",
+		},
+		{
+			name:    "err expired",
+			input:   map[string]types.CertificateAuthority{types.CAServer: newTestCA(t, true, testNow.AddDate(-2, 0, 0))},
+			wantErr: "invalid certificate authority server-ca: certificate CN=internal-pki expired at 2023-06-01T00:00:00Z",
+		},
+		{
+			name:    "err not yet valid",
+			input:   map[string]types.CertificateAuthority{types.CAServer: newTestCA(t, true, testNow.AddDate(0, 1, 0))},
+			wantErr: "invalid certificate authority server-ca: certificate CN=internal-pki is not valid before 2024-07-01T00:00:00Z",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			certificates, err := newCertificates(&v1beta1.KThreesConfigSpec{}, tc.input, testNow)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, certificates, tc.wantCerts)
+			for _, cert := range certificates {
+				require.NotNil(t, cert.KeyPair)
+			}
+			for purpose, supplied := range tc.input {
+				cert := certificates.GetByPurpose(caPurposes[purpose])
+				require.NotNil(t, cert)
+				assert.Equal(t, supplied.Cert, cert.KeyPair.Cert)
+				assert.False(t, cert.Generated)
+			}
+			assert.True(t, certificates.GetByPurpose(secrets.ClientClusterCA).Generated)
+		})
+	}
+}
+
+func TestValidateToken(t *testing.T) {
+	ca := newTestCA(t, true, testNow)
+	hash := sha256.Sum256(ca.Cert)
+	type test struct {
+		name     string
+		token    string
+		serverCA *types.CertificateAuthority
+		wantErr  string
+	}
+	tests := []test{
+		{name: "success passphrase", token: "test-token", serverCA: &ca},
+		{name: "success secure token", token: "K10" + hex.EncodeToString(hash[:]) + "::server:test-token", serverCA: &ca},
+		{name: "success secure token generated CA", token: "K10abc::server:test-token"},
+		{name: "err whitespace", token: "test token", wantErr: "token can not contain whitespace"},
+		{name: "err secure token credentials", token: "K10abc::server", wantErr: "secure token needs to end with <user>:<password>"},
+		{name: "err secure token hash", token: "K10abc::server:test-token", serverCA: &ca, wantErr: "secure token doesn't match the server-ca certificate authority"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := validateToken(tc.token, tc.serverCA)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestNewClientCertificate(t *testing.T) {
+	ca := newTestCA(t, true, testNow.AddDate(0, -1, 0))
+	caCert, err := certs.DecodeCertPEM(ca.Cert)
+	require.NoError(t, err)
+	caKey, err := certs.DecodePrivateKeyPEM(ca.Key)
+	require.NoError(t, err)
+	type test struct {
+		name    string
+		user    string
+		groups  []string
+		ttl     time.Duration
+		wantErr string
+	}
+	tests := []test{
+		{name: "success", user: "alice", groups: []string{"developers", "oncall"}, ttl: 24 * time.Hour},
+		{name: "err no user", ttl: time.Hour, wantErr: "a user is required for a client certificate"},
+		{name: "err ttl", user: "alice", ttl: -time.Hour, wantErr: "invalid ttl -1h0m0s, it needs to be positive"},
+		{name: "err ttl exceeds CA", user: "alice", ttl: 365 * 24 * time.Hour, wantErr: "ttl 8760h0m0s exceeds the expiry of the client CA at 2025-05-01T00:00:00Z"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			key, cert, err := newClientCertificate(caCert, caKey, tc.user, tc.groups, testNow, tc.ttl)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, key.Public(), cert.PublicKey)
+			assert.Equal(t, tc.user, cert.Subject.CommonName)
+			assert.ElementsMatch(t, tc.groups, cert.Subject.Organization)
+			assert.Equal(t, testNow.Add(tc.ttl), cert.NotAfter)
+			assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, cert.ExtKeyUsage)
+			assert.NoError(t, cert.CheckSignatureFrom(caCert))
+		})
+	}
+}
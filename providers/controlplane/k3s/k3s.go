@@ -3,12 +3,14 @@ package k3s
 import (
 	"context"
 	"crypto"
+	"crypto/rsa"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"net"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/k3s-io/cluster-api-k3s/bootstrap/api/v1beta1"
@@ -19,6 +21,8 @@ import (
 	secrets "github.com/k3s-io/cluster-api-k3s/pkg/secret"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	clientv1 "k8s.io/client-go/tools/clientcmd/api/v1"
 	"k8s.io/klog/v2"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
@@ -80,15 +84,50 @@ func (p *ControlPlane) PreDeploy(ctx context.Context, values *types.Values) erro
 	values.K8sVersion = controlPlaneSpec.Spec.Version
 	klog.Infof("k8s version : %s", controlPlaneSpec.Spec.Version)
 
-	// generate certificates
-	p.Certs = secrets.NewCertificatesForInitialControlPlane(&p.Config)
-	for _, cert := range p.Certs {
-		err := cert.Generate()
-		if err != nil {
-			return err
+	// validate the supplied token and certificates and generate the others
+	var serverCA *types.CertificateAuthority
+	if ca, ok := values.CertificateAuthorities[types.CAServer]; ok {
+		serverCA = &ca
+	}
+	if values.BootstrapToken != "" {
+		if err := validateToken(values.BootstrapToken, serverCA); err != nil {
+			return fmt.Errorf("invalid bootstrap token: %s", err)
 		}
 	}
-	// generate kubeconfig
+	var err error
+	p.Certs, err = newCertificates(&p.Config, values.CertificateAuthorities, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := p.generateKubeconfig(values); err != nil {
+		return err
+	}
+	// set the BootstrapManifestDir
+	values.BootstrapManifestDir = "/var/lib/rancher/k3s/server/manifests/"
+
+	return nil
+}
+
+// generateKubeconfig sets values.Kubeconfig to a kubeconfig with a new admin client certificate issued by the client CA.
+func (p *ControlPlane) generateKubeconfig(values *types.Values) error {
+	clientCACert, clientCAKey, serverCACert, err := p.kubeconfigCAs()
+	if err != nil {
+		return err
+	}
+	newKubeconfig, err := kubeconfig.New(values.ClusterName, clusterServer(values), clientCACert, clientCAKey, serverCACert)
+	if err != nil {
+		return errors.Join(errors.New("failed to generate kubeconfig"), err)
+	}
+	values.Kubeconfig = &clientv1.Config{}
+	err = clientv1.Convert_api_Config_To_v1_Config(newKubeconfig, values.Kubeconfig, nil)
+	if err != nil {
+		return errors.Join(errors.New("failed to convert kubeconfig to v1"), err)
+	}
+	return nil
+}
+
+// kubeconfigCAs returns the client CA that issues client certificates and the server CA that clients trust.
+func (p *ControlPlane) kubeconfigCAs() (*x509.Certificate, crypto.Signer, *x509.Certificate, error) {
 	var clientCACert, serverCACert *x509.Certificate
 	var clientCAKey crypto.Signer
 	var err error
@@ -97,32 +136,28 @@ func (p *ControlPlane) PreDeploy(ctx context.Context, values *types.Values) erro
 		case secrets.ClusterCA:
 			serverCACert, err = certs.DecodeCertPEM(cert.KeyPair.Cert)
 			if err != nil {
-				return errors.Join(errors.New("failed to decode server CA certificate"), err)
+				return nil, nil, nil, errors.Join(errors.New("failed to decode server CA certificate"), err)
 			}
 		case secrets.ClientClusterCA:
 			clientCACert, err = certs.DecodeCertPEM(cert.KeyPair.Cert)
 			if err != nil {
-				return errors.Join(errors.New("failed to decode client cluster CA certificate"), err)
+				return nil, nil, nil, errors.Join(errors.New("failed to decode client cluster CA certificate"), err)
 			}
 			clientCAKey, err = certs.DecodePrivateKeyPEM(cert.KeyPair.Key)
 			if err != nil {
-				return errors.Join(errors.New("failed to decode client CA private key"), err)
+				return nil, nil, nil, errors.Join(errors.New("failed to decode client CA private key"), err)
 			}
 		}
 	}
-	newKubeconfig, err := kubeconfig.New(values.ClusterName, fmt.Sprintf("https://%s", net.JoinHostPort(values.ClusterEndpoint, "6443")), clientCACert, clientCAKey, serverCACert)
-	if err != nil {
-		return errors.Join(errors.New("failed to generate kubeconfig"), err)
-	}
-	values.Kubeconfig = &clientv1.Config{}
-	err = clientv1.Convert_api_Config_To_v1_Config(newKubeconfig, values.Kubeconfig, nil)
-	if err != nil {
-		return errors.Join(errors.New("failed to convert kubeconfig to v1"), err)
+	if clientCACert == nil || clientCAKey == nil || serverCACert == nil {
+		return nil, nil, nil, errors.New("server and client CA are required to generate a kubeconfig")
 	}
-	// set the BootstrapManifestDir
-	values.BootstrapManifestDir = "/var/lib/rancher/k3s/server/manifests/"
+	return clientCACert, clientCAKey, serverCACert, nil
+}
 
-	return nil
+// clusterServer is the URL of the API server of the cluster.
+func clusterServer(values *types.Values) string {
+	return fmt.Sprintf("https://%s", net.JoinHostPort(values.ClusterEndpoint, "6443"))
 }
 
 func (p *ControlPlane) GenerateRunCommand(_ context.Context, values *types.Values) ([]string, error) {
@@ -133,15 +168,32 @@ func (p *ControlPlane) GenerateInitScript(_ context.Context, initScriptPath stri
 	return capiYaml.ConstructFile(initScriptPath, "files/init-cluster.sh", files, values, false)
 }
 
-func GetControlPlaneDef(manifests []string) *capK3s.KThreesControlPlane {
+// controlPlaneGVK matches all versions of the KThreesControlPlane.
+var controlPlaneGVK = schema.GroupVersionKind{Group: capK3s.GroupVersion.Group, Kind: "KThreesControlPlane"}
+
+// References are the references of the k3s kinds in the manifests.
+var References = []capiYaml.Reference{
+	{From: controlPlaneGVK.GroupKind(), Path: []string{"spec", "machineTemplate", "infrastructureRef"}, Required: true},
+}
+
+// AddToScheme registers the k3s control plane and bootstrap API types.
+func AddToScheme(scheme *runtime.Scheme) error {
+	if err := capK3s.AddToScheme(scheme); err != nil {
+		return err
+	}
+	return v1beta1.AddToScheme(scheme)
+}
+
+func GetControlPlaneDef(manifest *capiYaml.Manifest) *capK3s.KThreesControlPlane {
+	object := manifest.Get(controlPlaneGVK, "")
+	if object == nil {
+		return nil
+	}
 	var cp capK3s.KThreesControlPlane
-	for _, manifest := range manifests {
-		_ = yaml.Unmarshal([]byte(manifest), &cp)
-		if cp.Kind == "KThreesControlPlane" {
-			return &cp
-		}
+	if err := capiYaml.DecodeObject(object, &cp); err != nil {
+		return nil
 	}
-	return nil
+	return &cp
 }
 
 func (p *ControlPlane) generateK3sConfig(values *types.Values) (*capiYaml.InitFile, error) {
@@ -167,35 +219,32 @@ func generateK3sManifests() *capiYaml.InitFile {
 	}
 }
 
-func (p *ControlPlane) UpdateManifests(_ context.Context, manifests []string, values *types.Values) (*capiYaml.ParsedManifest, error) {
-	var controlPlane capK3s.KThreesControlPlane
+func (p *ControlPlane) UpdateManifests(_ context.Context, manifest *capiYaml.Manifest, values *types.Values) (*capiYaml.ParsedManifest, error) {
 	var controlPlaneManifests capiYaml.ParsedManifest
-	for _, manifest := range manifests {
-		err := yaml.Unmarshal([]byte(manifest), &controlPlane)
-		if err != nil {
-			return nil, err
+	for _, object := range manifest.List(controlPlaneGVK) {
+		var controlPlane capK3s.KThreesControlPlane
+		if err := capiYaml.DecodeObject(object, &controlPlane); err != nil {
+			return nil, fmt.Errorf("could not decode KThreesControlPlane %s: %s", object.GetName(), err)
 		}
-		if controlPlane.Kind == "KThreesControlPlane" {
-			for _, file := range controlPlane.Spec.KThreesConfigSpec.Files {
-				newFile := capiYaml.InitFile{
-					Path:        file.Path,
-					Content:     file.Content,
-					Owner:       file.Owner,
-					Permissions: file.Permissions,
-					Encoding:    string(file.Encoding),
-				}
-				controlPlaneManifests.AdditionalFiles = append(controlPlaneManifests.AdditionalFiles, newFile)
-			}
-			for _, cmd := range controlPlane.Spec.KThreesConfigSpec.PreK3sCommands {
-				parsedCommand := strings.ReplaceAll(cmd, "{{ '{{", "{{")
-				parsedCommand = strings.ReplaceAll(parsedCommand, "}}' }}", "}}")
-				controlPlaneManifests.PreRunCmd = append(controlPlaneManifests.PreRunCmd, parsedCommand)
-			}
-			for _, cmd := range controlPlane.Spec.KThreesConfigSpec.PostK3sCommands {
-				parsedCommand := strings.ReplaceAll(cmd, "{{ '{{", "{{")
-				parsedCommand = strings.ReplaceAll(parsedCommand, "}}' }}", "}}")
-				controlPlaneManifests.PostRunCmd = append(controlPlaneManifests.PostRunCmd, parsedCommand)
+		for _, file := range controlPlane.Spec.KThreesConfigSpec.Files {
+			newFile := capiYaml.InitFile{
+				Path:        file.Path,
+				Content:     file.Content,
+				Owner:       file.Owner,
+				Permissions: file.Permissions,
+				Encoding:    string(file.Encoding),
 			}
+			controlPlaneManifests.AdditionalFiles = append(controlPlaneManifests.AdditionalFiles, newFile)
+		}
+		for _, cmd := range controlPlane.Spec.KThreesConfigSpec.PreK3sCommands {
+			parsedCommand := strings.ReplaceAll(cmd, "{{ '{{", "{{")
+			parsedCommand = strings.ReplaceAll(parsedCommand, "}}' }}", "}}")
+			controlPlaneManifests.PreRunCmd = append(controlPlaneManifests.PreRunCmd, parsedCommand)
+		}
+		for _, cmd := range controlPlane.Spec.KThreesConfigSpec.PostK3sCommands {
+			parsedCommand := strings.ReplaceAll(cmd, "{{ '{{", "{{")
+			parsedCommand = strings.ReplaceAll(parsedCommand, "}}' }}", "}}")
+			controlPlaneManifests.PostRunCmd = append(controlPlaneManifests.PostRunCmd, parsedCommand)
 		}
 	}
 	return &controlPlaneManifests, nil
@@ -258,6 +307,10 @@ func (p *ControlPlane) GetControlPlaneCertFiles(ctx context.Context) ([]capiYaml
 		return nil, ErrNoCerts
 	}
 	k3sFiles := p.Certs.AsFiles()
+	// the request-header CA is only set if it was supplied, but it isn't part of the files of the certificates
+	if requestHeaderCA := p.Certs.GetByPurpose(secrets.FrontProxyCA); requestHeaderCA != nil {
+		k3sFiles = append(k3sFiles, requestHeaderCA.AsFiles()...)
+	}
 	yamlFiles := make([]capiYaml.InitFile, len(k3sFiles))
 	for i, file := range k3sFiles {
 		yamlFiles[i] = capiYaml.InitFile{
@@ -308,3 +361,75 @@ func (p *ControlPlane) GetKubeconfig(ctx context.Context, values *types.Values)
 
 	return &kubeconfigFile, nil
 }
+
+func (p *ControlPlane) RotateKubeconfig(_ context.Context, values *types.Values, ttl time.Duration) error {
+	if p.Certs == nil {
+		return ErrNoCerts
+	}
+	clientCACert, clientCAKey, serverCACert, err := p.kubeconfigCAs()
+	if err != nil {
+		return err
+	}
+	// the same subject and user name as the admin certificate issued by kubeconfig.New
+	clientKey, clientCert, err := newClientCertificate(clientCACert, clientCAKey, "kubernetes-admin", []string{"system:masters"}, time.Now(), ttl)
+	if err != nil {
+		return err
+	}
+	values.Kubeconfig = certificateKubeconfig(values, values.ClusterName+"-admin", serverCACert, clientKey, clientCert)
+	return nil
+}
+
+func (p *ControlPlane) GetCertificates(_ context.Context) (map[string][]byte, error) {
+	if p.Certs == nil {
+		return nil, ErrNoCerts
+	}
+	certificates := make(map[string][]byte, len(p.Certs))
+	for purpose, k3sPurpose := range caPurposes {
+		if cert := p.Certs.GetByPurpose(k3sPurpose); cert != nil && cert.KeyPair != nil {
+			certificates[purpose] = cert.KeyPair.Cert
+		}
+	}
+	return certificates, nil
+}
+
+func (p *ControlPlane) GetUserKubeconfig(_ context.Context, values *types.Values, user string, groups []string, ttl time.Duration) (*clientv1.Config, error) {
+	if p.Certs == nil {
+		return nil, ErrNoCerts
+	}
+	clientCACert, clientCAKey, serverCACert, err := p.kubeconfigCAs()
+	if err != nil {
+		return nil, err
+	}
+	clientKey, clientCert, err := newClientCertificate(clientCACert, clientCAKey, user, groups, time.Now(), ttl)
+	if err != nil {
+		return nil, err
+	}
+	return certificateKubeconfig(values, user, serverCACert, clientKey, clientCert), nil
+}
+
+// certificateKubeconfig returns a kubeconfig for the cluster of values that authenticates as user with the client
+// certificate.
+func certificateKubeconfig(values *types.Values, user string, serverCACert *x509.Certificate, clientKey *rsa.PrivateKey, clientCert *x509.Certificate) *clientv1.Config {
+	contextName := fmt.Sprintf("%s@%s", user, values.ClusterName)
+	return &clientv1.Config{
+		Clusters: []clientv1.NamedCluster{{
+			Name: values.ClusterName,
+			Cluster: clientv1.Cluster{
+				Server:                   clusterServer(values),
+				CertificateAuthorityData: certs.EncodeCertPEM(serverCACert),
+			},
+		}},
+		AuthInfos: []clientv1.NamedAuthInfo{{
+			Name: user,
+			AuthInfo: clientv1.AuthInfo{
+				ClientCertificateData: certs.EncodeCertPEM(clientCert),
+				ClientKeyData:         certs.EncodePrivateKeyPEM(clientKey),
+			},
+		}},
+		Contexts: []clientv1.NamedContext{{
+			Name:    contextName,
+			Context: clientv1.Context{Cluster: values.ClusterName, AuthInfo: user},
+		}},
+		CurrentContext: contextName,
+	}
+}
@@ -2,12 +2,18 @@ package k3s
 
 import (
 	"context"
+	"maps"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/k3s-io/cluster-api-k3s/bootstrap/api/v1beta1"
 	"github.com/k3s-io/cluster-api-k3s/pkg/etcd"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"k8s.io/utils/ptr"
+	"sigs.k8s.io/cluster-api/util/certs"
 
 	"capi-bootstrap/types"
 	capiYaml "capi-bootstrap/yaml"
@@ -38,8 +44,9 @@ metadata:
   name: k3s
   namespace: capi-k3s-bootstrap-system
 spec:
+  version: "v0.2.0"
   fetchConfig:
-    url: https://github.com/k3s-io/cluster-api-k3s/releases/latest/bootstrap-components.yaml
+    url: https://github.com/k3s-io/cluster-api-k3s/releases/download/v0.2.0/bootstrap-components.yaml
 ---
 apiVersion: operator.cluster.x-k8s.io/v1alpha2
 kind: ControlPlaneProvider
@@ -47,11 +54,12 @@ metadata:
   name: k3s
   namespace: capi-k3s-control-plane-system
 spec:
+  version: "v0.2.0"
   fetchConfig:
-    url: https://github.com/k3s-io/cluster-api-k3s/releases/latest/control-plane-components.yaml`,
+    url: https://github.com/k3s-io/cluster-api-k3s/releases/download/v0.2.0/control-plane-components.yaml`,
 	}
 	tests := []test{
-		{name: "success", input: types.Values{}, want: ptr.To(expectedCapiFile)},
+		{name: "success", input: types.Values{Versions: types.DefaultVersions}, want: ptr.To(expectedCapiFile)},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -170,12 +178,13 @@ spec:
   version: v1.29.5+k3s1
 `}
 	tests := []test{
-		{name: "success", input: types.Values{Manifests: manifests, ClusterEndpoint: "api-server.test.com"}, want: types.Values{
+		{name: "success", input: types.Values{Manifests: parseManifest(t, manifests...), ClusterEndpoint: "api-server.test.com"}, want: types.Values{
 			BootstrapManifestDir: "/var/lib/rancher/k3s/server/manifests/",
 			K8sVersion:           "v1.29.5+k3s1",
 		},
 			wantEndpoint: "https://api-server.test.com:6443"},
 		{name: "err cp not found", input: types.Values{}, want: types.Values{}, wantErr: "control plane not found"},
+		{name: "err bootstrap token", input: types.Values{Manifests: parseManifest(t, manifests...), BootstrapToken: "test token"}, want: types.Values{}, wantErr: "invalid bootstrap token: token can not contain whitespace"},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -233,12 +242,12 @@ func TestK3s_GenerateInitScript(t *testing.T) {
 sed -i "s/127.0.0.1/api-server.test.com/" /etc/rancher/k3s/k3s.yaml
 until k3s kubectl get -f /var/lib/rancher/k3s/server/manifests/capi-manifests.yaml; do sleep 10; done
 rm /var/lib/rancher/k3s/server/manifests/capi-manifests.yaml
-k3s kubectl patch machine test-cluster-bootstrap --type=json -p "[{\"op\": \"add\", \"path\": \"/metadata/ownerReferences\", \"value\" : [{\"apiVersion\":\"controlplane.cluster.x-k8s.io/v1beta1\",\"blockOwnerDeletion\":true,\"controller\":true,\"kind\":\"KThreesControlPlane\",\"name\":\"test-cluster-control-plane\",\"uid\":\"$(k3s kubectl get KThreesControlPlane test-cluster-control-plane -ojsonpath='{.metadata.uid}')\"}]}]"
-k3s kubectl patch cluster test-cluster --type=json -p '[{"op": "replace", "path": "/spec/controlPlaneRef/name", "value": "test-cluster-control-plane"}]'
+k3s kubectl -n capl patch machine test-cluster-bootstrap --type=json -p "[{\"op\": \"add\", \"path\": \"/metadata/ownerReferences\", \"value\" : [{\"apiVersion\":\"controlplane.cluster.x-k8s.io/v1beta1\",\"blockOwnerDeletion\":true,\"controller\":true,\"kind\":\"KThreesControlPlane\",\"name\":\"test-cluster-control-plane\",\"uid\":\"$(k3s kubectl -n capl get KThreesControlPlane test-cluster-control-plane -ojsonpath='{.metadata.uid}')\"}]}]"
+k3s kubectl -n capl patch cluster test-cluster --type=json -p '[{"op": "replace", "path": "/spec/controlPlaneRef/name", "value": "test-cluster-control-plane"}]'
 `,
 	}
 	tests := []test{
-		{name: "success", input: types.Values{ClusterName: "test-cluster", ClusterEndpoint: "api-server.test.com"}, want: &expectedFile},
+		{name: "success", input: types.Values{ClusterName: "test-cluster", Namespace: "capl", ControlPlaneName: "test-cluster-control-plane", ClusterEndpoint: "api-server.test.com"}, want: &expectedFile},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -331,7 +340,7 @@ spec:
 			t.Parallel()
 			ctx := context.Background()
 			controlPlane := ControlPlane{}
-			actual, _ := controlPlane.UpdateManifests(ctx, manifests, &tc.input)
+			actual, _ := controlPlane.UpdateManifests(ctx, parseManifest(t, manifests...), &tc.input)
 			for i, actualFile := range actual.AdditionalFiles {
 				assert.Equal(t, tc.want.AdditionalFiles[i].Path, actualFile.Path, "expected file path: %s", tc.want.AdditionalFiles[i].Path)
 				assert.Equal(t, tc.want.AdditionalFiles[i].Content, actualFile.Content, "expected file contents: %s", tc.want.AdditionalFiles[i].Content)
@@ -350,7 +359,7 @@ func TestK3s_GetControlPlaneCertSecret(t *testing.T) {
 	tests := []test{
 		{name: "success", input: types.Values{
 			ClusterName: "test-cluster", ClusterEndpoint: "api-server.test.com",
-			Manifests: []string{`---
+			Manifests: parseManifest(t, `---
 apiVersion: controlplane.cluster.x-k8s.io/v1beta1
 kind: KThreesControlPlane
 metadata:
@@ -370,7 +379,7 @@ spec:
       - traefik
   replicas: 3
   version: v1.29.5+k3s1
-`}}},
+`)}},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -404,7 +413,7 @@ func TestK3s_GetControlPlaneCertFiles(t *testing.T) {
 	tests := []test{
 		{name: "success", input: types.Values{
 			ClusterName: "test-cluster", ClusterEndpoint: "api-server.test.com",
-			Manifests: []string{`---
+			Manifests: parseManifest(t, `---
 apiVersion: controlplane.cluster.x-k8s.io/v1beta1
 kind: KThreesControlPlane
 metadata:
@@ -424,7 +433,31 @@ spec:
       - traefik
   replicas: 3
   version: v1.29.5+k3s1
-`}}},
+`)}},
+		{name: "success request-header CA", input: types.Values{
+			ClusterName: "test-cluster", ClusterEndpoint: "api-server.test.com",
+			CertificateAuthorities: map[string]types.CertificateAuthority{types.CARequestHeader: newTestCA(t, true, time.Now().Add(-time.Hour))},
+			Manifests: parseManifest(t, `---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta1
+kind: KThreesControlPlane
+metadata:
+  name: test-vpc-k3s-control-plane
+  namespace: default
+spec:
+  kthreesConfigSpec:
+    agentConfig:
+      nodeName: '{{ ds.meta_data.label }}'
+    preK3sCommands:
+    - sed -i '/swap/d' /etc/fstab
+    - swapoff -a
+    - hostnamectl set-hostname '{{ ds.meta_data.label }}' && hostname -F /etc/hostname
+    serverConfig:
+      disableComponents:
+      - servicelb
+      - traefik
+  replicas: 3
+  version: v1.29.5+k3s1
+`)}},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -461,7 +494,7 @@ func TestK3s_GetKubeconfig(t *testing.T) {
 	tests := []test{
 		{name: "success", input: types.Values{
 			ClusterName: "test-cluster", ClusterEndpoint: "api-server.test.com",
-			Manifests: []string{`---
+			Manifests: parseManifest(t, `---
 apiVersion: controlplane.cluster.x-k8s.io/v1beta1
 kind: KThreesControlPlane
 metadata:
@@ -481,7 +514,7 @@ spec:
       - traefik
   replicas: 3
   version: v1.29.5+k3s1
-`}}},
+`)}},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -506,7 +539,68 @@ spec:
 	}
 }
 
+func TestK3s_Kubeconfigs(t *testing.T) {
+	ctx := context.Background()
+	values := types.Values{
+		ClusterName: "test-cluster", ClusterEndpoint: "api-server.test.com",
+		Manifests: parseManifest(t, `---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta1
+kind: KThreesControlPlane
+metadata:
+  name: test-vpc-k3s-control-plane
+spec:
+  version: v1.29.5+k3s1
+`)}
+	controlPlane := ControlPlane{}
+	require.NoError(t, controlPlane.PreDeploy(ctx, &values))
+	previous := values.Kubeconfig
+
+	require.NoError(t, controlPlane.RotateKubeconfig(ctx, &values, 30*24*time.Hour))
+	require.Len(t, values.Kubeconfig.AuthInfos, 1)
+	assert.Equal(t, previous.Clusters, values.Kubeconfig.Clusters)
+	assert.Equal(t, previous.Contexts, values.Kubeconfig.Contexts)
+	assert.Equal(t, previous.CurrentContext, values.Kubeconfig.CurrentContext)
+	assert.Equal(t, previous.AuthInfos[0].Name, values.Kubeconfig.AuthInfos[0].Name)
+	assert.NotEqual(t, previous.AuthInfos[0].AuthInfo.ClientCertificateData, values.Kubeconfig.AuthInfos[0].AuthInfo.ClientCertificateData)
+	previousCert, err := certs.DecodeCertPEM(previous.AuthInfos[0].AuthInfo.ClientCertificateData)
+	require.NoError(t, err)
+	rotatedCert, err := certs.DecodeCertPEM(values.Kubeconfig.AuthInfos[0].AuthInfo.ClientCertificateData)
+	require.NoError(t, err)
+	assert.Equal(t, previousCert.Subject.String(), rotatedCert.Subject.String())
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), rotatedCert.NotAfter, time.Minute)
+	assert.Error(t, controlPlane.RotateKubeconfig(ctx, &values, 0))
+
+	certificates, err := controlPlane.GetCertificates(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{types.CAServer, types.CAClient, types.CAEtcd}, slices.Collect(maps.Keys(certificates)))
+	assert.Equal(t, previous.Clusters[0].Cluster.CertificateAuthorityData, certificates[types.CAServer])
+
+	userConfig, err := controlPlane.GetUserKubeconfig(ctx, &values, "alice", []string{"developers"}, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, previous.Clusters, userConfig.Clusters)
+	assert.Equal(t, "alice@test-cluster", userConfig.CurrentContext)
+	require.Len(t, userConfig.AuthInfos, 1)
+	assert.Equal(t, "alice", userConfig.AuthInfos[0].Name)
+	assert.Empty(t, userConfig.Extensions)
+
+	// no cert error
+	controlPlane.Certs = nil
+	assert.True(t, IsErrNoCerts(controlPlane.RotateKubeconfig(ctx, &values, time.Hour)))
+	_, err = controlPlane.GetUserKubeconfig(ctx, &values, "alice", nil, time.Hour)
+	assert.True(t, IsErrNoCerts(err))
+	_, err = controlPlane.GetCertificates(ctx)
+	assert.True(t, IsErrNoCerts(err))
+}
+
 func TestNewControlPlane(t *testing.T) {
 	k3s := NewControlPlane()
 	assert.Equal(t, k3s.Name, "KThreesControlPlane")
 }
+
+// parseManifest joins the documents into a manifest like the one parsed from the manifest file.
+func parseManifest(t *testing.T, documents ...string) *capiYaml.Manifest {
+	t.Helper()
+	manifest, err := capiYaml.ParseManifest(strings.Join(documents, "\n"))
+	require.NoError(t, err)
+	return manifest
+}
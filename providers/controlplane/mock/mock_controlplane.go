@@ -14,8 +14,10 @@ import (
 	yaml "capi-bootstrap/yaml"
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "go.uber.org/mock/gomock"
+	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
 )
 
 // MockProvider is a mock of Provider interface.
@@ -101,6 +103,21 @@ func (mr *MockProviderMockRecorder) GenerateRunCommand(ctx, values any) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GenerateRunCommand", reflect.TypeOf((*MockProvider)(nil).GenerateRunCommand), ctx, values)
 }
 
+// GetCertificates mocks base method.
+func (m *MockProvider) GetCertificates(ctx context.Context) (map[string][]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCertificates", ctx)
+	ret0, _ := ret[0].(map[string][]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCertificates indicates an expected call of GetCertificates.
+func (mr *MockProviderMockRecorder) GetCertificates(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCertificates", reflect.TypeOf((*MockProvider)(nil).GetCertificates), ctx)
+}
+
 // GetControlPlaneCertFiles mocks base method.
 func (m *MockProvider) GetControlPlaneCertFiles(ctx context.Context) ([]yaml.InitFile, error) {
 	m.ctrl.T.Helper()
@@ -146,6 +163,21 @@ func (mr *MockProviderMockRecorder) GetKubeconfig(ctx, values any) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetKubeconfig", reflect.TypeOf((*MockProvider)(nil).GetKubeconfig), ctx, values)
 }
 
+// GetUserKubeconfig mocks base method.
+func (m *MockProvider) GetUserKubeconfig(ctx context.Context, values *types.Values, user string, groups []string, ttl time.Duration) (*v1.Config, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserKubeconfig", ctx, values, user, groups, ttl)
+	ret0, _ := ret[0].(*v1.Config)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserKubeconfig indicates an expected call of GetUserKubeconfig.
+func (mr *MockProviderMockRecorder) GetUserKubeconfig(ctx, values, user, groups, ttl any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserKubeconfig", reflect.TypeOf((*MockProvider)(nil).GetUserKubeconfig), ctx, values, user, groups, ttl)
+}
+
 // PreDeploy mocks base method.
 func (m *MockProvider) PreDeploy(ctx context.Context, values *types.Values) error {
 	m.ctrl.T.Helper()
@@ -160,17 +192,31 @@ func (mr *MockProviderMockRecorder) PreDeploy(ctx, values any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PreDeploy", reflect.TypeOf((*MockProvider)(nil).PreDeploy), ctx, values)
 }
 
+// RotateKubeconfig mocks base method.
+func (m *MockProvider) RotateKubeconfig(ctx context.Context, values *types.Values, ttl time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateKubeconfig", ctx, values, ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RotateKubeconfig indicates an expected call of RotateKubeconfig.
+func (mr *MockProviderMockRecorder) RotateKubeconfig(ctx, values, ttl any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateKubeconfig", reflect.TypeOf((*MockProvider)(nil).RotateKubeconfig), ctx, values, ttl)
+}
+
 // UpdateManifests mocks base method.
-func (m *MockProvider) UpdateManifests(ctx context.Context, manifests []string, values *types.Values) (*yaml.ParsedManifest, error) {
+func (m *MockProvider) UpdateManifests(ctx context.Context, manifest *yaml.Manifest, values *types.Values) (*yaml.ParsedManifest, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateManifests", ctx, manifests, values)
+	ret := m.ctrl.Call(m, "UpdateManifests", ctx, manifest, values)
 	ret0, _ := ret[0].(*yaml.ParsedManifest)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // UpdateManifests indicates an expected call of UpdateManifests.
-func (mr *MockProviderMockRecorder) UpdateManifests(ctx, manifests, values any) *gomock.Call {
+func (mr *MockProviderMockRecorder) UpdateManifests(ctx, manifest, values any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateManifests", reflect.TypeOf((*MockProvider)(nil).UpdateManifests), ctx, manifests, values)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateManifests", reflect.TypeOf((*MockProvider)(nil).UpdateManifests), ctx, manifest, values)
 }
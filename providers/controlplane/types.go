@@ -2,6 +2,9 @@ package controlplane
 
 import (
 	"context"
+	"time"
+
+	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
 
 	"capi-bootstrap/types"
 	capiYaml "capi-bootstrap/yaml"
@@ -17,7 +20,7 @@ type Provider interface {
 	// GenerateAdditionalFiles generates any additional manifests that might be necessary for the ControlPlane Provider
 	GenerateAdditionalFiles(ctx context.Context, values *types.Values) ([]capiYaml.InitFile, error)
 	// UpdateManifests parses and updates any manifests needed to by the Provider
-	UpdateManifests(ctx context.Context, manifests []string, values *types.Values) (*capiYaml.ParsedManifest, error)
+	UpdateManifests(ctx context.Context, manifest *capiYaml.Manifest, values *types.Values) (*capiYaml.ParsedManifest, error)
 	// PreDeploy takes in a common substitutions struct, does any setup needed to deploy a CAPI cluster and updates
 	// the substitutions struct with any values needed by the ControlPlane Provider
 	PreDeploy(ctx context.Context, values *types.Values) error
@@ -30,4 +33,12 @@ type Provider interface {
 	// GetKubeconfig gets the kubeconfig based on the certificates generated by the controlPlane Provider
 	// and writes it to a Secret file to be used by CAPI
 	GetKubeconfig(ctx context.Context, values *types.Values) (*capiYaml.InitFile, error)
+	// RotateKubeconfig replaces values.Kubeconfig with a kubeconfig using a new admin client certificate, which is
+	// issued from the certificates kept by the controlPlane Provider and expires after ttl
+	RotateKubeconfig(ctx context.Context, values *types.Values, ttl time.Duration) error
+	// GetCertificates returns the PEM encoded CA certificates kept by the controlPlane Provider by their purpose
+	GetCertificates(ctx context.Context) (map[string][]byte, error)
+	// GetUserKubeconfig returns a kubeconfig for user and groups with a client certificate that is issued from the
+	// certificates kept by the controlPlane Provider and expires after ttl
+	GetUserKubeconfig(ctx context.Context, values *types.Values, user string, groups []string, ttl time.Duration) (*v1.Config, error)
 }
@@ -1,6 +1,11 @@
 package infrastructure
 
-import "capi-bootstrap/providers/infrastructure/linode"
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"capi-bootstrap/providers/infrastructure/linode"
+	capiYaml "capi-bootstrap/yaml"
+)
 
 func NewProvider(name string) Provider {
 	switch name {
@@ -10,3 +15,13 @@ func NewProvider(name string) Provider {
 		return nil
 	}
 }
+
+// AddToScheme registers the API types of all infrastructure providers, manifests are validated against them.
+func AddToScheme(scheme *runtime.Scheme) error {
+	return linode.AddToScheme(scheme)
+}
+
+// References returns the references between the objects of all infrastructure providers.
+func References() []capiYaml.Reference {
+	return linode.References
+}
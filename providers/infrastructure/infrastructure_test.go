@@ -4,6 +4,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 
 	"capi-bootstrap/providers/infrastructure/linode"
 )
@@ -24,3 +27,11 @@ func TestNewProvider(t *testing.T) {
 		assert.Equal(t, tc.want, actual)
 	}
 }
+
+func TestAddToScheme(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, AddToScheme(scheme))
+	for _, kind := range []string{"LinodeCluster", "LinodeMachineTemplate", "LinodeVPC"} {
+		assert.True(t, scheme.Recognizes(schema.GroupVersionKind{Group: "infrastructure.cluster.x-k8s.io", Version: "v1alpha2", Kind: kind}), kind)
+	}
+}
@@ -0,0 +1,199 @@
+package linode
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/linode/linodego"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+
+	"capi-bootstrap/types"
+	capiYaml "capi-bootstrap/yaml"
+)
+
+const (
+	// nodeBalancerCIDR is the private range NodeBalancers use to connect to their backends.
+	nodeBalancerCIDR = "192.168.255.0/24"
+	// privateCIDR is the range of Linode private IPs that other control plane machines join from without a VPC.
+	privateCIDR = "192.168.128.0/17"
+)
+
+// LinodeFirewall is the subset of the CAPL LinodeFirewall needed to create the firewall of the bootstrap instance,
+// the CAPL version used to parse manifests doesn't include it yet.
+type LinodeFirewall struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              LinodeFirewallSpec `json:"spec,omitempty"`
+}
+
+type LinodeFirewallSpec struct {
+	FirewallID     *int                    `json:"firewallID,omitempty"`
+	InboundRules   []linodego.FirewallRule `json:"inboundRules,omitempty"`
+	InboundPolicy  string                  `json:"inboundPolicy,omitempty"`
+	OutboundRules  []linodego.FirewallRule `json:"outboundRules,omitempty"`
+	OutboundPolicy string                  `json:"outboundPolicy,omitempty"`
+}
+
+// GetFirewallDef returns the LinodeFirewall referenced by the firewallRef of the control plane LinodeMachineTemplate,
+// or nil if the template doesn't reference one.
+func GetFirewallDef(manifest *capiYaml.Manifest) (*LinodeFirewall, error) {
+	object, err := firewallObject(manifest)
+	if err != nil || object == nil {
+		return nil, err
+	}
+	var firewall LinodeFirewall
+	if err := capiYaml.DecodeObject(object, &firewall); err != nil {
+		return nil, fmt.Errorf("could not decode LinodeFirewall %s: %s", object.GetName(), err)
+	}
+	return &firewall, nil
+}
+
+// firewallObject returns the LinodeFirewall object referenced by the firewallRef of the control plane
+// LinodeMachineTemplate, its namespace defaults to the namespace of the template.
+func firewallObject(manifest *capiYaml.Manifest) (*unstructured.Unstructured, error) {
+	template, err := controlPlaneMachineTemplate(manifest)
+	if err != nil {
+		return nil, err
+	}
+	ref, found, err := unstructured.NestedStringMap(template.Object, "spec", "template", "spec", "firewallRef")
+	if err != nil {
+		return nil, fmt.Errorf("invalid firewallRef of LinodeMachineTemplate %s: %s", template.GetName(), err)
+	}
+	if !found {
+		return nil, nil
+	}
+	namespace := ref["namespace"]
+	if namespace == "" {
+		namespace = template.GetNamespace()
+	}
+	if object := findLinodeObject(manifest, "LinodeFirewall", ref["name"], namespace); object != nil {
+		return object, nil
+	}
+	return nil, fmt.Errorf("LinodeFirewall %s referenced by LinodeMachineTemplate %s not found", ref["name"], template.GetName())
+}
+
+// createFirewall creates the Cloud Firewall attached to the bootstrap instance. The rules of the LinodeFirewall
+// referenced by the control plane machine template are used if there is one, an existing firewall it references is
+// used as is. Otherwise, the default
+// firewall drops all inbound traffic except the API server from the NodeBalancer, the ports other control plane
+// machines join on and SSH from the configured CIDRs.
+func (p *Infrastructure) createFirewall(ctx context.Context, values *types.Values) error {
+	firewallDef, err := GetFirewallDef(values.Manifests)
+	if err != nil {
+		return err
+	}
+	if firewallDef != nil && firewallDef.Spec.FirewallID != nil {
+		p.FirewallID = *firewallDef.Spec.FirewallID
+		klog.Infof("Using existing Firewall: %d\n", p.FirewallID)
+		return nil
+	}
+
+	createOptions := linodego.FirewallCreateOptions{
+		Label: values.ClusterName,
+		Rules: p.defaultFirewallRules(),
+		Tags:  []string{values.ClusterName},
+	}
+	if firewallDef != nil {
+		createOptions.Label = firewallDef.Name
+		createOptions.Rules = linodego.FirewallRuleSet{
+			Inbound:        firewallDef.Spec.InboundRules,
+			InboundPolicy:  defaultPolicy(firewallDef.Spec.InboundPolicy),
+			Outbound:       firewallDef.Spec.OutboundRules,
+			OutboundPolicy: defaultPolicy(firewallDef.Spec.OutboundPolicy),
+		}
+	}
+	firewall, err := p.Client.CreateFirewall(ctx, createOptions)
+	if err != nil {
+		return fmt.Errorf("unable to create Firewall: %s", err)
+	}
+	p.FirewallID = firewall.ID
+	p.FirewallCreated = true
+	klog.Infof("Created Firewall: %s\n", firewall.Label)
+	return nil
+}
+
+func (p *Infrastructure) defaultFirewallRules() linodego.FirewallRuleSet {
+	clusterCIDRs := []string{privateCIDR}
+	if p.VPC != nil {
+		for _, subnet := range p.VPC.Spec.Subnets {
+			clusterCIDRs = append(clusterCIDRs, subnet.IPv4)
+		}
+	}
+	rules := []linodego.FirewallRule{
+		{
+			Action:      "ACCEPT",
+			Label:       "api-server",
+			Description: "API server from the NodeBalancer",
+			Ports:       "6443",
+			Protocol:    linodego.TCP,
+			Addresses:   linodego.NetworkAddresses{IPv4: &[]string{nodeBalancerCIDR}},
+		},
+		{
+			Action:      "ACCEPT",
+			Label:       "control-plane-tcp",
+			Description: "API server, etcd and kubelet from other control plane machines",
+			Ports:       "2379-2380,6443,10250",
+			Protocol:    linodego.TCP,
+			Addresses:   linodego.NetworkAddresses{IPv4: &clusterCIDRs},
+		},
+		{
+			Action:      "ACCEPT",
+			Label:       "control-plane-udp",
+			Description: "flannel VXLAN from other control plane machines",
+			Ports:       "8472",
+			Protocol:    linodego.UDP,
+			Addresses:   linodego.NetworkAddresses{IPv4: &clusterCIDRs},
+		},
+	}
+	if len(p.SSHAllowedCIDRs) != 0 {
+		rules = append(rules, linodego.FirewallRule{
+			Action:      "ACCEPT",
+			Label:       "ssh",
+			Description: "SSH from LINODE_FIREWALL_SSH_CIDRS",
+			Ports:       "22",
+			Protocol:    linodego.TCP,
+			Addresses:   linodego.NetworkAddresses{IPv4: &p.SSHAllowedCIDRs},
+		})
+	}
+	return linodego.FirewallRuleSet{
+		Inbound:        rules,
+		InboundPolicy:  "DROP",
+		OutboundPolicy: "ACCEPT",
+	}
+}
+
+// defaultPolicy returns the policy CAPL uses for a LinodeFirewall that doesn't set one.
+func defaultPolicy(policy string) string {
+	if policy == "" {
+		return "ACCEPT"
+	}
+	return policy
+}
+
+// sshAllowedCIDRs returns the CIDRs SSH is allowed from by the default firewall.
+func sshAllowedCIDRs() []string {
+	var cidrs []string
+	for _, cidr := range strings.Split(os.Getenv("LINODE_FIREWALL_SSH_CIDRS"), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return cidrs
+}
+
+// setFirewallID sets the ID of the created firewall on the LinodeFirewall referenced by the control plane machine
+// template in the manifests, so CAPL adopts it instead of creating another firewall with the same label.
+func (p *Infrastructure) setFirewallID(manifest *capiYaml.Manifest) error {
+	if !p.FirewallCreated {
+		return nil
+	}
+	firewall, err := firewallObject(manifest)
+	if err != nil || firewall == nil {
+		return err
+	}
+	return unstructured.SetNestedField(firewall.Object, int64(p.FirewallID), "spec", "firewallID")
+}
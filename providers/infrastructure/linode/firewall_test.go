@@ -0,0 +1,229 @@
+package linode
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/linode/linodego"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"k8s.io/utils/ptr"
+
+	mockClient "capi-bootstrap/providers/infrastructure/linode/mock"
+	"capi-bootstrap/types"
+)
+
+// firewallManifests returns the cluster, control plane and control plane LinodeMachineTemplate, which references the
+// LinodeFirewall firewallName if it isn't empty, followed by documents.
+func firewallManifests(firewallName string, documents ...string) []string {
+	template := `---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeMachineTemplate
+metadata:
+  name: test-cluster-control-plane
+spec:
+  template:
+    spec:
+      region: us-ord`
+	if firewallName != "" {
+		template += fmt.Sprintf(`
+      firewallRef:
+        apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+        kind: LinodeFirewall
+        name: %s`, firewallName)
+	}
+	return append([]string{`---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+spec:
+  controlPlaneRef:
+    apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+    kind: KThreesControlPlane
+    name: test-cluster-control-plane`, `---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+kind: KThreesControlPlane
+metadata:
+  name: test-cluster-control-plane
+spec:
+  machineTemplate:
+    infrastructureRef:
+      apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+      kind: LinodeMachineTemplate
+      name: test-cluster-control-plane`, template}, documents...)
+}
+
+func TestCAPL_CreateFirewall(t *testing.T) {
+	firewallManifest := `---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeFirewall
+metadata:
+  name: test-cluster-firewall
+spec:
+  inboundPolicy: DROP
+  inboundRules:
+  - action: ACCEPT
+    label: api-server
+    ports: "6443"
+    protocol: TCP
+    addresses:
+      ipv4: ["0.0.0.0/0"]
+`
+	existingFirewallManifest := `---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeFirewall
+metadata:
+  name: test-cluster-firewall
+spec:
+  firewallID: 654
+`
+	type test struct {
+		name        string
+		manifests   []string
+		sshCIDRs    []string
+		wantID      int
+		wantCreated bool
+		wantErr     string
+		mockClient  func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient
+	}
+	tests := []test{
+		{
+			name:      "success default",
+			manifests: firewallManifests("", firewallManifest),
+			sshCIDRs:  []string{"203.0.113.0/24"},
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
+				mock.EXPECT().
+					CreateFirewall(ctx, gomock.Cond(func(x any) bool {
+						opts := x.(linodego.FirewallCreateOptions)
+						assert.Equal(t, "test-cluster", opts.Label)
+						assert.Equal(t, []string{"test-cluster"}, opts.Tags)
+						assert.Equal(t, "DROP", opts.Rules.InboundPolicy)
+						assert.Equal(t, "ACCEPT", opts.Rules.OutboundPolicy)
+						assert.Len(t, opts.Rules.Inbound, 4)
+						assert.Equal(t, linodego.FirewallRule{
+							Action:      "ACCEPT",
+							Label:       "ssh",
+							Description: "SSH from LINODE_FIREWALL_SSH_CIDRS",
+							Ports:       "22",
+							Protocol:    linodego.TCP,
+							Addresses:   linodego.NetworkAddresses{IPv4: &[]string{"203.0.113.0/24"}},
+						}, opts.Rules.Inbound[3])
+						return true
+					})).
+					Return(&linodego.Firewall{ID: 321}, nil)
+				return mock
+			},
+			wantID:      321,
+			wantCreated: true,
+		},
+		{
+			name:      "success manifest rules",
+			manifests: firewallManifests("test-cluster-firewall", firewallManifest),
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
+				mock.EXPECT().
+					CreateFirewall(ctx, linodego.FirewallCreateOptions{
+						Label: "test-cluster-firewall",
+						Rules: linodego.FirewallRuleSet{
+							Inbound: []linodego.FirewallRule{{
+								Action:    "ACCEPT",
+								Label:     "api-server",
+								Ports:     "6443",
+								Protocol:  linodego.TCP,
+								Addresses: linodego.NetworkAddresses{IPv4: &[]string{"0.0.0.0/0"}},
+							}},
+							InboundPolicy:  "DROP",
+							OutboundPolicy: "ACCEPT",
+						},
+						Tags: []string{"test-cluster"},
+					}).
+					Return(&linodego.Firewall{ID: 321}, nil)
+				return mock
+			},
+			wantID:      321,
+			wantCreated: true,
+		},
+		{
+			name:      "success existing firewall",
+			manifests: firewallManifests("test-cluster-firewall", existingFirewallManifest),
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
+				return mock
+			},
+			wantID: 654,
+		},
+		{
+			name:      "err firewall not found",
+			manifests: firewallManifests("other-firewall", firewallManifest),
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
+				return mock
+			},
+			wantErr: "LinodeFirewall other-firewall referenced by LinodeMachineTemplate test-cluster-control-plane not found",
+		},
+		{
+			name:      "err no control plane",
+			manifests: []string{firewallManifest},
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
+				return mock
+			},
+			wantErr: "unable to resolve the control plane machine template: cluster not found",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctrl := gomock.NewController(t)
+			mock := mockClient.NewMockLinodeClient(ctrl)
+			ctx := context.Background()
+			infra := &Infrastructure{
+				Client:          tc.mockClient(ctx, t, mock),
+				SSHAllowedCIDRs: tc.sshCIDRs,
+			}
+			err := infra.createFirewall(ctx, &types.Values{ClusterName: "test-cluster", Manifests: parseManifest(t, tc.manifests...)})
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantID, infra.FirewallID)
+			assert.Equal(t, tc.wantCreated, infra.FirewallCreated)
+		})
+	}
+}
+
+func TestCAPL_SetFirewallID(t *testing.T) {
+	t.Parallel()
+	firewall := func(name string) string {
+		return fmt.Sprintf(`---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeFirewall
+metadata:
+  name: %s
+spec:
+  inboundPolicy: DROP`, name)
+	}
+	manifest := parseManifest(t, firewallManifests("test-cluster-firewall", firewall("test-cluster-md-0-firewall"), firewall("test-cluster-firewall"))...)
+	infra := &Infrastructure{FirewallID: 321, FirewallCreated: true}
+	assert.NoError(t, infra.setFirewallID(manifest))
+	actual, err := manifest.Marshal()
+	assert.NoError(t, err)
+	assert.Contains(t, string(actual), `---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeFirewall
+metadata:
+  name: test-cluster-md-0-firewall
+spec:
+  inboundPolicy: DROP
+---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeFirewall
+metadata:
+  name: test-cluster-firewall
+spec:
+  firewallID: 321
+  inboundPolicy: DROP
+`)
+	firewallDef, err := GetFirewallDef(manifest)
+	assert.NoError(t, err)
+	assert.Equal(t, ptr.To(321), firewallDef.Spec.FirewallID)
+}
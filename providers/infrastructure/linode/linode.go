@@ -8,12 +8,18 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/linode/cluster-api-provider-linode/api/v1alpha2"
 	"github.com/linode/linodego"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/klog/v2"
-	"sigs.k8s.io/cluster-api/api/v1beta1"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/yaml"
 
 	"capi-bootstrap/types"
@@ -33,6 +39,10 @@ type LinodeClient interface {
 	CreateInstance(ctx context.Context, opts linodego.InstanceCreateOptions) (*linodego.Instance, error)
 	ListInstances(ctx context.Context, opts *linodego.ListOptions) ([]linodego.Instance, error)
 	DeleteInstance(ctx context.Context, linodeID int) error
+	CreateToken(ctx context.Context, opts linodego.TokenCreateOptions) (*linodego.Token, error)
+	DeleteToken(ctx context.Context, tokenID int) error
+	CreateFirewall(ctx context.Context, opts linodego.FirewallCreateOptions) (*linodego.Firewall, error)
+	DeleteFirewall(ctx context.Context, firewallID int) error
 }
 
 type Infrastructure struct {
@@ -41,9 +51,24 @@ type Infrastructure struct {
 	Machine            *v1alpha2.LinodeMachineTemplate `json:"-"`
 	NodeBalancer       *linodego.NodeBalancer          `json:"-"`
 	NodeBalancerConfig *linodego.NodeBalancerConfig    `json:"-"`
-	Token              string
-	AuthorizedKeys     []string
-	VPC                *v1alpha2.LinodeVPC `json:"-"`
+	// Token is the operator's LINODE_TOKEN, it is only used by the CLI and never stored
+	Token string `json:"-"`
+	// TokenExpiry is how long the ClusterToken is valid, defaultTokenExpiry unless LINODE_TOKEN_EXPIRY is set
+	TokenExpiry time.Duration `json:"-"`
+	// ClusterToken is the scoped token minted for the cluster, it is the token written to the bootstrap machine
+	ClusterToken string `json:"-"`
+	// ClusterTokenID is the ID of the ClusterToken, so it can be revoked when the cluster is deleted
+	ClusterTokenID int
+	// FirewallID is the Cloud Firewall attached to the bootstrap instance
+	FirewallID int
+	// FirewallCreated is set if the firewall was created for the cluster and has to be deleted with it
+	FirewallCreated bool
+	// SSHAllowedCIDRs are the CIDRs the default firewall allows SSH from
+	SSHAllowedCIDRs []string `json:"-"`
+	AuthorizedKeys  []string
+	VPC             *v1alpha2.LinodeVPC `json:"-"`
+	// VPCSubnetID is the subnet of the VPC the bootstrap instance is attached to
+	VPCSubnetID int `json:"-"`
 }
 
 func NewInfrastructure() *Infrastructure {
@@ -58,8 +83,28 @@ func (p *Infrastructure) GenerateCapiFile(_ context.Context, values *types.Value
 }
 
 func (p *Infrastructure) GenerateCapiMachine(ctx context.Context, values *types.Values) (*capiYaml.InitFile, error) {
+	machineSpec, err := p.pivotMachineSpec(values)
+	if err != nil {
+		return nil, err
+	}
+	templateValues := struct {
+		*types.Values
+		Linode *Infrastructure
+		// MachineSpec is the indented spec of the pivot LinodeMachine, without the fields rendered from the instance metadata
+		MachineSpec string
+	}{
+		values,
+		p,
+		machineSpec,
+	}
 	filePath := filepath.Join(values.BootstrapManifestDir, "capi-pivot-machine.yaml")
-	return capiYaml.ConstructFile(filePath, "files/capi-pivot-machine.yaml", files, p.getTemplateValues(values), false)
+	machineFile, err := capiYaml.ConstructFile(filePath, "files/capi-pivot-machine.yaml", files, templateValues, false)
+	if err != nil {
+		return nil, err
+	}
+	// the instance ID is rendered from the instance metadata
+	machineFile.Jinja = true
+	return machineFile, nil
 }
 
 func (p *Infrastructure) GenerateAdditionalFiles(ctx context.Context, values *types.Values) ([]capiYaml.InitFile, error) {
@@ -72,29 +117,111 @@ func (p *Infrastructure) GenerateAdditionalFiles(ctx context.Context, values *ty
 	if err != nil {
 		return nil, err
 	}
+	// the region is rendered from the instance metadata
+	CCMFile.Jinja = true
 	return []capiYaml.InitFile{*CCMFile}, nil
 }
 
+// dryRunToken is the token used in rendered files when LINODE_TOKEN is not set.
+const dryRunToken = "<LINODE_TOKEN>"
+
+// defaultTokenExpiry is how long the cluster token is valid if LINODE_TOKEN_EXPIRY is not set, a leaked token is
+// never valid indefinitely.
+const defaultTokenExpiry = 90 * 24 * time.Hour
+
+// clusterTokenScopes are the scopes of the API calls made by CAPL and the Linode CCM with the cluster token.
+var clusterTokenScopes = []string{
+	// CAPL attaches firewalls to the instances it creates, the CCM manages the firewalls of LoadBalancer Services
+	"firewall:read_write",
+	// CAPL checks if the image of a machine supports cloud-init metadata
+	"images:read_only",
+	// the CCM shares IPs between nodes for LoadBalancer Services
+	"ips:read_write",
+	// CAPL creates, configures, boots and deletes instances and their placement groups, the CCM reads the instances
+	// of the nodes
+	"linodes:read_write",
+	// CAPL adds control plane machines to the NodeBalancer of the API server, the CCM creates NodeBalancers for
+	// LoadBalancer Services
+	"nodebalancers:read_write",
+	// CAPL bootstraps machines with a StackScript in regions or images without cloud-init metadata
+	"stackscripts:read_write",
+	// CAPL creates the VPCs of LinodeVPCs and attaches instances to their subnets
+	"vpc:read_write",
+}
+
+// objectStorageScope is only added to the cluster token if the manifests use CAPL's object storage kinds.
+const objectStorageScope = "object_storage:read_write"
+
+// tokenScopes returns the scopes of the cluster token for the kinds used in manifest.
+func tokenScopes(manifest *capiYaml.Manifest) string {
+	scopes := slices.Clone(clusterTokenScopes)
+	if manifest != nil && (manifest.Get(linodeGVK("LinodeObjectStorageBucket"), "") != nil ||
+		manifest.Get(linodeGVK("LinodeObjectStorageKey"), "") != nil) {
+		scopes = append(scopes, objectStorageScope)
+	}
+	return strings.Join(scopes, ",")
+}
+
 func (p *Infrastructure) PreCmd(ctx context.Context, values *types.Values) error {
 	p.Token = os.Getenv("LINODE_TOKEN")
 
+	if p.Token == "" && values.DryRun {
+		klog.Warningf("LINODE_TOKEN env variable is not set, rendering files with %s", dryRunToken)
+		p.Token = dryRunToken
+	}
 	if p.Token == "" {
 		return errors.New("LINODE_TOKEN env variable is required")
 	}
 	client := NewClient(p.Token, ctx)
 	p.Client = &client
 
+	p.TokenExpiry = defaultTokenExpiry
+	if expiry := os.Getenv("LINODE_TOKEN_EXPIRY"); expiry != "" {
+		tokenExpiry, err := time.ParseDuration(expiry)
+		if err != nil {
+			return fmt.Errorf("invalid LINODE_TOKEN_EXPIRY %q: %s", expiry, err)
+		}
+		if tokenExpiry <= 0 {
+			return fmt.Errorf("invalid LINODE_TOKEN_EXPIRY %q: the cluster token has to expire", expiry)
+		}
+		p.TokenExpiry = tokenExpiry
+	}
+
 	if len(values.SSHAuthorizedKeys) > 0 {
 		p.AuthorizedKeys = values.SSHAuthorizedKeys
 	}
+	p.SSHAllowedCIDRs = sshAllowedCIDRs()
+	if len(p.AuthorizedKeys) > 0 && len(p.SSHAllowedCIDRs) == 0 {
+		klog.Warning("AUTHORIZED_KEYS is set but LINODE_FIREWALL_SSH_CIDRS is not, the firewall will not allow SSH")
+	}
 
 	return nil
 }
 
 func (p *Infrastructure) PreDeploy(ctx context.Context, values *types.Values) error {
-	p.Machine = GetLinodeMachineDef(values.Manifests)
-	if p.Machine == nil {
-		return errors.New("machine not found")
+	machine, err := GetLinodeMachineDef(values.Manifests)
+	if err != nil {
+		return err
+	}
+	p.Machine = machine
+
+	if vpcDef := GetVPCRef(values.Manifests); vpcDef != nil {
+		p.VPC = vpcDef
+	}
+
+	if values.DryRun {
+		// don't create a NodeBalancer, the endpoint that was passed in is used instead
+		p.NodeBalancer = &linodego.NodeBalancer{
+			Label:  &values.ClusterName,
+			Region: p.Machine.Spec.Template.Spec.Region,
+			IPv4:   &values.ClusterEndpoint,
+		}
+		p.NodeBalancerConfig = &linodego.NodeBalancerConfig{
+			Port:     6443,
+			Protocol: "tcp",
+		}
+		p.ClusterToken = dryRunToken
+		return nil
 	}
 
 	nbListFilter, err := json.Marshal(map[string]string{"tags": values.ClusterName})
@@ -136,9 +263,33 @@ func (p *Infrastructure) PreDeploy(ctx context.Context, values *types.Values) er
 
 	values.ClusterEndpoint = *p.NodeBalancer.IPv4
 
-	if vpcDef := GetVPCRef(values.Manifests); vpcDef != nil {
-		p.VPC = vpcDef
+	if err := p.createVPC(ctx); err != nil {
+		return err
 	}
+
+	if err := p.createFirewall(ctx, values); err != nil {
+		return err
+	}
+
+	// the operator's token never leaves the workstation, the cluster gets a token that can only manage its resources.
+	// The token always expires, nothing replaces it in the cluster once it does.
+	tokenExpiry := p.TokenExpiry
+	if tokenExpiry <= 0 {
+		tokenExpiry = defaultTokenExpiry
+	}
+	createOptions := linodego.TokenCreateOptions{
+		Label:  values.ClusterName,
+		Scopes: tokenScopes(values.Manifests),
+		Expiry: ptr.To(time.Now().Add(tokenExpiry)),
+	}
+	clusterToken, err := p.Client.CreateToken(ctx, createOptions)
+	if err != nil {
+		return fmt.Errorf("unable to create token for cluster: %s", err)
+	}
+	p.ClusterToken = clusterToken.Token
+	p.ClusterTokenID = clusterToken.ID
+	klog.Warningf("Created token %s for cluster, it expires at %s and has to be replaced in the cluster before then\n",
+		clusterToken.Label, createOptions.Expiry.Format(time.RFC3339))
 	return nil
 }
 
@@ -152,36 +303,20 @@ func (p *Infrastructure) Deploy(ctx context.Context, values *types.Values, metad
 		Tags:      []string{values.ClusterName},
 		PrivateIP: true,
 		Metadata:  &linodego.InstanceMetadataOptions{UserData: base64.StdEncoding.EncodeToString(metadata)},
+		// attached on creation, so the instance is never reachable without it
+		FirewallID: p.FirewallID,
 	}
 
-	if p.VPC != nil {
-		var vpc *linodego.VPC
-		var vpcSubnets []linodego.VPCSubnetCreateOptions
-		for _, subnet := range p.VPC.Spec.Subnets {
-			vpcSubnets = append(vpcSubnets, linodego.VPCSubnetCreateOptions{
-				Label: subnet.Label,
-				IPv4:  subnet.IPv4,
-			})
+	for _, machineInterface := range p.machineInterfaces() {
+		instanceInterface := linodego.InstanceConfigInterfaceCreateOptions{
+			Purpose:  machineInterface.Purpose,
+			Primary:  machineInterface.Primary,
+			SubnetID: machineInterface.SubnetID,
 		}
-		vpc, err := p.Client.CreateVPC(ctx, linodego.VPCCreateOptions{
-			Label:       p.VPC.Name,
-			Description: p.VPC.Spec.Description,
-			Region:      p.VPC.Spec.Region,
-			Subnets:     vpcSubnets,
-		})
-		if err != nil {
-			return fmt.Errorf("unable to create VPC: %s", err)
+		if machineInterface.IPv4 != nil {
+			instanceInterface.IPv4 = &linodego.VPCIPv4{NAT1To1: &machineInterface.IPv4.NAT1To1}
 		}
-		natAny := "any"
-		createOptions.Interfaces = []linodego.InstanceConfigInterfaceCreateOptions{
-			{
-				Purpose:  linodego.InterfacePurposeVPC,
-				Primary:  true,
-				SubnetID: &vpc.Subnets[0].ID,
-				IPv4: &linodego.VPCIPv4{
-					NAT1To1: &natAny,
-				}},
-			{Purpose: linodego.InterfacePurposePublic}}
+		createOptions.Interfaces = append(createOptions.Interfaces, instanceInterface)
 	}
 
 	if len(p.AuthorizedKeys) > 0 {
@@ -276,6 +411,11 @@ func (p *Infrastructure) Delete(ctx context.Context, values *types.Values, force
 	default:
 		klog.Fatalf("More than one NodeBalaner found for deletion, cannot delete")
 	}
+	if p.FirewallCreated {
+		klog.Infof("Will delete Firewall:\n")
+		klog.Infof("  ID: %d\n", p.FirewallID)
+	}
+
 	var confirm string
 	if !force {
 		klog.Info("Would you like to delete these resources(y/n): ")
@@ -310,60 +450,190 @@ func (p *Infrastructure) Delete(ctx context.Context, values *types.Values, force
 		klog.Infof("  Deleted VPC %s\n", *nodeBal[0].Label)
 	}
 
+	if p.FirewallCreated {
+		if err := p.Client.DeleteFirewall(ctx, p.FirewallID); err != nil && !linodego.IsNotFound(err) {
+			return fmt.Errorf("could not delete Firewall %d: %v", p.FirewallID, err)
+		}
+		klog.Infof("  Deleted Firewall %d\n", p.FirewallID)
+	}
+
+	if p.ClusterTokenID != 0 {
+		if err := p.Client.DeleteToken(ctx, p.ClusterTokenID); err != nil && !linodego.IsNotFound(err) {
+			return fmt.Errorf("could not revoke token %d: %v", p.ClusterTokenID, err)
+		}
+		klog.Infof("  Revoked token %d\n", p.ClusterTokenID)
+	}
+
 	return nil
 }
 
-func GetLinodeMachineDef(manifests []string) *v1alpha2.LinodeMachineTemplate {
+// GetLinodeMachineDef returns the LinodeMachineTemplate of the control plane, which the bootstrap instance is created
+// from. It's resolved through the machine template infrastructureRef of the control plane rather than taken from the
+// first template, since the worker template may come first in the manifests.
+func GetLinodeMachineDef(manifest *capiYaml.Manifest) (*v1alpha2.LinodeMachineTemplate, error) {
+	object, err := controlPlaneMachineTemplate(manifest)
+	if err != nil {
+		return nil, err
+	}
 	var template v1alpha2.LinodeMachineTemplate
-	for _, manifest := range manifests {
-		_ = yaml.Unmarshal([]byte(manifest), &template)
-		if template.Kind == "LinodeMachineTemplate" {
-			return &template
+	if err := capiYaml.DecodeObject(object, &template); err != nil {
+		return nil, fmt.Errorf("could not decode LinodeMachineTemplate %s: %s", object.GetName(), err)
+	}
+	return &template, nil
+}
+
+// controlPlaneMachineTemplate returns the LinodeMachineTemplate object referenced by the control plane in the manifests.
+func controlPlaneMachineTemplate(manifest *capiYaml.Manifest) (*unstructured.Unstructured, error) {
+	ref, err := capiYaml.GetControlPlaneMachineTemplateRef(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve the control plane machine template: %s", err)
+	}
+	if ref.Kind != "LinodeMachineTemplate" {
+		return nil, fmt.Errorf("control plane machine template %s is a %s, not a LinodeMachineTemplate", ref.Name, ref.Kind)
+	}
+	if object := findLinodeObject(manifest, "LinodeMachineTemplate", ref.Name, ref.Namespace); object != nil {
+		return object, nil
+	}
+	return nil, fmt.Errorf("LinodeMachineTemplate %s referenced by the control plane not found", ref.Name)
+}
+
+// findLinodeObject returns the CAPL object of kind with name in the manifests. Objects and references without a
+// namespace match any namespace.
+func findLinodeObject(manifest *capiYaml.Manifest, kind, name, namespace string) *unstructured.Unstructured {
+	for _, object := range manifest.List(linodeGVK(kind)) {
+		if object.GetName() != name {
+			continue
 		}
+		if object.GetNamespace() != "" && namespace != "" && object.GetNamespace() != namespace {
+			continue
+		}
+		return object
 	}
 	return nil
 }
 
-func (p *Infrastructure) UpdateManifests(ctx context.Context, manifests []string, values *types.Values) error {
-	var LinodeClusterIndex int
-	var LinodeCluster v1alpha2.LinodeCluster
-	for i, manifest := range manifests {
-		err := yaml.Unmarshal([]byte(manifest), &LinodeCluster)
-		if err != nil {
-			return err
-		}
-		if LinodeCluster.Kind == "LinodeCluster" {
-			LinodeCluster.Spec.ControlPlaneEndpoint = v1beta1.APIEndpoint{
-				Host: values.ClusterEndpoint,
-				Port: int32(p.NodeBalancerConfig.Port),
-			}
-			LinodeCluster.Spec.Network = v1alpha2.NetworkSpec{
-				LoadBalancerType:              "NodeBalancer",
-				ApiserverLoadBalancerPort:     6443,
-				NodeBalancerID:                &p.NodeBalancer.ID,
-				ApiserverNodeBalancerConfigID: &p.NodeBalancerConfig.ID,
-			}
-			LinodeClusterIndex = i
-			break
+func (p *Infrastructure) UpdateManifests(ctx context.Context, manifest *capiYaml.Manifest, values *types.Values) error {
+	linodeCluster := manifest.Get(linodeGVK("LinodeCluster"), values.InfrastructureName)
+	if linodeCluster == nil {
+		return fmt.Errorf("LinodeCluster %s referenced by the cluster not found", values.InfrastructureName)
+	}
+	fields := map[string]any{
+		"spec.controlPlaneEndpoint.host":             values.ClusterEndpoint,
+		"spec.controlPlaneEndpoint.port":             int64(p.NodeBalancerConfig.Port),
+		"spec.network.loadBalancerType":              "NodeBalancer",
+		"spec.network.apiserverLoadBalancerPort":     int64(6443),
+		"spec.network.nodeBalancerID":                int64(p.NodeBalancer.ID),
+		"spec.network.apiserverNodeBalancerConfigID": int64(p.NodeBalancerConfig.ID),
+	}
+	for field, value := range fields {
+		if err := unstructured.SetNestedField(linodeCluster.Object, value, strings.Split(field, ".")...); err != nil {
+			return fmt.Errorf("could not update LinodeCluster %s: %s", values.InfrastructureName, err)
 		}
 	}
-	LinodeClusterString, err := yaml.Marshal(LinodeCluster)
+	return p.setFirewallID(manifest)
+}
+
+// pivotMachineSpec returns the spec of the LinodeMachine the bootstrap instance is adopted as. It's the spec of the
+// control plane machine template with the settings Deploy creates the instance with, so CAPL sees no drift.
+func (p *Infrastructure) pivotMachineSpec(values *types.Values) (string, error) {
+	spec := p.Machine.Spec.Template.Spec.DeepCopy()
+	spec.Interfaces = p.machineInterfaces()
+	spec.AuthorizedKeys = p.AuthorizedKeys
+	spec.FirewallID = p.FirewallID
+	spec.PrivateIP = ptr.To(true)
+	spec.Tags = []string{values.ClusterName}
+	// the instance is created with a random root password that isn't kept
+	spec.RootPass = ""
+	rawSpec, err := yaml.Marshal(spec)
 	if err != nil {
-		return err
+		return "", err
 	}
-	manifests[LinodeClusterIndex] = string(LinodeClusterString)
+	lines := strings.Split(strings.TrimSuffix(string(rawSpec), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = "  " + line
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// createVPC creates the VPC of the cluster before the bootstrap instance, so the pivot LinodeMachine can be rendered
+// with the subnet the instance is attached to.
+func (p *Infrastructure) createVPC(ctx context.Context) error {
+	if p.VPC == nil {
+		return nil
+	}
+	var vpcSubnets []linodego.VPCSubnetCreateOptions
+	for _, subnet := range p.VPC.Spec.Subnets {
+		vpcSubnets = append(vpcSubnets, linodego.VPCSubnetCreateOptions{
+			Label: subnet.Label,
+			IPv4:  subnet.IPv4,
+		})
+	}
+	vpc, err := p.Client.CreateVPC(ctx, linodego.VPCCreateOptions{
+		Label:       p.VPC.Name,
+		Description: p.VPC.Spec.Description,
+		Region:      p.VPC.Spec.Region,
+		Subnets:     vpcSubnets,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create VPC: %s", err)
+	}
+	if len(vpc.Subnets) == 0 {
+		return fmt.Errorf("VPC %s has no subnets", vpc.Label)
+	}
+	p.VPCSubnetID = vpc.Subnets[0].ID
+	klog.Infof("Created VPC: %s\n", vpc.Label)
 	return nil
 }
 
-func GetVPCRef(manifests []string) *v1alpha2.LinodeVPC {
+// machineInterfaces returns the interfaces of the bootstrap instance. With a VPC it is attached to the first subnet
+// with 1:1 NAT and gets a public interface, otherwise it only has the default public interface.
+func (p *Infrastructure) machineInterfaces() []v1alpha2.InstanceConfigInterfaceCreateOptions {
+	if p.VPC == nil {
+		return nil
+	}
+	vpcInterface := v1alpha2.InstanceConfigInterfaceCreateOptions{
+		Purpose: linodego.InterfacePurposeVPC,
+		Primary: true,
+		IPv4:    &v1alpha2.VPCIPv4{NAT1To1: "any"},
+	}
+	// the subnet isn't known in a dry run since the VPC isn't created
+	if p.VPCSubnetID != 0 {
+		vpcInterface.SubnetID = ptr.To(p.VPCSubnetID)
+	}
+	return []v1alpha2.InstanceConfigInterfaceCreateOptions{
+		vpcInterface,
+		{Purpose: linodego.InterfacePurposePublic},
+	}
+}
+
+func GetVPCRef(manifest *capiYaml.Manifest) *v1alpha2.LinodeVPC {
+	object := manifest.Get(linodeGVK("LinodeVPC"), "")
+	if object == nil {
+		return nil
+	}
 	var vpc v1alpha2.LinodeVPC
-	for _, manifest := range manifests {
-		_ = yaml.Unmarshal([]byte(manifest), &vpc)
-		if vpc.Kind == "LinodeVPC" {
-			return &vpc
-		}
+	if err := capiYaml.DecodeObject(object, &vpc); err != nil {
+		return nil
 	}
-	return nil
+	return &vpc
+}
+
+// References are the references of the CAPL kinds in the manifests.
+var References = []capiYaml.Reference{
+	{From: linodeGVK("LinodeCluster").GroupKind(), Path: []string{"spec", "vpcRef"}, To: linodeGVK("LinodeVPC").GroupKind()},
+	{From: linodeGVK("LinodeMachineTemplate").GroupKind(), Path: []string{"spec", "template", "spec", "placementGroupRef"}, To: linodeGVK("LinodePlacementGroup").GroupKind()},
+	// firewallRef is newer than the CAPL version used to parse manifests, being a reference makes it a known field.
+	{From: linodeGVK("LinodeMachineTemplate").GroupKind(), Path: []string{"spec", "template", "spec", "firewallRef"}, To: linodeGVK("LinodeFirewall").GroupKind()},
+}
+
+// AddToScheme registers the CAPL API types.
+func AddToScheme(scheme *runtime.Scheme) error {
+	return v1alpha2.AddToScheme(scheme)
+}
+
+// linodeGVK matches all versions of the CAPL kind.
+func linodeGVK(kind string) schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: v1alpha2.GroupVersion.Group, Kind: kind}
 }
 
 func (p *Infrastructure) getTemplateValues(v *types.Values) any {
@@ -3,14 +3,18 @@ package linode
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/linode/cluster-api-provider-linode/api/v1alpha2"
 	"github.com/linode/linodego"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/ptr"
@@ -55,22 +59,23 @@ metadata:
   name: linode
   namespace: capl-system
 spec:
-  version: v0.6.0
+  version: "v0.6.0"
   fetchConfig:
-    url: https://github.com/linode/cluster-api-provider-linode/releases/latest/infrastructure-components.yaml
+    url: https://github.com/linode/cluster-api-provider-linode/releases/download/v0.6.0/infrastructure-components.yaml
   configSecret:
     name: capl-variables
 `,
 	}
 	tests := []test{
-		{name: "success", input: types.Values{BootstrapManifestDir: "/test-manifests/"}, want: ptr.To(expectedCapiFile)},
+		{name: "success", input: types.Values{BootstrapManifestDir: "/test-manifests/", Versions: types.DefaultVersions}, want: ptr.To(expectedCapiFile)},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 			ctx := context.Background()
 			Infra := &Infrastructure{
-				Token: "test-token",
+				Token:        "operator-token",
+				ClusterToken: "test-token",
 			}
 
 			actual, err := Infra.GenerateCapiFile(ctx, &tc.input)
@@ -85,33 +90,46 @@ func TestCAPL_GenerateCapiMachine(t *testing.T) {
 	type test struct {
 		name  string
 		input types.Values
+		infra *Infrastructure
 		want  *capiYaml.InitFile
 	}
-	expectedCapiPivotFile := capiYaml.InitFile{
-		Path: string(os.PathSeparator) + filepath.Join("test-manifests", "capi-pivot-machine.yaml"),
-		Content: `---
+	pivotFile := func(namespace, spec string) *capiYaml.InitFile {
+		return &capiYaml.InitFile{
+			Path: string(os.PathSeparator) + filepath.Join("test-manifests", "capi-pivot-machine.yaml"),
+			Content: fmt.Sprintf(`---
+apiVersion: v1
+kind: Secret
+metadata:
+  labels:
+    cluster.x-k8s.io/cluster-name: "test-cluster"
+  name: "test-cluster-bootstrap"
+  namespace: "%[1]s"
+type: cluster.x-k8s.io/secret
+# the bootstrap instance is already provisioned, the secret only has to exist for the Machine to be bootstrapped
+stringData:
+  format: cloud-config
+  value: ""
+---
 apiVersion: cluster.x-k8s.io/v1beta1
 kind: Machine
 metadata:
   labels:
     cluster.x-k8s.io/cluster-name: "test-cluster"
     cluster.x-k8s.io/control-plane: ""
-    cluster.x-k8s.io/control-plane-name: ""
+    cluster.x-k8s.io/control-plane-name: "test-cp"
   name: "test-cluster-bootstrap"
-  namespace: default
+  namespace: "%[1]s"
 spec:
   bootstrap:
-    dataSecretName: linode-test-cluster-crs-0
+    dataSecretName: "test-cluster-bootstrap"
   infrastructureRef:
     apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
     kind: LinodeMachine
     name: "test-cluster-bootstrap"
-    namespace: default
+    namespace: "%[1]s"
   clusterName: "test-cluster"
   providerID: linode://{{ ds.meta_data.id }}
   version: "1.30.0"
-  authorizedKeys:
-    - ssh-rsa blah
 ---
 apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
 kind: LinodeMachine
@@ -119,31 +137,90 @@ metadata:
   labels:
     cluster.x-k8s.io/cluster-name: "test-cluster"
     cluster.x-k8s.io/control-plane: ""
-    cluster.x-k8s.io/control-plane-name: ""
+    cluster.x-k8s.io/control-plane-name: "test-cp"
   name: "test-cluster-bootstrap"
-  namespace: default
+  namespace: "%[1]s"
 spec:
-  image: linode/ubuntu22.04
   instanceID: {{ ds.meta_data.id }}
   providerID: "linode://{{ ds.meta_data.id }}"
-  region: "{{ ds.meta_data.region }}"
-  type: g6-standard-6
-  authorizedKeys:
-    - ssh-rsa blah
-`,
+%[2]s
+`, namespace, spec),
+		}
 	}
+	machine := &v1alpha2.LinodeMachineTemplate{
+		Spec: v1alpha2.LinodeMachineTemplateSpec{
+			Template: v1alpha2.LinodeMachineTemplateResource{Spec: v1alpha2.LinodeMachineSpec{
+				Image:          "linode/ubuntu22.04",
+				Region:         "us-mia",
+				Type:           "g6-standard-4",
+				RootPass:       "template-pass",
+				DiskEncryption: "enabled",
+				Interfaces:     []v1alpha2.InstanceConfigInterfaceCreateOptions{{Purpose: linodego.InterfacePurposePublic}},
+			}},
+		},
+	}
+	topologyPivotFile := pivotFile("default", `  diskEncryption: enabled
+  image: linode/ubuntu22.04
+  privateIP: true
+  region: us-mia
+  tags:
+  - test-cluster
+  type: g6-standard-4`)
+	topologyPivotFile.Content = strings.ReplaceAll(topologyPivotFile.Content, `
+    cluster.x-k8s.io/control-plane-name: "test-cp"`, `
+    cluster.x-k8s.io/control-plane-name: "test-cp"
+    topology.cluster.x-k8s.io/owned: ""`)
 	tests := []test{
-		{name: "success", input: types.Values{ClusterName: "test-cluster", K8sVersion: "1.30.0", BootstrapManifestDir: "/test-manifests/", SSHAuthorizedKeys: []string{"ssh-rsa blah"}}, want: ptr.To(expectedCapiPivotFile)},
+		{
+			name:  "success",
+			input: types.Values{ClusterName: "test-cluster", Namespace: "default", ControlPlaneName: "test-cp", K8sVersion: "1.30.0", BootstrapManifestDir: "/test-manifests/"},
+			infra: &Infrastructure{Machine: machine, FirewallID: 321, AuthorizedKeys: []string{"ssh-rsa blah"}},
+			want: pivotFile("default", `  authorizedKeys:
+  - ssh-rsa blah
+  diskEncryption: enabled
+  firewallID: 321
+  image: linode/ubuntu22.04
+  privateIP: true
+  region: us-mia
+  tags:
+  - test-cluster
+  type: g6-standard-4`),
+		},
+		{
+			name:  "success vpc",
+			input: types.Values{ClusterName: "test-cluster", Namespace: "capl", ControlPlaneName: "test-cp", K8sVersion: "1.30.0", BootstrapManifestDir: "/test-manifests/"},
+			infra: &Infrastructure{Machine: machine, VPC: &v1alpha2.LinodeVPC{}, VPCSubnetID: 456},
+			want: pivotFile("capl", `  diskEncryption: enabled
+  image: linode/ubuntu22.04
+  interfaces:
+  - ipv4:
+      nat1to1: any
+    primary: true
+    purpose: vpc
+    subnetId: 456
+  - purpose: public
+  privateIP: true
+  region: us-mia
+  tags:
+  - test-cluster
+  type: g6-standard-4`),
+		},
+		{
+			name:  "success topology",
+			input: types.Values{ClusterName: "test-cluster", Namespace: "default", ControlPlaneName: "test-cp", K8sVersion: "1.30.0", BootstrapManifestDir: "/test-manifests/", Topology: true},
+			infra: &Infrastructure{Machine: machine},
+			want:  topologyPivotFile,
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 			ctx := context.Background()
-			Infra := Infrastructure{}
-			actual, err := Infra.GenerateCapiMachine(ctx, &tc.input)
+			actual, err := tc.infra.GenerateCapiMachine(ctx, &tc.input)
 			assert.NoError(t, err)
 			assert.Equal(t, tc.want.Path, actual.Path, "expected file path: %s", tc.want.Path)
 			assert.Equal(t, tc.want.Content, actual.Content, "expected file contents: %s", tc.want.Content)
+			assert.True(t, actual.Jinja, "expected file to be rendered with jinja")
 		})
 	}
 }
@@ -165,13 +242,13 @@ metadata:
   name: ccm-linode
 spec:
   targetNamespace: kube-system
-  version: v0.4.10
+  version: "v0.4.10"
   chart: ccm-linode
   repo: https://linode.github.io/linode-cloud-controller-manager/
   bootstrap: true
   valuesContent: |-
     routeController:
-      vpcName: test-cluster
+      vpcName: test-vpc
       clusterCIDR: 10.0.0.0/8
       configureCloudRoutes: true
     secretRef:
@@ -203,7 +280,7 @@ metadata:
   name: ccm-linode
 spec:
   targetNamespace: kube-system
-  version: v0.4.10
+  version: "v0.4.10"
   chart: ccm-linode
   repo: https://linode.github.io/linode-cloud-controller-manager/
   bootstrap: true
@@ -228,8 +305,8 @@ stringData:
 `,
 	}}
 	tests := []test{
-		{name: "success vpc", infra: &Infrastructure{Token: "test-token", VPC: &v1alpha2.LinodeVPC{}}, input: types.Values{ClusterName: "test-cluster", K8sVersion: "1.30.0", BootstrapManifestDir: "/test-manifests/"}, want: expectedVPCFile},
-		{name: "success no vpc", infra: &Infrastructure{Token: "test-token"}, input: types.Values{ClusterName: "test-cluster", K8sVersion: "1.30.0", BootstrapManifestDir: "/test-manifests/"}, want: expectedVPCLessFile},
+		{name: "success vpc", infra: &Infrastructure{Token: "operator-token", ClusterToken: "test-token", VPC: &v1alpha2.LinodeVPC{ObjectMeta: v1.ObjectMeta{Name: "test-vpc"}}}, input: types.Values{ClusterName: "test-cluster", K8sVersion: "1.30.0", BootstrapManifestDir: "/test-manifests/", Versions: types.DefaultVersions}, want: expectedVPCFile},
+		{name: "success no vpc", infra: &Infrastructure{Token: "operator-token", ClusterToken: "test-token"}, input: types.Values{ClusterName: "test-cluster", K8sVersion: "1.30.0", BootstrapManifestDir: "/test-manifests/", Versions: types.DefaultVersions}, want: expectedVPCLessFile},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -239,6 +316,7 @@ stringData:
 			for i, actual := range actualFiles {
 				assert.Equal(t, tc.want[i].Path, actual.Path, "expected file path: %s", tc.want[i].Path)
 				assert.Equal(t, tc.want[i].Content, actual.Content, "expected file contents: %s", tc.want[i].Content)
+				assert.True(t, actual.Jinja, "expected file to be rendered with jinja")
 			}
 		})
 	}
@@ -246,18 +324,25 @@ stringData:
 
 func TestCAPL_PreCmd(t *testing.T) {
 	type test struct {
-		name  string
-		input string
-		err   string
+		name       string
+		input      string
+		expiry     string
+		wantExpiry time.Duration
+		err        string
 	}
 
 	tests := []test{
-		{name: "success", input: "test-token", err: ""},
+		{name: "success", input: "test-token", wantExpiry: defaultTokenExpiry, err: ""},
+		{name: "success token expiry", input: "test-token", expiry: "24h", wantExpiry: 24 * time.Hour},
+		{name: "success longer token expiry", input: "test-token", expiry: "4380h", wantExpiry: 4380 * time.Hour},
 		{name: "err no token", input: "", err: "LINODE_TOKEN env variable is required"},
+		{name: "err invalid token expiry", input: "test-token", expiry: "1d", err: "invalid LINODE_TOKEN_EXPIRY \"1d\": time: unknown unit \"d\" in duration \"1d\""},
+		{name: "err token without expiry", input: "test-token", expiry: "0s", err: "invalid LINODE_TOKEN_EXPIRY \"0s\": the cluster token has to expire"},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Setenv("LINODE_TOKEN", tc.input)
+			t.Setenv("LINODE_TOKEN_EXPIRY", tc.expiry)
 			ctx := context.Background()
 			Infra := Infrastructure{}
 			actualValues := types.Values{}
@@ -267,22 +352,61 @@ func TestCAPL_PreCmd(t *testing.T) {
 			} else {
 				assert.NoError(t, err)
 				assert.NotNil(t, Infra.Client)
+				assert.Equal(t, tc.wantExpiry, Infra.TokenExpiry)
 			}
 		})
 	}
 }
 
+func TestTokenScopes(t *testing.T) {
+	bucket := `---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha1
+kind: LinodeObjectStorageBucket
+metadata:
+  name: test-bucket`
+	type test struct {
+		name     string
+		manifest *capiYaml.Manifest
+		want     string
+	}
+	tests := []test{
+		{name: "success no manifest", want: "firewall:read_write,images:read_only,ips:read_write,linodes:read_write,nodebalancers:read_write,stackscripts:read_write,vpc:read_write"},
+		{name: "success object storage", manifest: parseManifest(t, bucket), want: "firewall:read_write,images:read_only,ips:read_write,linodes:read_write,nodebalancers:read_write,stackscripts:read_write,vpc:read_write,object_storage:read_write"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, tokenScopes(tc.manifest))
+		})
+	}
+}
+
 func TestCAPL_PreDeploy(t *testing.T) {
 	type test struct {
-		name       string
-		input      types.Values
-		want       types.Values
-		wantErr    string
-		mockClient func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient
+		name         string
+		input        types.Values
+		want         types.Values
+		wantToken    string
+		wantFirewall int
+		wantSubnet   int
+		wantErr      string
+		mockClient   func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient
 	}
 	manifests := []string{`---
 apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
 kind: LinodeMachineTemplate
+metadata:
+  name: test-cluster-md-0
+  namespace: default
+spec:
+  template:
+    spec:
+      image: linode/ubuntu22.04
+      region: us-ord
+      type: g6-standard-2`,
+		`---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeMachineTemplate
 metadata:
   name: test-cluster-control-plane
   namespace: default
@@ -295,6 +419,35 @@ spec:
       region: us-mia
       type: g6-standard-4`,
 		`---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+  namespace: default
+spec:
+  controlPlaneRef:
+    apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+    kind: KThreesControlPlane
+    name: test-cluster-control-plane
+  infrastructureRef:
+    apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+    kind: LinodeCluster
+    name: test-cluster`,
+		`---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+kind: KThreesControlPlane
+metadata:
+  name: test-cluster-control-plane
+  namespace: default
+spec:
+  machineTemplate:
+    infrastructureRef:
+      apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+      kind: LinodeMachineTemplate
+      name: test-cluster-control-plane
+  replicas: 3
+  version: v1.29.1+k3s2`,
+		`---
 apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
 kind: LinodeVPC
 metadata:
@@ -312,7 +465,7 @@ spec:
 	tests := []test{
 		{
 			name:  "success",
-			input: types.Values{ClusterName: "test-cluster", Manifests: manifests, BootstrapManifestDir: "/test-manifests/"},
+			input: types.Values{ClusterName: "test-cluster", Manifests: parseManifest(t, manifests...), BootstrapManifestDir: "/test-manifests/"},
 			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
 				mock.EXPECT().
 					ListNodeBalancers(ctx, linodego.NewListOptions(1, `{"tags":"test-cluster"}`)).
@@ -332,11 +485,52 @@ spec:
 						Check:     "connection",
 					}).
 					Return(ptr.To(linodego.NodeBalancerConfig{ID: 789}), nil)
+				mock.EXPECT().
+					CreateVPC(ctx, linodego.VPCCreateOptions{
+						Label:   "test-cluster",
+						Region:  "us-mia",
+						Subnets: []linodego.VPCSubnetCreateOptions{{Label: "default", IPv4: "10.0.0.0/8"}},
+					}).
+					Return(&linodego.VPC{ID: 987, Label: "test-cluster", Subnets: []linodego.VPCSubnet{{ID: 456}}}, nil)
+				mock.EXPECT().
+					CreateFirewall(ctx, gomock.Cond(func(x any) bool {
+						opts := x.(linodego.FirewallCreateOptions)
+						assert.Equal(t, "test-cluster", opts.Label)
+						assert.Equal(t, "DROP", opts.Rules.InboundPolicy)
+						assert.Equal(t, []string{"192.168.255.0/24"}, *opts.Rules.Inbound[0].Addresses.IPv4)
+						assert.Equal(t, []string{"192.168.128.0/17", "10.0.0.0/8"}, *opts.Rules.Inbound[1].Addresses.IPv4)
+						return true
+					})).
+					Return(&linodego.Firewall{ID: 321, Label: "test-cluster"}, nil)
+				mock.EXPECT().
+					CreateToken(ctx, gomock.Cond(func(x any) bool {
+						opts := x.(linodego.TokenCreateOptions)
+						assert.Equal(t, "test-cluster", opts.Label)
+						assert.NotContains(t, opts.Scopes, "*")
+						assert.Equal(t, "firewall:read_write,images:read_only,ips:read_write,linodes:read_write,nodebalancers:read_write,stackscripts:read_write,vpc:read_write", opts.Scopes)
+						assert.WithinDuration(t, time.Now().Add(time.Hour), *opts.Expiry, time.Minute)
+						return true
+					})).
+					Return(&linodego.Token{ID: 456, Label: "test-cluster", Token: "cluster-token"}, nil)
 				return mock
 			},
 			want: types.Values{
 				ClusterEndpoint: "1.2.3.4",
 			},
+			wantToken:    "cluster-token",
+			wantFirewall: 321,
+			wantSubnet:   456,
+		},
+		{
+			name:  "success dry run",
+			input: types.Values{ClusterName: "test-cluster", Manifests: parseManifest(t, manifests...), BootstrapManifestDir: "/test-manifests/", ClusterEndpoint: "127.0.0.1", DryRun: true},
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
+				return mock
+			},
+			want: types.Values{
+				ClusterEndpoint: "127.0.0.1",
+			},
+			wantToken: "<LINODE_TOKEN>",
 		},
 		{
 			name:  "err machine not found",
@@ -344,11 +538,11 @@ spec:
 			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
 				return mock
 			},
-			wantErr: "machine not found",
+			wantErr: "unable to resolve the control plane machine template: cluster not found",
 		},
 		{
 			name:  "err list NodeBalancer",
-			input: types.Values{ClusterName: "test-cluster", Manifests: manifests, BootstrapManifestDir: "/test-manifests/"},
+			input: types.Values{ClusterName: "test-cluster", Manifests: parseManifest(t, manifests...), BootstrapManifestDir: "/test-manifests/"},
 			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
 				mock.EXPECT().
 					ListNodeBalancers(ctx, linodego.NewListOptions(1, `{"tags":"test-cluster"}`)).
@@ -359,7 +553,7 @@ spec:
 		},
 		{
 			name:  "err existing NodeBalancer",
-			input: types.Values{ClusterName: "test-cluster", Manifests: manifests, BootstrapManifestDir: "/test-manifests/"},
+			input: types.Values{ClusterName: "test-cluster", Manifests: parseManifest(t, manifests...), BootstrapManifestDir: "/test-manifests/"},
 			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
 				mock.EXPECT().
 					ListNodeBalancers(ctx, linodego.NewListOptions(1, `{"tags":"test-cluster"}`)).
@@ -370,7 +564,7 @@ spec:
 		},
 		{
 			name:  "err create NodeBalancer",
-			input: types.Values{ClusterName: "test-cluster", Manifests: manifests, BootstrapManifestDir: "/test-manifests/"},
+			input: types.Values{ClusterName: "test-cluster", Manifests: parseManifest(t, manifests...), BootstrapManifestDir: "/test-manifests/"},
 			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
 				mock.EXPECT().
 					ListNodeBalancers(ctx, linodego.NewListOptions(1, `{"tags":"test-cluster"}`)).
@@ -388,7 +582,7 @@ spec:
 		},
 		{
 			name:  "err no ipv4",
-			input: types.Values{ClusterName: "test-cluster", Manifests: manifests, BootstrapManifestDir: "/test-manifests/"},
+			input: types.Values{ClusterName: "test-cluster", Manifests: parseManifest(t, manifests...), BootstrapManifestDir: "/test-manifests/"},
 			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
 				mock.EXPECT().
 					ListNodeBalancers(ctx, linodego.NewListOptions(1, `{"tags":"test-cluster"}`)).
@@ -412,6 +606,83 @@ spec:
 			},
 			wantErr: "no node IPv4 address on NodeBalancer",
 		},
+		{
+			name:  "err create token",
+			input: types.Values{ClusterName: "test-cluster", Manifests: parseManifest(t, manifests...), BootstrapManifestDir: "/test-manifests/"},
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
+				mock.EXPECT().
+					ListNodeBalancers(ctx, gomock.Any()).
+					Return([]linodego.NodeBalancer{}, nil)
+				mock.EXPECT().
+					CreateNodeBalancer(ctx, gomock.Any()).
+					Return(ptr.To(linodego.NodeBalancer{ID: 123, IPv4: ptr.To("1.2.3.4"), Label: ptr.To("test-cluster")}), nil)
+				mock.EXPECT().
+					CreateNodeBalancerConfig(ctx, 123, gomock.Any()).
+					Return(ptr.To(linodego.NodeBalancerConfig{ID: 789}), nil)
+				mock.EXPECT().
+					CreateVPC(ctx, linodego.VPCCreateOptions{
+						Label:   "test-cluster",
+						Region:  "us-mia",
+						Subnets: []linodego.VPCSubnetCreateOptions{{Label: "default", IPv4: "10.0.0.0/8"}},
+					}).
+					Return(&linodego.VPC{ID: 987, Label: "test-cluster", Subnets: []linodego.VPCSubnet{{ID: 456}}}, nil)
+				mock.EXPECT().
+					CreateFirewall(ctx, gomock.Any()).
+					Return(&linodego.Firewall{ID: 321, Label: "test-cluster"}, nil)
+				mock.EXPECT().
+					CreateToken(ctx, gomock.Any()).
+					Return(nil, errors.New("could not connect to linode"))
+				return mock
+			},
+			wantErr: "unable to create token for cluster: could not connect to linode",
+		},
+		{
+			name:  "err create VPC",
+			input: types.Values{ClusterName: "test-cluster", Manifests: parseManifest(t, manifests...), BootstrapManifestDir: "/test-manifests/"},
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
+				mock.EXPECT().
+					ListNodeBalancers(ctx, gomock.Any()).
+					Return([]linodego.NodeBalancer{}, nil)
+				mock.EXPECT().
+					CreateNodeBalancer(ctx, gomock.Any()).
+					Return(ptr.To(linodego.NodeBalancer{ID: 123, IPv4: ptr.To("1.2.3.4"), Label: ptr.To("test-cluster")}), nil)
+				mock.EXPECT().
+					CreateNodeBalancerConfig(ctx, 123, gomock.Any()).
+					Return(ptr.To(linodego.NodeBalancerConfig{ID: 789}), nil)
+				mock.EXPECT().
+					CreateVPC(ctx, gomock.Any()).
+					Return(nil, errors.New("could not connect to linode"))
+				return mock
+			},
+			wantErr: "unable to create VPC: could not connect to linode",
+		},
+		{
+			name:  "err create firewall",
+			input: types.Values{ClusterName: "test-cluster", Manifests: parseManifest(t, manifests...), BootstrapManifestDir: "/test-manifests/"},
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
+				mock.EXPECT().
+					ListNodeBalancers(ctx, gomock.Any()).
+					Return([]linodego.NodeBalancer{}, nil)
+				mock.EXPECT().
+					CreateNodeBalancer(ctx, gomock.Any()).
+					Return(ptr.To(linodego.NodeBalancer{ID: 123, IPv4: ptr.To("1.2.3.4"), Label: ptr.To("test-cluster")}), nil)
+				mock.EXPECT().
+					CreateNodeBalancerConfig(ctx, 123, gomock.Any()).
+					Return(ptr.To(linodego.NodeBalancerConfig{ID: 789}), nil)
+				mock.EXPECT().
+					CreateVPC(ctx, linodego.VPCCreateOptions{
+						Label:   "test-cluster",
+						Region:  "us-mia",
+						Subnets: []linodego.VPCSubnetCreateOptions{{Label: "default", IPv4: "10.0.0.0/8"}},
+					}).
+					Return(&linodego.VPC{ID: 987, Label: "test-cluster", Subnets: []linodego.VPCSubnet{{ID: 456}}}, nil)
+				mock.EXPECT().
+					CreateFirewall(ctx, gomock.Any()).
+					Return(nil, errors.New("could not connect to linode"))
+				return mock
+			},
+			wantErr: "unable to create Firewall: could not connect to linode",
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -422,12 +693,16 @@ spec:
 			Infra := Infrastructure{
 				Client:         tc.mockClient(ctx, t, mock),
 				Token:          "test-token",
+				TokenExpiry:    time.Hour,
 				AuthorizedKeys: []string{"test-key"},
 			}
 			err := Infra.PreDeploy(ctx, &tc.input)
 			if tc.wantErr == "" {
 				assert.NoError(t, err)
 				assert.Equal(t, tc.want.ClusterEndpoint, tc.input.ClusterEndpoint)
+				assert.Equal(t, tc.wantToken, Infra.ClusterToken)
+				assert.Equal(t, tc.wantFirewall, Infra.FirewallID)
+				assert.Equal(t, tc.wantSubnet, Infra.VPCSubnetID)
 				assert.Equal(t, Infra.AuthorizedKeys, Infra.AuthorizedKeys)
 				assert.NotNil(t, Infra.Machine)
 				assert.NotNil(t, Infra.NodeBalancer)
@@ -457,23 +732,6 @@ func TestCAPL_Deploy(t *testing.T) {
 				BootstrapManifestDir: "/test-manifests/",
 			},
 			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
-				mock.EXPECT().
-					CreateVPC(ctx, linodego.VPCCreateOptions{
-						Label:       "test-cluster",
-						Description: "",
-						Region:      "us-mia",
-						Subnets: []linodego.VPCSubnetCreateOptions{{
-							Label: "pod network",
-							IPv4:  "10.0.0.0/8",
-						}},
-					}).
-					Return(ptr.To(linodego.VPC{
-						ID:     123,
-						Region: "",
-						Subnets: []linodego.VPCSubnet{{
-							ID: 456,
-						}},
-					}), nil)
 				mock.EXPECT().
 					CreateInstance(ctx, gomock.Cond(func(x any) bool {
 						createOptions := x.(linodego.InstanceCreateOptions)
@@ -484,8 +742,11 @@ func TestCAPL_Deploy(t *testing.T) {
 						assert.Equal(t, createOptions.AuthorizedKeys, []string{"test-key"})
 						assert.Equal(t, createOptions.Tags, []string{"test-cluster"})
 						assert.Len(t, createOptions.Interfaces, 2)
+						assert.Equal(t, ptr.To(456), createOptions.Interfaces[0].SubnetID)
+						assert.Equal(t, ptr.To("any"), createOptions.Interfaces[0].IPv4.NAT1To1)
 						assert.Equal(t, createOptions.Interfaces[1].Purpose, linodego.InterfacePurposePublic)
 						assert.NotNil(t, createOptions.Metadata)
+						assert.Equal(t, 321, createOptions.FirewallID)
 						return true
 					})).
 					Return(ptr.To(linodego.Instance{IPv4: []*net.IP{{192, 168, 3, 4}}}), nil)
@@ -534,12 +795,14 @@ func TestCAPL_Deploy(t *testing.T) {
 						CredentialsRef: nil,
 					},
 				},
+				VPCSubnetID: 456,
 				NodeBalancer: &linodego.NodeBalancer{
 					ID: 1234,
 				},
 				NodeBalancerConfig: &linodego.NodeBalancerConfig{
 					ID: 5678,
 				},
+				FirewallID:     321,
 				Token:          "test-token",
 				AuthorizedKeys: []string{"test-key"},
 			}
@@ -597,6 +860,12 @@ func TestCAPL_Delete(t *testing.T) {
 				mock.EXPECT().
 					DeleteVPC(ctx, 123).
 					Return(nil)
+				mock.EXPECT().
+					DeleteFirewall(ctx, 321).
+					Return(nil)
+				mock.EXPECT().
+					DeleteToken(ctx, 456).
+					Return(nil)
 				return mock
 			},
 			force: true,
@@ -733,6 +1002,32 @@ func TestCAPL_Delete(t *testing.T) {
 			force:   true,
 			wantErr: "could not delete VPC test-cluster: could not connect to linode",
 		},
+		{
+			name: "err revoke token",
+			input: types.Values{
+				ClusterName: "test-cluster",
+			},
+			mockClient: func(ctx context.Context, t *testing.T, mock *mockClient.MockLinodeClient) *mockClient.MockLinodeClient {
+				mock.EXPECT().
+					ListInstances(ctx, gomock.Any()).
+					Return([]linodego.Instance{}, nil)
+				mock.EXPECT().
+					ListVPCs(ctx, gomock.Any()).
+					Return([]linodego.VPC{}, nil)
+				mock.EXPECT().
+					ListNodeBalancers(ctx, gomock.Any()).
+					Return([]linodego.NodeBalancer{}, nil)
+				mock.EXPECT().
+					DeleteFirewall(ctx, 321).
+					Return(&linodego.Error{Code: 404})
+				mock.EXPECT().
+					DeleteToken(ctx, 456).
+					Return(errors.New("could not connect to linode"))
+				return mock
+			},
+			force:   true,
+			wantErr: "could not revoke token 456: could not connect to linode",
+		},
 	}
 
 	for _, tc := range tests {
@@ -742,9 +1037,12 @@ func TestCAPL_Delete(t *testing.T) {
 			t.Setenv("AUTHORIZED_KEYS", "test-key")
 			ctx := context.Background()
 			Infra := Infrastructure{
-				Client:         tc.mockClient(ctx, t, mock),
-				Token:          "test-token",
-				AuthorizedKeys: []string{"test-key"},
+				Client:          tc.mockClient(ctx, t, mock),
+				Token:           "test-token",
+				ClusterTokenID:  456,
+				FirewallID:      321,
+				FirewallCreated: true,
+				AuthorizedKeys:  []string{"test-key"},
 			}
 			err := Infra.Delete(ctx, &tc.input, tc.force)
 			if tc.wantErr == "" {
@@ -755,11 +1053,104 @@ func TestCAPL_Delete(t *testing.T) {
 		})
 	}
 }
+func TestGetLinodeMachineDef(t *testing.T) {
+	cluster := `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+  namespace: default
+spec:
+  controlPlaneRef:
+    apiVersion: controlplane.cluster.x-k8s.io/v1beta1
+    kind: KubeadmControlPlane
+    name: test-cluster-control-plane`
+	controlPlane := func(kind, name string) string {
+		return fmt.Sprintf(`---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta1
+kind: KubeadmControlPlane
+metadata:
+  name: test-cluster-control-plane
+  namespace: default
+spec:
+  machineTemplate:
+    infrastructureRef:
+      apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+      kind: %s
+      name: %s`, kind, name)
+	}
+	template := func(name, region string) string {
+		return fmt.Sprintf(`---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeMachineTemplate
+metadata:
+  name: %s
+  namespace: default
+spec:
+  template:
+    spec:
+      region: %s`, name, region)
+	}
+	type test struct {
+		name       string
+		input      []string
+		wantRegion string
+		wantErr    string
+	}
+	tests := []test{
+		{
+			name: "success",
+			input: []string{
+				template("test-cluster-md-0", "us-ord"),
+				template("test-cluster-control-plane", "us-mia"),
+				cluster,
+				controlPlane("LinodeMachineTemplate", "test-cluster-control-plane"),
+			},
+			wantRegion: "us-mia",
+		},
+		{
+			name: "err not a LinodeMachineTemplate",
+			input: []string{
+				cluster,
+				controlPlane("DockerMachineTemplate", "test-cluster-control-plane"),
+			},
+			wantErr: "control plane machine template test-cluster-control-plane is a DockerMachineTemplate, not a LinodeMachineTemplate",
+		},
+		{
+			name: "err template not found",
+			input: []string{
+				template("test-cluster-md-0", "us-ord"),
+				cluster,
+				controlPlane("LinodeMachineTemplate", "test-cluster-control-plane"),
+			},
+			wantErr: "LinodeMachineTemplate test-cluster-control-plane referenced by the control plane not found",
+		},
+		{
+			name:    "err control plane not found",
+			input:   []string{template("test-cluster-control-plane", "us-mia"), cluster},
+			wantErr: "unable to resolve the control plane machine template: KubeadmControlPlane test-cluster-control-plane referenced by the cluster not found",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			machine, err := GetLinodeMachineDef(parseManifest(t, tc.input...))
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantRegion, machine.Spec.Template.Spec.Region)
+			}
+		})
+	}
+}
+
 func TestCAPL_UpdateManifests(t *testing.T) {
 	type test struct {
-		name  string
-		input types.Values
-		want  []string
+		name    string
+		input   types.Values
+		want    string
+		wantErr string
 	}
 	manifests := []string{`---
 apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
@@ -790,7 +1181,7 @@ spec:
     kind: LinodeVPC
     name: test-vpc-k3s
 `}
-	expectedManifests := []string{`---
+	expectedManifest := `---
 apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
 kind: LinodeMachineTemplate
 metadata:
@@ -803,39 +1194,49 @@ spec:
       interfaces:
       - purpose: public
       region: us-mia
-      type: g6-standard-4`,
-		`---
+      type: g6-standard-4
+---
 apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
 kind: LinodeCluster
 metadata:
   name: test-vpc-k3s
   namespace: default
 spec:
+  controlPlaneEndpoint:
+    host: api-server.test.com
+    port: 6443
   credentialsRef:
     name: test-vpc-k3s-credentials
+  network:
+    apiserverLoadBalancerPort: 6443
+    apiserverNodeBalancerConfigID: 5678
+    loadBalancerType: NodeBalancer
+    nodeBalancerID: 1234
   region: us-mia
   vpcRef:
     apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
     kind: LinodeVPC
     name: test-vpc-k3s
-  controlPlaneEndpoint:
-    api-server.test.com
-    port: 6443
-  network
-    loadBalancerType: NodeBalancer
-    apiserverLoadBalancerPort: 6443
-    nodeBalancerID: 1234
-    apiserverNodeBalancerConfigID: 5678
-`}
+`
 
 	tests := []test{
 		{
 			name: "success",
 			input: types.Values{
-				ClusterName:     "test-cluster",
-				ClusterEndpoint: "api-server.test.com",
+				ClusterName:        "test-cluster",
+				InfrastructureName: "test-vpc-k3s",
+				ClusterEndpoint:    "api-server.test.com",
 			},
-			want: expectedManifests},
+			want: expectedManifest},
+		{
+			name: "err LinodeCluster not found",
+			input: types.Values{
+				ClusterName:        "test-cluster",
+				InfrastructureName: "other-cluster",
+				ClusterEndpoint:    "api-server.test.com",
+			},
+			wantErr: "LinodeCluster other-cluster referenced by the cluster not found",
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -845,11 +1246,16 @@ spec:
 				NodeBalancer:       &linodego.NodeBalancer{ID: 1234},
 				NodeBalancerConfig: &linodego.NodeBalancerConfig{ID: 5678, Port: 6443},
 			}
-			err := infra.UpdateManifests(ctx, manifests, &tc.input)
-			assert.NoError(t, err)
-			for i, actualFile := range tc.input.Manifests {
-				assert.Equal(t, tc.want[i], actualFile, "expected file: %s", tc.want[i])
+			manifest := parseManifest(t, manifests...)
+			err := infra.UpdateManifests(ctx, manifest, &tc.input)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
 			}
+			assert.NoError(t, err)
+			actual, err := manifest.Marshal()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, string(actual))
 		})
 	}
 }
@@ -864,3 +1270,11 @@ func TestCAPL_PostDeploy(t *testing.T) {
 	err := infra.PostDeploy(ctx, &actualValues)
 	assert.NoError(t, err)
 }
+
+// parseManifest joins the documents into a manifest like the one parsed from the manifest file.
+func parseManifest(t *testing.T, documents ...string) *capiYaml.Manifest {
+	t.Helper()
+	manifest, err := capiYaml.ParseManifest(strings.Join(documents, "\n"))
+	require.NoError(t, err)
+	return manifest
+}
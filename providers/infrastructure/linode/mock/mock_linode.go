@@ -40,6 +40,21 @@ func (m *MockLinodeClient) EXPECT() *MockLinodeClientMockRecorder {
 	return m.recorder
 }
 
+// CreateFirewall mocks base method.
+func (m *MockLinodeClient) CreateFirewall(ctx context.Context, opts linodego.FirewallCreateOptions) (*linodego.Firewall, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateFirewall", ctx, opts)
+	ret0, _ := ret[0].(*linodego.Firewall)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateFirewall indicates an expected call of CreateFirewall.
+func (mr *MockLinodeClientMockRecorder) CreateFirewall(ctx, opts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateFirewall", reflect.TypeOf((*MockLinodeClient)(nil).CreateFirewall), ctx, opts)
+}
+
 // CreateInstance mocks base method.
 func (m *MockLinodeClient) CreateInstance(ctx context.Context, opts linodego.InstanceCreateOptions) (*linodego.Instance, error) {
 	m.ctrl.T.Helper()
@@ -100,6 +115,21 @@ func (mr *MockLinodeClientMockRecorder) CreateNodeBalancerNode(ctx, nodebalancer
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateNodeBalancerNode", reflect.TypeOf((*MockLinodeClient)(nil).CreateNodeBalancerNode), ctx, nodebalancerID, configID, opts)
 }
 
+// CreateToken mocks base method.
+func (m *MockLinodeClient) CreateToken(ctx context.Context, opts linodego.TokenCreateOptions) (*linodego.Token, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateToken", ctx, opts)
+	ret0, _ := ret[0].(*linodego.Token)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateToken indicates an expected call of CreateToken.
+func (mr *MockLinodeClientMockRecorder) CreateToken(ctx, opts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateToken", reflect.TypeOf((*MockLinodeClient)(nil).CreateToken), ctx, opts)
+}
+
 // CreateVPC mocks base method.
 func (m *MockLinodeClient) CreateVPC(ctx context.Context, opts linodego.VPCCreateOptions) (*linodego.VPC, error) {
 	m.ctrl.T.Helper()
@@ -115,6 +145,20 @@ func (mr *MockLinodeClientMockRecorder) CreateVPC(ctx, opts any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateVPC", reflect.TypeOf((*MockLinodeClient)(nil).CreateVPC), ctx, opts)
 }
 
+// DeleteFirewall mocks base method.
+func (m *MockLinodeClient) DeleteFirewall(ctx context.Context, firewallID int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteFirewall", ctx, firewallID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteFirewall indicates an expected call of DeleteFirewall.
+func (mr *MockLinodeClientMockRecorder) DeleteFirewall(ctx, firewallID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteFirewall", reflect.TypeOf((*MockLinodeClient)(nil).DeleteFirewall), ctx, firewallID)
+}
+
 // DeleteInstance mocks base method.
 func (m *MockLinodeClient) DeleteInstance(ctx context.Context, linodeID int) error {
 	m.ctrl.T.Helper()
@@ -143,6 +187,20 @@ func (mr *MockLinodeClientMockRecorder) DeleteNodeBalancer(ctx, nodebalancerID a
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteNodeBalancer", reflect.TypeOf((*MockLinodeClient)(nil).DeleteNodeBalancer), ctx, nodebalancerID)
 }
 
+// DeleteToken mocks base method.
+func (m *MockLinodeClient) DeleteToken(ctx context.Context, tokenID int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteToken", ctx, tokenID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteToken indicates an expected call of DeleteToken.
+func (mr *MockLinodeClientMockRecorder) DeleteToken(ctx, tokenID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteToken", reflect.TypeOf((*MockLinodeClient)(nil).DeleteToken), ctx, tokenID)
+}
+
 // DeleteVPC mocks base method.
 func (m *MockLinodeClient) DeleteVPC(ctx context.Context, vpcID int) error {
 	m.ctrl.T.Helper()
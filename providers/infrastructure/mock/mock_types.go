@@ -157,15 +157,15 @@ func (mr *MockProviderMockRecorder) PreDeploy(ctx, values any) *gomock.Call {
 }
 
 // UpdateManifests mocks base method.
-func (m *MockProvider) UpdateManifests(ctx context.Context, manifests []string, values *types.Values) error {
+func (m *MockProvider) UpdateManifests(ctx context.Context, manifest *yaml.Manifest, values *types.Values) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateManifests", ctx, manifests, values)
+	ret := m.ctrl.Call(m, "UpdateManifests", ctx, manifest, values)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateManifests indicates an expected call of UpdateManifests.
-func (mr *MockProviderMockRecorder) UpdateManifests(ctx, manifests, values any) *gomock.Call {
+func (mr *MockProviderMockRecorder) UpdateManifests(ctx, manifest, values any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateManifests", reflect.TypeOf((*MockProvider)(nil).UpdateManifests), ctx, manifests, values)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateManifests", reflect.TypeOf((*MockProvider)(nil).UpdateManifests), ctx, manifest, values)
 }
@@ -15,7 +15,7 @@ type Provider interface {
 	// GenerateAdditionalFiles generates any additional manifests that might be necessary for the Provider
 	GenerateAdditionalFiles(ctx context.Context, values *types.Values) ([]capiYaml.InitFile, error)
 	// UpdateManifests parses and updates any manifests needed to by the Provider
-	UpdateManifests(ctx context.Context, manifests []string, values *types.Values) error
+	UpdateManifests(ctx context.Context, manifest *capiYaml.Manifest, values *types.Values) error
 	// PreCmd does any validation an initial steps needed for doing any operations with a cluster in a Provider
 	PreCmd(ctx context.Context, values *types.Values) error
 	// PreDeploy takes in a common substitutions struct, does any setup needed to deploy a CAPI cluster and updates
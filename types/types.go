@@ -1,21 +1,54 @@
 package types
 
 import (
+	"fmt"
 	"io/fs"
 	"os"
+	"time"
 
 	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
 	"k8s.io/klog/v2"
+
+	capiYaml "capi-bootstrap/yaml"
+)
+
+const (
+	// OutputFormatCloudConfig renders the user data as a jinja templated cloud-config.
+	OutputFormatCloudConfig = "cloud-config"
+	// OutputFormatIgnition renders the user data as an Ignition config for Flatcar and Fedora CoreOS.
+	OutputFormatIgnition = "ignition"
+	// OutputFormatMIME renders the user data as multi-part MIME, with the init script and extra parts as separate parts.
+	OutputFormatMIME = "mime"
+)
+
+const (
+	// FileDeliveryRunCmd installs the files uploaded to the backend with generated runcmds.
+	FileDeliveryRunCmd = "runcmd"
+	// FileDeliverySource lets cloud-init fetch the files uploaded to the backend with write_files source, files that
+	// use instance data are kept inline so they are rendered with jinja.
+	FileDeliverySource = "source"
 )
 
+// OutputFormats are the supported formats of the user data.
+var OutputFormats = []string{OutputFormatCloudConfig, OutputFormatIgnition, OutputFormatMIME}
+
 // Values is the struct including information parsed by all providers.
 type Values struct {
 	// ClusterName is the name of the cluster that is being deployed
 	ClusterName string
 	// BootstrapToken will pass a bootstrap token to a controlPlane provider, otherwise one will be generated
 	BootstrapToken string
+	// CertificateAuthorities are existing CA key pairs by their purpose, which are passed to a controlPlane provider
+	// instead of generated ones
+	CertificateAuthorities map[string]CertificateAuthority `json:"-"`
 	// Namespace for resources to be installed into
 	Namespace string
+	// ControlPlaneName is the name of the control plane referenced by the Cluster
+	ControlPlaneName string
+	// InfrastructureName is the name of the infrastructure cluster referenced by the Cluster
+	InfrastructureName string
+	// Topology is set if the Cluster has a managed topology, the bootstrap objects are then labeled as owned by it
+	Topology bool
 	// The generated Kubeconfig for a bootstrapped cluster
 	Kubeconfig *v1.Config `json:"-"`
 	// K8sVersion is the version parsed from the providers.ControlPlane
@@ -32,11 +65,190 @@ type Values struct {
 	ManifestFS fs.FS `json:"-"`
 	// BootstrapManifestDir sets the directory on the bootstrap machine for all generated k8s manifests written to
 	BootstrapManifestDir string
-	// Manifests is the separated list of all manifests parsed from the ManifestFile
-	Manifests []string `json:"-"`
+	// Manifests are the objects parsed from the ManifestFile
+	Manifests *capiYaml.Manifest `json:"-"`
 	// TarWriteFiles specifies whether a single tar files should be constructed for all write_files in order to deliver
 	// reduce file sizes
 	TarWriteFiles bool
+	// DryRun stops providers from creating any resources, it is used to render the files for a cluster locally
+	DryRun bool `json:"-"`
+	// OutputFormat is the format of the user data for the bootstrap machine, one of OutputFormatCloudConfig,
+	// OutputFormatIgnition or OutputFormatMIME. It can be set with a flag or by an infrastructure provider in PreCmd.
+	OutputFormat string
+	// FileDelivery is how files uploaded to the backend are delivered, either FileDeliveryRunCmd or FileDeliverySource.
+	// If it is empty, FileDeliveryRunCmd is used, or FileDeliverySource with OutputFormatIgnition.
+	FileDelivery string
+	// CloudInit holds the user defined additions to the cloud-config of the bootstrap machine
+	CloudInit CloudInit
+	// Addons is the list of extra Helm charts and manifests installed on the bootstrap cluster
+	Addons []Addon
+	// Versions are the resolved versions of the components installed on the bootstrap cluster
+	Versions Versions
+}
+
+// The purposes of the CertificateAuthorities of a cluster.
+const (
+	CAServer        = "server-ca"
+	CAClient        = "client-ca"
+	CAEtcd          = "etcd-ca"
+	CARequestHeader = "request-header-ca"
+)
+
+// CAPurposes are the purposes of the CertificateAuthorities that can be supplied.
+var CAPurposes = []string{CAServer, CAClient, CAEtcd, CARequestHeader}
+
+// CertificateAuthority is an existing CA key pair.
+type CertificateAuthority struct {
+	// Cert is the PEM encoded CA certificate
+	Cert []byte
+	// Key is the PEM encoded private key of the CA certificate
+	Key []byte
+}
+
+// CertificateAuthoritySource is where an existing CA key pair is read from, either local files or a Secret.
+type CertificateAuthoritySource struct {
+	// CertFile is the local path of the PEM encoded CA certificate
+	CertFile string
+	// KeyFile is the local path of the PEM encoded private key
+	KeyFile string
+	// Secret is a <namespace>/<name> reference to a Secret with tls.crt and tls.key in the cluster of the current
+	// kubeconfig context
+	Secret string
+}
+
+// TokenSource is where an existing bootstrap token is read from, either a local file or a Secret.
+type TokenSource struct {
+	// File is the local path of a file containing the token
+	File string
+	// Secret is a <namespace>/<name> reference to a Secret with the token in its value key in the cluster of the current
+	// kubeconfig context
+	Secret string
+}
+
+// Versions are the versions of the components installed on the bootstrap cluster.
+type Versions struct {
+	// CertManager is the version of the cert-manager Helm chart
+	CertManager string
+	// CAPIOperator is the version of the cluster-api-operator Helm chart
+	CAPIOperator string
+	// CAPI is the version of the core cluster-api provider
+	CAPI string
+	// K3sProvider is the version of the k3s bootstrap and control plane providers
+	K3sProvider string
+	// CAPL is the version of the cluster-api-provider-linode infrastructure provider
+	CAPL string
+	// LinodeCCM is the version of the Linode cloud controller manager Helm chart
+	LinodeCCM string
+}
+
+// DefaultVersions are the component versions used when no version has been configured.
+var DefaultVersions = Versions{
+	CertManager:  "v1.15.3",
+	CAPIOperator: "v0.12.0",
+	CAPI:         "v1.8.1",
+	K3sProvider:  "v0.2.0",
+	CAPL:         "v0.6.0",
+	LinodeCCM:    "v0.4.10",
+}
+
+// SetDefaults sets every component that has no version configured to its version from DefaultVersions.
+func (v *Versions) SetDefaults() {
+	setDefault(&v.CertManager, DefaultVersions.CertManager)
+	setDefault(&v.CAPIOperator, DefaultVersions.CAPIOperator)
+	setDefault(&v.CAPI, DefaultVersions.CAPI)
+	setDefault(&v.K3sProvider, DefaultVersions.K3sProvider)
+	setDefault(&v.CAPL, DefaultVersions.CAPL)
+	setDefault(&v.LinodeCCM, DefaultVersions.LinodeCCM)
+}
+
+// Set sets the version of a component by its name as used on the command line.
+func (v *Versions) Set(component string, version string) error {
+	switch component {
+	case "cert-manager":
+		v.CertManager = version
+	case "capi-operator":
+		v.CAPIOperator = version
+	case "capi":
+		v.CAPI = version
+	case "k3s-provider":
+		v.K3sProvider = version
+	case "capl":
+		v.CAPL = version
+	case "linode-ccm":
+		v.LinodeCCM = version
+	default:
+		return fmt.Errorf("unknown component %q, options are: cert-manager, capi-operator, capi, k3s-provider, capl, linode-ccm", component)
+	}
+	return nil
+}
+
+func setDefault(version *string, defaultVersion string) {
+	if *version == "" {
+		*version = defaultVersion
+	}
+}
+
+// Addon is an extra Helm chart or manifest installed on the bootstrap cluster.
+type Addon struct {
+	// Name of the add-on, used to name the generated resources
+	Name string
+	// Namespace the add-on is installed into
+	Namespace string
+	// Chart is the name of the Helm chart to install, it can not be used together with Manifest
+	Chart string
+	// Repo is the Helm repository the Chart is installed from
+	Repo string
+	// Version pins the version of the Chart, and is available as [[[ .Addon.Version ]]] in templates
+	Version string
+	// Values are the Helm values for the Chart, templated with [[[ ]]] delimiters
+	Values string
+	// Manifest is the local path or URL of a manifest to install, templated with [[[ ]]] delimiters
+	Manifest string
+	// ClusterResourceSet additionally applies the add-on to the workload cluster through a ClusterResourceSet
+	ClusterResourceSet bool
+}
+
+// CloudInit is the set of user defined additions to the cloud-config generated for the bootstrap machine.
+type CloudInit struct {
+	// Debug enables the debug profile, which installs k9s and shell helpers on the bootstrap machine
+	Debug bool
+	// RunCmd is a list of extra commands to run after the cluster has been initialized
+	RunCmd []string
+	// WriteFiles is a list of extra files to write to the bootstrap machine
+	WriteFiles []capiYaml.InitFile
+	// CloudConfigParts are extra cloud-configs merged with the generated one, they require OutputFormatMIME
+	CloudConfigParts []string
+	// Boothooks are scripts run early on every boot of the bootstrap machine, they require OutputFormatMIME
+	Boothooks []string
+}
+
+// The kinds of credentials recorded by an AuditEntry.
+const (
+	CredentialCertificate = "certificate"
+	CredentialToken       = "token"
+)
+
+// AuditEntry records a credential that has been issued for a cluster.
+type AuditEntry struct {
+	// Time the credential has been issued at
+	Time time.Time
+	// IssuedBy is the local user that issued the credential
+	IssuedBy string
+	// Credential is the kind of the credential, either CredentialCertificate or CredentialToken
+	Credential string
+	// User is the user of a certificate or the <namespace>/<name> of a ServiceAccount
+	User string
+	// Groups are the groups of a certificate
+	Groups []string `json:",omitempty"`
+	// Serial is the serial number of a certificate
+	Serial string `json:",omitempty"`
+	// Expires is the time the credential expires at
+	Expires time.Time
+}
+
+// FileName is the name the entry is stored under by a backend, names sort by the time of the entry.
+func (e AuditEntry) FileName() string {
+	return fmt.Sprintf("%s-%s.yaml", e.Time.UTC().Format("20060102T150405.000000000Z"), e.Credential)
 }
 
 type ClusterInfo struct {
@@ -59,6 +271,13 @@ type Config struct {
 	CAPI           map[string]Env
 	ControlPlane   map[string]Env
 	Infrastructure map[string]Env
+	CloudInit      CloudInit
+	Addons         []Addon
+	Versions       Versions
+	// CertificateAuthorities are the sources of existing CA key pairs by their purpose
+	CertificateAuthorities map[string]CertificateAuthoritySource
+	// BootstrapToken is the source of an existing bootstrap token
+	BootstrapToken TokenSource
 }
 
 type Defaults struct {
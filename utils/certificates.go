@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"sigs.k8s.io/cluster-api/util/certs"
+)
+
+// TabWriteCertificates writes the subject and expiry of the PEM encoded certificates sorted by their name.
+func TabWriteCertificates(w io.Writer, certificates map[string][]byte, now time.Time) error {
+	if _, err := fmt.Fprintln(w, "Name\tSubject\tExpires\tRemaining"); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(certificates)) {
+		cert, err := certs.DecodeCertPEM(certificates[name])
+		if err != nil {
+			return fmt.Errorf("could not decode certificate %s: %s", name, err)
+		}
+		remaining := "expired"
+		if now.Before(cert.NotAfter) {
+			remaining = fmt.Sprintf("%dd", int(cert.NotAfter.Sub(now).Hours()/24))
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, cert.Subject, cert.NotAfter.UTC().Format(time.RFC3339), remaining); err != nil {
+			return err
+		}
+	}
+	return nil
+}
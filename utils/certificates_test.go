@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCert(t *testing.T, commonName string, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    notAfter.AddDate(-1, 0, 0),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestTabWriteCertificates(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	type test struct {
+		name    string
+		input   map[string][]byte
+		want    string
+		wantErr string
+	}
+	tests := []test{
+		{
+			name: "success",
+			input: map[string][]byte{
+				"server-ca":          newTestCert(t, "k3s-server-ca", now.AddDate(10, 0, 0)),
+				"test-cluster-admin": newTestCert(t, "kubernetes-admin", now.AddDate(0, 0, -1)),
+				"client-ca":          newTestCert(t, "k3s-client-ca", now.AddDate(0, 0, 30)),
+			},
+			want: `Name               Subject             Expires              Remaining
+client-ca          CN=k3s-client-ca    2024-07-01T00:00:00Z 30d
+server-ca          CN=k3s-server-ca    2034-06-01T00:00:00Z 3652d
+test-cluster-admin CN=kubernetes-admin 2024-05-31T00:00:00Z expired
+`,
+		},
+		{
+			name:    "err invalid certificate",
+			input:   map[string][]byte{"server-ca": []byte("invalid")},
+			wantErr: "could not decode certificate server-ca: unable to decode PEM data",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			buf := &bytes.Buffer{}
+			w := tabwriter.NewWriter(buf, 0, 8, 1, ' ', 0)
+			err := TabWriteCertificates(w, tc.input, now)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, w.Flush())
+			assert.Equal(t, tc.want, buf.String())
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+)
+
+// ClusterResource is the resource of the Cluster API clusters.
+var ClusterResource = schema.GroupVersionResource{Group: "cluster.x-k8s.io", Version: "v1beta1", Resource: "clusters"}
+
+// WaitForCluster polls the bootstrap cluster until the Cluster of the workload cluster exists, which means the bootstrap
+// machine has downloaded its files and applied the CAPI manifests. Errors while the API server is not reachable yet
+// are retried until ctx is done.
+func WaitForCluster(ctx context.Context, client dynamic.Interface, namespace string, name string, interval time.Duration) error {
+	err := wait.PollUntilContextCancel(ctx, interval, true, func(ctx context.Context) (bool, error) {
+		_, err := client.Resource(ClusterResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			return true, nil
+		case apierrors.IsNotFound(err):
+			klog.V(4).Infof("waiting for cluster %s/%s to be created", namespace, name)
+		default:
+			klog.V(4).Infof("waiting for the bootstrap cluster API server: %s", err)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("cluster %s/%s was not created on the bootstrap cluster: %s", namespace, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestWaitForCluster(t *testing.T) {
+	t.Parallel()
+	cluster := &unstructured.Unstructured{}
+	cluster.SetAPIVersion("cluster.x-k8s.io/v1beta1")
+	cluster.SetKind("Cluster")
+	cluster.SetNamespace("default")
+	cluster.SetName("test-cluster")
+
+	type test struct {
+		name    string
+		objects []runtime.Object
+		wantErr string
+	}
+	tests := []test{
+		{name: "success", objects: []runtime.Object{cluster}},
+		{name: "err timeout", wantErr: "cluster default/test-cluster was not created on the bootstrap cluster: context deadline exceeded"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			client := dynamicFake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{ClusterResource: "ClusterList"}, tc.objects...)
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err := WaitForCluster(ctx, client, "default", "test-cluster", 10*time.Millisecond)
+			if tc.wantErr != "" {
+				assert.EqualErrorf(t, err, tc.wantErr, "expected error message: %s", tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
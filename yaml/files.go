@@ -2,12 +2,16 @@ package yaml
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/fs"
 	"path"
 	"strings"
 	"text/template"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	capi "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/yaml"
 )
@@ -26,54 +30,172 @@ func ConstructFile(filePath string, localPath string, filesystem fs.FS, values a
 }
 
 func templateManifest(filesystem fs.FS, localPath string, templateValues any, escapeFile bool) ([]byte, error) {
-	var err error
-	tmpl := template.New(path.Base(localPath))
-	tmpl.Delims("[[[", "]]]")
 	rawYaml, err := fs.ReadFile(filesystem, localPath)
 	if err != nil {
 		return nil, fmt.Errorf("error reading file: %s", err)
 	}
 	escapedYaml := string(rawYaml)
 	if escapeFile {
-		// convert '{{ }}' to "{{ }}" then escape template
-		escapedYaml = strings.ReplaceAll(string(rawYaml), "'{{", "\"{{")
-		escapedYaml = strings.ReplaceAll(escapedYaml, "}}'", "}}\"")
-		escapedYaml = strings.ReplaceAll(escapedYaml, "{{", "{{ '{{")
-		escapedYaml = strings.ReplaceAll(escapedYaml, "}}", "}}' }}")
+		escapedYaml = EscapeJinja(escapedYaml)
 	}
-	tmpl, err = tmpl.Parse(escapedYaml)
+	return executeTemplate(localPath, escapedYaml, templateValues)
+}
+
+// EscapeJinja escapes the '{{ }}' in a yaml manifest, so cloud-init renders them as they are instead of evaluating them.
+func EscapeJinja(content string) string {
+	// convert '{{ }}' to "{{ }}" then escape template
+	content = strings.ReplaceAll(content, "'{{", "\"{{")
+	content = strings.ReplaceAll(content, "}}'", "}}\"")
+	content = strings.ReplaceAll(content, "{{", "{{ '{{")
+	return strings.ReplaceAll(content, "}}", "}}' }}")
+}
+
+// UnescapeJinja reverts EscapeJinja for content that isn't rendered by cloud-init, the quotes of '{{ }}' are not
+// restored.
+func UnescapeJinja(content string) string {
+	content = strings.ReplaceAll(content, "{{ '{{", "{{")
+	return strings.ReplaceAll(content, "}}' }}", "}}")
+}
+
+// TemplateString executes content as a template with [[[ ]]] delimiters, name is only used for error messages.
+func TemplateString(name string, content string, templateValues any) (string, error) {
+	templated, err := executeTemplate(name, content, templateValues)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse template %s, %s", localPath, err)
+		return "", err
+	}
+	return string(templated), nil
+}
+
+func executeTemplate(name string, content string, templateValues any) ([]byte, error) {
+	tmpl := template.New(path.Base(name))
+	tmpl.Delims("[[[", "]]]")
+	tmpl, err := tmpl.Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s, %s", name, err)
 	}
 
 	var b []byte
 	buf := bytes.NewBuffer(b)
 	err = tmpl.Execute(buf, templateValues)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute template %s, %s", localPath, err)
+		return nil, fmt.Errorf("failed to execute template %s, %s", name, err)
 	}
 	return buf.Bytes(), nil
 }
 
-func UpdateCluster(manifests []string) error {
-	var cluster capi.Cluster
-	var clusterIndex int
-	for i, manifest := range manifests {
-		err := yaml.Unmarshal([]byte(manifest), &cluster)
-		if err != nil {
-			return err
+// PivotControlPlaneName is the name the Cluster's controlPlaneRef points at until the bootstrap machine is adopted, so
+// the control plane doesn't scale up before then. The init script of the control plane provider restores the real
+// name, which is passed in types.Values.ControlPlaneName.
+const PivotControlPlaneName = "fake-control-plane"
+
+// UpdateCluster points the controlPlaneRef of the Cluster in the manifest at PivotControlPlaneName and labels it with
+// its name, which the ClusterResourceSets of add-ons select it by.
+func UpdateCluster(manifest *Manifest) error {
+	cluster := manifest.Get(clusterGVK, "")
+	if cluster == nil {
+		return errors.New("cluster not found")
+	}
+	if _, found, _ := unstructured.NestedMap(cluster.Object, "spec", "controlPlaneRef"); !found {
+		return fmt.Errorf("cluster %s has no controlPlaneRef", cluster.GetName())
+	}
+	labels := cluster.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[capi.ClusterNameLabel] = cluster.GetName()
+	cluster.SetLabels(labels)
+	return unstructured.SetNestedField(cluster.Object, PivotControlPlaneName, "spec", "controlPlaneRef", "name")
+}
+
+// ClusterOverrides are values from the command line that replace the ones in the manifests, unset values are kept.
+type ClusterOverrides struct {
+	// KubernetesVersion is set on the control plane and the machine templates of the MachineDeployments
+	KubernetesVersion string
+	// ControlPlaneReplicas is set on the control plane
+	ControlPlaneReplicas *int64
+	// WorkerReplicas is set on the MachineDeployments
+	WorkerReplicas *int64
+}
+
+// ApplyClusterOverrides patches the control plane referenced by the Cluster and the MachineDeployments in the
+// manifests with the overrides. All control plane providers keep the version and replicas in spec.version and
+// spec.replicas. Clusters with a managed topology are patched in their topology instead.
+func ApplyClusterOverrides(manifest *Manifest, overrides ClusterOverrides) error {
+	if overrides.KubernetesVersion == "" && overrides.ControlPlaneReplicas == nil && overrides.WorkerReplicas == nil {
+		return nil
+	}
+	cluster := GetClusterDef(manifest)
+	if cluster != nil && cluster.Spec.Topology != nil && cluster.Spec.ControlPlaneRef == nil {
+		return applyTopologyOverrides(manifest.Get(clusterGVK, ""), overrides)
+	}
+	if cluster == nil || cluster.Spec.ControlPlaneRef == nil {
+		return errors.New("cluster with a controlPlaneRef not found")
+	}
+	controlPlaneRef := cluster.Spec.ControlPlaneRef
+	controlPlaneFound := false
+	for _, object := range manifest.Objects() {
+		gvk := object.GroupVersionKind()
+		fields := map[string]any{}
+		switch {
+		case gvk.Group == controlPlaneGroup && gvk.Kind == controlPlaneRef.Kind && object.GetName() == controlPlaneRef.Name:
+			controlPlaneFound = true
+			if overrides.KubernetesVersion != "" {
+				fields["spec.version"] = overrides.KubernetesVersion
+			}
+			if overrides.ControlPlaneReplicas != nil {
+				fields["spec.replicas"] = *overrides.ControlPlaneReplicas
+			}
+		case gvk.Group == capi.GroupVersion.Group && gvk.Kind == "MachineDeployment":
+			if overrides.KubernetesVersion != "" {
+				fields["spec.template.spec.version"] = overrides.KubernetesVersion
+			}
+			if overrides.WorkerReplicas != nil {
+				fields["spec.replicas"] = *overrides.WorkerReplicas
+			}
+		}
+		for field, value := range fields {
+			if err := unstructured.SetNestedField(object.Object, value, strings.Split(field, ".")...); err != nil {
+				return fmt.Errorf("could not update %s %s: %s", gvk.Kind, object.GetName(), err)
+			}
+		}
+	}
+	if !controlPlaneFound && (overrides.KubernetesVersion != "" || overrides.ControlPlaneReplicas != nil) {
+		return fmt.Errorf("%s %s referenced by the cluster not found", controlPlaneRef.Kind, controlPlaneRef.Name)
+	}
+	return nil
+}
+
+// applyTopologyOverrides patches the topology of cluster, the replicas of workers are set on all its
+// MachineDeployments.
+func applyTopologyOverrides(cluster *unstructured.Unstructured, overrides ClusterOverrides) error {
+	if overrides.KubernetesVersion != "" {
+		if err := unstructured.SetNestedField(cluster.Object, overrides.KubernetesVersion, "spec", "topology", "version"); err != nil {
+			return fmt.Errorf("could not update cluster %s: %s", cluster.GetName(), err)
 		}
-		if cluster.Kind == "Cluster" {
-			cluster.Spec.ControlPlaneRef.Name = "fake-control-plane"
-			clusterIndex = i
-			break
+	}
+	if overrides.ControlPlaneReplicas != nil {
+		if err := unstructured.SetNestedField(cluster.Object, *overrides.ControlPlaneReplicas, "spec", "topology", "controlPlane", "replicas"); err != nil {
+			return fmt.Errorf("could not update cluster %s: %s", cluster.GetName(), err)
 		}
 	}
-	clusterString, err := yaml.Marshal(cluster)
+	if overrides.WorkerReplicas == nil {
+		return nil
+	}
+	machineDeployments, found, err := unstructured.NestedSlice(cluster.Object, "spec", "topology", "workers", "machineDeployments")
 	if err != nil {
-		return err
+		return fmt.Errorf("could not read the MachineDeployments of cluster %s: %s", cluster.GetName(), err)
+	}
+	if !found {
+		return nil
+	}
+	for _, machineDeployment := range machineDeployments {
+		if machineDeployment, ok := machineDeployment.(map[string]any); ok {
+			machineDeployment["replicas"] = *overrides.WorkerReplicas
+		}
+	}
+	if err := unstructured.SetNestedSlice(cluster.Object, machineDeployments, "spec", "topology", "workers", "machineDeployments"); err != nil {
+		return fmt.Errorf("could not update cluster %s: %s", cluster.GetName(), err)
 	}
-	manifests[clusterIndex] = string(clusterString)
 	return nil
 }
 
@@ -82,13 +204,67 @@ func Marshal(obj interface{}) ([]byte, error) {
 	return yaml.Marshal(obj)
 }
 
-func GetClusterDef(manifests []string) *capi.Cluster {
+// clusterGVK is the kind of the CAPI Cluster, all versions of it are looked up.
+var clusterGVK = capi.GroupVersion.WithKind("Cluster").GroupKind().WithVersion("")
+
+// GetClusterDef returns the first Cluster in the manifest, or nil if there is none or it can't be decoded.
+func GetClusterDef(manifest *Manifest) *capi.Cluster {
+	object := manifest.Get(clusterGVK, "")
+	if object == nil {
+		return nil
+	}
 	var cluster capi.Cluster
-	for _, manifest := range manifests {
-		_ = yaml.Unmarshal([]byte(manifest), &cluster)
-		if cluster.Kind == "Cluster" && cluster.APIVersion == "cluster.x-k8s.io/v1beta1" {
-			return &cluster
+	if err := DecodeObject(object, &cluster); err != nil {
+		return nil
+	}
+	return &cluster
+}
+
+// controlPlaneGroup is the API group of all control plane providers.
+const controlPlaneGroup = "controlplane.cluster.x-k8s.io"
+
+// machineTemplateRefPaths are the fields control planes reference their infrastructure machine template in.
+// KThreesControlPlane, KubeadmControlPlane and RKE2ControlPlane use spec.machineTemplate.infrastructureRef, older
+// RKE2ControlPlane versions use spec.infrastructureRef.
+var machineTemplateRefPaths = [][]string{
+	{"spec", "machineTemplate", "infrastructureRef"},
+	{"spec", "infrastructureRef"},
+}
+
+// GetControlPlaneMachineTemplateRef returns the reference to the infrastructure machine template of the control plane
+// referenced by the Cluster in the manifests, its namespace defaults to the namespace of the control plane.
+func GetControlPlaneMachineTemplateRef(manifest *Manifest) (*corev1.ObjectReference, error) {
+	cluster := GetClusterDef(manifest)
+	if cluster == nil {
+		return nil, errors.New("cluster not found")
+	}
+	controlPlaneRef := cluster.Spec.ControlPlaneRef
+	if controlPlaneRef == nil {
+		return nil, fmt.Errorf("cluster %s has no controlPlaneRef", cluster.Name)
+	}
+
+	object := manifest.Get(schema.GroupVersionKind{Group: controlPlaneGroup, Kind: controlPlaneRef.Kind}, controlPlaneRef.Name)
+	if object == nil {
+		return nil, fmt.Errorf("%s %s referenced by the cluster not found", controlPlaneRef.Kind, controlPlaneRef.Name)
+	}
+	for _, fields := range machineTemplateRefPaths {
+		ref, found, err := unstructured.NestedStringMap(object.Object, fields...)
+		if err != nil || !found {
+			continue
 		}
+		if ref["kind"] == "" || ref["name"] == "" {
+			return nil, fmt.Errorf("%s %s has an incomplete %s", object.GetKind(), object.GetName(), strings.Join(fields, "."))
+		}
+		namespace := ref["namespace"]
+		if namespace == "" {
+			namespace = object.GetNamespace()
+		}
+		return &corev1.ObjectReference{
+			APIVersion: ref["apiVersion"],
+			Kind:       ref["kind"],
+			Name:       ref["name"],
+			Namespace:  namespace,
+		}, nil
 	}
-	return nil
+	return nil, fmt.Errorf("%s %s has no machine template infrastructureRef", object.GetKind(), object.GetName())
 }
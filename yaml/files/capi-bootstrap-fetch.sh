@@ -0,0 +1,36 @@
+#!/bin/sh
+# capi-bootstrap-fetch <sha256> <output> <curl arguments...>
+# Downloads a file with curl and verifies its SHA-256, retrying with backoff until it matches. Exits with 1 and
+# removes the output if the file could not be fetched, so a truncated or tampered file is never used.
+set -u
+
+expected="$1"
+output="$2"
+shift 2
+
+attempts="${CAPI_BOOTSTRAP_FETCH_ATTEMPTS:-8}"
+delay="${CAPI_BOOTSTRAP_FETCH_DELAY:-2}"
+attempt=1
+while [ "$attempt" -le "$attempts" ]; do
+  if curl -sSfL -o "$output" "$@"; then
+    actual="$(sha256sum "$output" | cut -d ' ' -f 1)"
+    if [ "$actual" = "$expected" ]; then
+      exit 0
+    fi
+    echo "capi-bootstrap-fetch: checksum mismatch for $output, expected $expected but got $actual (attempt $attempt/$attempts)" >&2
+  else
+    echo "capi-bootstrap-fetch: failed to download $output (attempt $attempt/$attempts)" >&2
+  fi
+  attempt=$((attempt + 1))
+  if [ "$attempt" -le "$attempts" ]; then
+    sleep "$delay"
+    delay=$((delay * 2))
+    if [ "$delay" -gt 60 ]; then
+      delay=60
+    fi
+  fi
+done
+
+echo "capi-bootstrap-fetch: giving up on $output after $attempts attempts" >&2
+rm -f "$output"
+exit 1
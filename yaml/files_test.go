@@ -4,16 +4,22 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
 	v1meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
 	capi "sigs.k8s.io/cluster-api/api/v1beta1"
-
-	"capi-bootstrap/types"
 )
 
+// templateValues mirrors the fields of types.Values used by the test templates.
+type templateValues struct {
+	ClusterName string
+}
+
 func TestConstructFile(t *testing.T) {
 	notFound := "error reading file: open wrong-tmpfile: no such file or directory"
 	if runtime.GOOS == "windows" {
@@ -21,7 +27,7 @@ func TestConstructFile(t *testing.T) {
 	}
 	type test struct {
 		name       string
-		input      types.Values
+		input      templateValues
 		escapeFile bool
 		localPath  string
 		manifest   []byte
@@ -31,7 +37,7 @@ func TestConstructFile(t *testing.T) {
 	tests := []test{
 		{
 			name:      "success",
-			input:     types.Values{ClusterName: "test-cluster"},
+			input:     templateValues{ClusterName: "test-cluster"},
 			localPath: "tmpfile",
 			manifest: []byte(`---
 apiVersion: infrastructure.cluster.x-k8s.io/v1alpha1
@@ -59,7 +65,7 @@ spec:
 		},
 		{
 			name:       "success escaped file",
-			input:      types.Values{ClusterName: "test-cluster"},
+			input:      templateValues{ClusterName: "test-cluster"},
 			localPath:  "tmpfile",
 			escapeFile: true,
 			manifest: []byte(`---
@@ -88,13 +94,13 @@ spec:
 		},
 		{
 			name:      "err invalid file",
-			input:     types.Values{ClusterName: "test-cluster"},
+			input:     templateValues{ClusterName: "test-cluster"},
 			localPath: "wrong-tmpfile",
 			wantErr:   notFound,
 		},
 		{
 			name:      "err invalid parsed template",
-			input:     types.Values{ClusterName: "test-cluster"},
+			input:     templateValues{ClusterName: "test-cluster"},
 			localPath: "tmpfile",
 			manifest: []byte(`---
 apiVersion: infrastructure.cluster.x-k8s.io/v1alpha1
@@ -133,11 +139,12 @@ spec:
 
 func TestUpdateCluster(t *testing.T) {
 	type test struct {
-		name  string
-		input []string
-		want  []string
+		name    string
+		input   string
+		want    string
+		wantErr string
 	}
-	manifests := []string{`---
+	machineDeployment := `---
 apiVersion: cluster.x-k8s.io/v1beta1
 kind: MachineDeployment
 metadata:
@@ -150,8 +157,12 @@ spec:
     matchLabels: null
   template:
     spec:
-      clusterName: test-cluster`,
-		`---
+      clusterName: test-cluster
+`
+	tests := []test{
+		{
+			name: "success",
+			input: machineDeployment + `---
 apiVersion: cluster.x-k8s.io/v1beta1
 kind: Cluster
 metadata:
@@ -170,25 +181,14 @@ spec:
     apiVersion: infrastructure.cluster.x-k8s.io/v1alpha1
     kind: FakeInfrastructureCluster
     name: test-cluster
-`}
-	expectedManifests := []string{`---
+  unknownField: kept
+`,
+			want: machineDeployment + `---
 apiVersion: cluster.x-k8s.io/v1beta1
-kind: MachineDeployment
-metadata:
-  name: test-cluster-md-0
-  namespace: default
-spec:
-  clusterName: test-cluster
-  replicas: 3
-  selector:
-    matchLabels: null
-  template:
-    spec:
-      clusterName: test-cluster`,
-		`apiVersion: cluster.x-k8s.io/v1beta1
 kind: Cluster
 metadata:
-  creationTimestamp: null
+  labels:
+    cluster.x-k8s.io/cluster-name: test-cluster
   name: test-cluster
   namespace: default
 spec:
@@ -196,9 +196,6 @@ spec:
     pods:
       cidrBlocks:
       - 10.192.0.0/10
-  controlPlaneEndpoint:
-    host: ""
-    port: 0
   controlPlaneRef:
     apiVersion: controlplane.cluster.x-k8s.io/v1beta1
     kind: FakeControlPlane
@@ -207,26 +204,39 @@ spec:
     apiVersion: infrastructure.cluster.x-k8s.io/v1alpha1
     kind: FakeInfrastructureCluster
     name: test-cluster
-status:
-  controlPlaneReady: false
-  infrastructureReady: false
-`}
-
-	tests := []test{
+  unknownField: kept
+`,
+		},
 		{
-			name:  "success",
-			input: manifests,
-			want:  expectedManifests,
+			name:    "err no cluster",
+			input:   machineDeployment,
+			wantErr: "cluster not found",
+		},
+		{
+			name: "err no controlPlaneRef",
+			input: `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+`,
+			wantErr: "cluster test-cluster has no controlPlaneRef",
 		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			err := UpdateCluster(manifests)
-			assert.NoError(t, err)
-			for i, actualFile := range tc.input {
-				assert.Equal(t, tc.want[i], actualFile, "expected file: %s", tc.want[i])
+			manifest, err := ParseManifest(tc.input)
+			require.NoError(t, err)
+			err = UpdateCluster(manifest)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
 			}
+			assert.NoError(t, err)
+			actual, err := manifest.Marshal()
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, string(actual))
 		})
 	}
 }
@@ -313,7 +323,9 @@ spec:
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			cluster := GetClusterDef(tc.input)
+			manifest, err := ParseManifest(strings.Join(tc.input, "\n"))
+			require.NoError(t, err)
+			cluster := GetClusterDef(manifest)
 			if tc.want == nil {
 				assert.Nil(t, cluster)
 			} else {
@@ -328,3 +340,306 @@ spec:
 		})
 	}
 }
+
+func TestGetControlPlaneMachineTemplateRef(t *testing.T) {
+	cluster := `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+  namespace: default
+spec:
+  controlPlaneRef:
+    apiVersion: controlplane.cluster.x-k8s.io/v1beta1
+    kind: RKE2ControlPlane
+    name: test-cluster-control-plane`
+	type test struct {
+		name    string
+		input   []string
+		want    *v1.ObjectReference
+		wantErr string
+	}
+	tests := []test{
+		{
+			name: "success machineTemplate",
+			input: []string{cluster, `---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta1
+kind: RKE2ControlPlane
+metadata:
+  name: test-cluster-control-plane
+  namespace: default
+spec:
+  machineTemplate:
+    infrastructureRef:
+      apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+      kind: LinodeMachineTemplate
+      name: test-cluster-control-plane`},
+			want: &v1.ObjectReference{
+				APIVersion: "infrastructure.cluster.x-k8s.io/v1alpha2",
+				Kind:       "LinodeMachineTemplate",
+				Name:       "test-cluster-control-plane",
+				Namespace:  "default",
+			},
+		},
+		{
+			name: "success infrastructureRef",
+			input: []string{cluster, `---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta1
+kind: RKE2ControlPlane
+metadata:
+  name: test-cluster-control-plane
+  namespace: default
+spec:
+  infrastructureRef:
+    apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+    kind: LinodeMachineTemplate
+    name: test-cluster-control-plane
+    namespace: other`},
+			want: &v1.ObjectReference{
+				APIVersion: "infrastructure.cluster.x-k8s.io/v1alpha2",
+				Kind:       "LinodeMachineTemplate",
+				Name:       "test-cluster-control-plane",
+				Namespace:  "other",
+			},
+		},
+		{
+			name:    "err no cluster",
+			input:   []string{},
+			wantErr: "cluster not found",
+		},
+		{
+			name: "err no controlPlaneRef",
+			input: []string{`---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster`},
+			wantErr: "cluster test-cluster has no controlPlaneRef",
+		},
+		{
+			name:    "err control plane not found",
+			input:   []string{cluster},
+			wantErr: "RKE2ControlPlane test-cluster-control-plane referenced by the cluster not found",
+		},
+		{
+			name: "err no infrastructureRef",
+			input: []string{cluster, `---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta1
+kind: RKE2ControlPlane
+metadata:
+  name: test-cluster-control-plane
+spec:
+  replicas: 3`},
+			wantErr: "RKE2ControlPlane test-cluster-control-plane has no machine template infrastructureRef",
+		},
+		{
+			name: "err incomplete infrastructureRef",
+			input: []string{cluster, `---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta1
+kind: RKE2ControlPlane
+metadata:
+  name: test-cluster-control-plane
+spec:
+  machineTemplate:
+    infrastructureRef:
+      kind: LinodeMachineTemplate`},
+			wantErr: "RKE2ControlPlane test-cluster-control-plane has an incomplete spec.machineTemplate.infrastructureRef",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			manifest, err := ParseManifest(strings.Join(tc.input, "\n"))
+			require.NoError(t, err)
+			ref, err := GetControlPlaneMachineTemplateRef(manifest)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.want, ref)
+			}
+		})
+	}
+}
+
+func TestApplyClusterOverrides(t *testing.T) {
+	manifests := []string{`---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+spec:
+  controlPlaneRef:
+    apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+    kind: KThreesControlPlane
+    name: test-cluster-control-plane
+`, `---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+kind: KThreesControlPlane
+metadata:
+  name: test-cluster-control-plane
+spec:
+  replicas: 3
+  version: v1.29.4+k3s1
+`, `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: MachineDeployment
+metadata:
+  name: test-cluster-md-0
+spec:
+  clusterName: test-cluster
+  replicas: 3
+  template:
+    spec:
+      clusterName: test-cluster
+      version: v1.29.4+k3s1
+`}
+	type test struct {
+		name      string
+		input     []string
+		overrides ClusterOverrides
+		want      []string
+		wantErr   string
+	}
+	tests := []test{
+		{
+			name:  "success",
+			input: manifests,
+			overrides: ClusterOverrides{
+				KubernetesVersion:    "v1.30.4+k3s1",
+				ControlPlaneReplicas: ptr.To[int64](1),
+				WorkerReplicas:       ptr.To[int64](0),
+			},
+			want: []string{manifests[0], `---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+kind: KThreesControlPlane
+metadata:
+  name: test-cluster-control-plane
+spec:
+  replicas: 1
+  version: v1.30.4+k3s1
+`, `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: MachineDeployment
+metadata:
+  name: test-cluster-md-0
+spec:
+  clusterName: test-cluster
+  replicas: 0
+  template:
+    spec:
+      clusterName: test-cluster
+      version: v1.30.4+k3s1
+`},
+		},
+		{
+			name:      "success worker replicas only",
+			input:     manifests,
+			overrides: ClusterOverrides{WorkerReplicas: ptr.To[int64](5)},
+			want: []string{manifests[0], manifests[1], `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: MachineDeployment
+metadata:
+  name: test-cluster-md-0
+spec:
+  clusterName: test-cluster
+  replicas: 5
+  template:
+    spec:
+      clusterName: test-cluster
+      version: v1.29.4+k3s1
+`},
+		},
+		{
+			name: "success topology",
+			input: []string{`---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+spec:
+  topology:
+    class: linode-k3s
+    version: v1.29.4+k3s1
+    workers:
+      machineDeployments:
+      - class: default-worker
+        name: md-0
+`},
+			overrides: ClusterOverrides{
+				KubernetesVersion:    "v1.30.4+k3s1",
+				ControlPlaneReplicas: ptr.To[int64](1),
+				WorkerReplicas:       ptr.To[int64](0),
+			},
+			want: []string{`---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+spec:
+  topology:
+    class: linode-k3s
+    controlPlane:
+      replicas: 1
+    version: v1.30.4+k3s1
+    workers:
+      machineDeployments:
+      - class: default-worker
+        name: md-0
+        replicas: 0
+`},
+		},
+		{
+			name:  "success no overrides",
+			input: manifests[1:],
+			want:  manifests[1:],
+		},
+		{
+			name:      "err control plane not found",
+			input:     []string{manifests[0], manifests[2]},
+			overrides: ClusterOverrides{ControlPlaneReplicas: ptr.To[int64](1)},
+			wantErr:   "KThreesControlPlane test-cluster-control-plane referenced by the cluster not found",
+		},
+		{
+			name:      "err cluster not found",
+			input:     manifests[1:],
+			overrides: ClusterOverrides{KubernetesVersion: "v1.30.4+k3s1"},
+			wantErr:   "cluster with a controlPlaneRef not found",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			manifest, err := ParseManifest(strings.Join(tc.input, ""))
+			require.NoError(t, err)
+			err = ApplyClusterOverrides(manifest, tc.overrides)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				actual, err := manifest.Marshal()
+				require.NoError(t, err)
+				assert.Equal(t, strings.Join(tc.want, ""), string(actual))
+			}
+		})
+	}
+}
+
+func TestUnescapeJinja(t *testing.T) {
+	type test struct {
+		name  string
+		input string
+		want  string
+	}
+	tests := []test{
+		{name: "success escaped", input: EscapeJinja("name: {{ .Release.Name }}"), want: "name: {{ .Release.Name }}"},
+		{name: "success quotes", input: EscapeJinja("name: '{{ .Release.Name }}'"), want: "name: \"{{ .Release.Name }}\""},
+		{name: "success unescaped", input: "region: {{ ds.meta_data.region }}", want: "region: {{ ds.meta_data.region }}"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, UnescapeJinja(tc.input))
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package yaml
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FetchScriptPath is where the script downloading and verifying files is written on the machine.
+const FetchScriptPath = "/usr/local/bin/capi-bootstrap-fetch"
+
+//go:embed files/capi-bootstrap-fetch.sh
+var fetchScript string
+
+// DefaultPermissions are the permissions cloud-init uses for write_files without permissions.
+const DefaultPermissions fs.FileMode = 0o644
+
+// FileMode returns the parsed octal Permissions of the file, or DefaultPermissions if they are not set.
+func (f InitFile) FileMode() (fs.FileMode, error) {
+	if f.Permissions == "" {
+		return DefaultPermissions, nil
+	}
+	mode, err := strconv.ParseUint(f.Permissions, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid permissions %q for file %s: %s", f.Permissions, f.Path, err)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// IsBase64 returns whether the Content of the file is base64 encoded.
+func (f InitFile) IsBase64() bool {
+	switch f.Encoding {
+	case "b64", "base64", "gz+b64", "gzip+b64", "gz+base64", "gzip+base64":
+		return true
+	}
+	return false
+}
+
+// IsGzip returns whether the Content of the file is gzip compressed.
+func (f InitFile) IsGzip() bool {
+	switch f.Encoding {
+	case "gz", "gzip", "gz+b64", "gzip+b64", "gz+base64", "gzip+base64":
+		return true
+	}
+	return false
+}
+
+// DecodedContent returns the Content of the file as it will be written to disk, decoded according to its Encoding.
+func (f InitFile) DecodedContent() ([]byte, error) {
+	content := []byte(f.Content)
+	if f.IsBase64() {
+		decoded, err := base64.StdEncoding.DecodeString(f.Content)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode base64 content of file %s: %s", f.Path, err)
+		}
+		content = decoded
+	}
+	if f.IsGzip() {
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress content of file %s: %s", f.Path, err)
+		}
+		defer reader.Close()
+		content, err = io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress content of file %s: %s", f.Path, err)
+		}
+	}
+	return content, nil
+}
+
+// UserGroup returns the user and group that own the file, cloud-init leaves them as root if they are not set.
+func (f InitFile) UserGroup() (string, string) {
+	user, group, _ := strings.Cut(f.Owner, ":")
+	if user == "" {
+		user = "root"
+	}
+	if group == "" {
+		group = "root"
+	}
+	return user, group
+}
+
+// InstallCmd returns the shell command downloading the file from url and installing it. The download is verified
+// against the SHA-256 of the Content of the file and retried by the fetch script, the command exits the script it
+// runs in if the file can't be fetched. The file is created with its permissions and owner before any content is
+// written to it, encoded content is decoded and unencoded content is rendered with cloud-init query like the jinja
+// templated write_files it replaces.
+func (f InitFile) InstallCmd(url string, headers map[string]string) (string, error) {
+	mode, err := f.FileMode()
+	if err != nil {
+		return "", err
+	}
+	user, group := f.UserGroup()
+	filePath := ShellQuote(f.Path)
+
+	var decodeCmd string
+	switch {
+	case f.IsBase64() && f.IsGzip():
+		decodeCmd = `base64 -d "$tmp" | gunzip`
+	case f.IsBase64():
+		decodeCmd = `base64 -d "$tmp"`
+	case f.IsGzip():
+		decodeCmd = `gunzip -c "$tmp"`
+	default:
+		decodeCmd = `xargs -0 cloud-init query -f < "$tmp"`
+	}
+
+	var installCmd string
+	if f.Append {
+		installCmd = fmt.Sprintf("mkdir -p \"$(dirname %[1]s)\" && %[2]s >> %[1]s && chmod %04[3]o %[1]s && chown %[4]s:%[5]s %[1]s",
+			filePath, decodeCmd, mode.Perm(), ShellQuote(user), ShellQuote(group))
+	} else {
+		installCmd = fmt.Sprintf("install -D -m %04[3]o -o %[4]s -g %[5]s /dev/null %[1]s && %[2]s > %[1]s",
+			filePath, decodeCmd, mode.Perm(), ShellQuote(user), ShellQuote(group))
+	}
+	return fmt.Sprintf(`tmp="$(mktemp)" && %s && %s && rm -f "$tmp" || exit 1`, fetchCmd(f.SHA256(), `"$tmp"`, url, headers), installCmd), nil
+}
+
+// VerifySourceCmd returns the shell command verifying a file that cloud-init fetched from its Source against sha256,
+// it is fetched again if it doesn't match and the command exits the script it runs in if that fails.
+func (f InitFile) VerifySourceCmd(sha256 string) string {
+	return fmt.Sprintf("printf '%%s  %%s\\n' %[1]s %[2]s | sha256sum -c --status - || %[3]s || exit 1",
+		sha256, ShellQuote(f.Path), fetchCmd(sha256, ShellQuote(f.Path), f.Source.URI, f.Source.Headers))
+}
+
+// SHA256 returns the hex encoded SHA-256 of the Content of the file, which is what is uploaded to a backend.
+func (f InitFile) SHA256() string {
+	sum := sha256.Sum256([]byte(f.Content))
+	return hex.EncodeToString(sum[:])
+}
+
+// FetchScript returns the file for the script used by InstallCmd and VerifySourceCmd to download and verify files.
+func FetchScript() InitFile {
+	return InitFile{
+		Path:        FetchScriptPath,
+		Content:     fetchScript,
+		Permissions: "0755",
+	}
+}
+
+// fetchCmd returns the command downloading url to output with the fetch script, output has to be quoted already.
+func fetchCmd(sha256 string, output string, url string, headers map[string]string) string {
+	args := []string{FetchScriptPath, sha256, output, ShellQuote(url)}
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	slices.Sort(headerNames)
+	for _, name := range headerNames {
+		args = append(args, "-H", ShellQuote(name+": "+headers[name]))
+	}
+	return strings.Join(args, " ")
+}
+
+// ShellQuote quotes s for use as a single argument in a shell command.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,203 @@
+package yaml
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitFile_FileMode(t *testing.T) {
+	type test struct {
+		name    string
+		file    InitFile
+		want    fs.FileMode
+		wantErr string
+	}
+	tests := []test{
+		{name: "default", file: InitFile{Path: "/tmp/file"}, want: 0o644},
+		{name: "private", file: InitFile{Path: "/tmp/file", Permissions: "0600"}, want: 0o600},
+		{name: "executable", file: InitFile{Path: "/tmp/file", Permissions: "755"}, want: 0o755},
+		{name: "err invalid", file: InitFile{Path: "/tmp/file", Permissions: "rw-r--r--"}, wantErr: "invalid permissions \"rw-r--r--\" for file /tmp/file: strconv.ParseUint: parsing \"rw-r--r--\": invalid syntax"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			mode, err := tc.file.FileMode()
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.want, mode)
+			}
+		})
+	}
+}
+
+func TestInitFile_DecodedContent(t *testing.T) {
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, err := gzipWriter.Write([]byte("compressed content"))
+	assert.NoError(t, err)
+	assert.NoError(t, gzipWriter.Close())
+
+	type test struct {
+		name    string
+		file    InitFile
+		want    string
+		wantErr string
+	}
+	tests := []test{
+		{name: "plain", file: InitFile{Path: "/tmp/file", Content: "plain content"}, want: "plain content"},
+		{name: "base64", file: InitFile{Path: "/tmp/file", Content: base64.StdEncoding.EncodeToString([]byte("encoded content")), Encoding: "b64"}, want: "encoded content"},
+		{name: "gzip", file: InitFile{Path: "/tmp/file", Content: compressed.String(), Encoding: "gzip"}, want: "compressed content"},
+		{name: "gzip base64", file: InitFile{Path: "/tmp/file", Content: base64.StdEncoding.EncodeToString(compressed.Bytes()), Encoding: "gz+b64"}, want: "compressed content"},
+		{name: "err invalid base64", file: InitFile{Path: "/tmp/file", Content: "%%%", Encoding: "base64"}, wantErr: "could not decode base64 content of file /tmp/file: illegal base64 data at input byte 0"},
+		{name: "err invalid gzip", file: InitFile{Path: "/tmp/file", Content: "plain", Encoding: "gz"}, wantErr: "could not decompress content of file /tmp/file: unexpected EOF"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			content, err := tc.file.DecodedContent()
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.want, string(content))
+			}
+		})
+	}
+}
+
+func TestInitFile_InstallCmd(t *testing.T) {
+	currentUser, err := user.Current()
+	assert.NoError(t, err)
+	currentGroup, err := user.LookupGroupId(currentUser.Gid)
+	assert.NoError(t, err)
+	owner := currentUser.Username + ":" + currentGroup.Name
+
+	// cloud-init is not installed where the tests run, fake query -f by printing the template unchanged
+	binDir := t.TempDir()
+	err = os.WriteFile(filepath.Join(binDir, "cloud-init"), []byte("#!/bin/sh\nprintf '%s' \"$3\"\n"), 0o755)
+	assert.NoError(t, err)
+	fetchScriptPath := writeFetchScript(t)
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, err = gzipWriter.Write([]byte("compressed content"))
+	assert.NoError(t, err)
+	assert.NoError(t, gzipWriter.Close())
+
+	type test struct {
+		name     string
+		file     InitFile
+		existing string
+		served   string
+		want     string
+		wantMode fs.FileMode
+		wantErr  bool
+	}
+	tests := []test{
+		{name: "plain", file: InitFile{Path: "etc/plain.yaml", Content: "region: {{ ds.meta_data.region }}", Owner: owner}, want: "region: {{ ds.meta_data.region }}", wantMode: 0o644},
+		{name: "private key", file: InitFile{Path: "etc/tls/server.key", Content: "private key", Permissions: "0600", Owner: owner}, want: "private key", wantMode: 0o600},
+		{name: "base64", file: InitFile{Path: "etc/encoded", Content: base64.StdEncoding.EncodeToString([]byte("encoded content")), Encoding: "b64", Permissions: "0640", Owner: owner}, want: "encoded content", wantMode: 0o640},
+		{name: "gzip base64", file: InitFile{Path: "etc/compressed", Content: base64.StdEncoding.EncodeToString(compressed.Bytes()), Encoding: "gz+b64", Permissions: "0755", Owner: owner}, want: "compressed content", wantMode: 0o755},
+		{name: "append", file: InitFile{Path: "etc/hosts", Content: "10.0.0.1 test\n", Append: true, Permissions: "0600", Owner: owner}, existing: "127.0.0.1 localhost\n", want: "127.0.0.1 localhost\n10.0.0.1 test\n", wantMode: 0o600},
+		{name: "err checksum mismatch", file: InitFile{Path: "etc/tampered", Content: "uploaded content", Owner: owner}, served: "tampered content", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			root := t.TempDir()
+			tc.file.Path = filepath.Join(root, tc.file.Path)
+			if tc.existing != "" {
+				assert.NoError(t, os.MkdirAll(filepath.Dir(tc.file.Path), 0o755))
+				assert.NoError(t, os.WriteFile(tc.file.Path, []byte(tc.existing), 0o644))
+			}
+			// the backend serves the content as it was uploaded
+			if tc.served == "" {
+				tc.served = tc.file.Content
+			}
+			source := filepath.Join(root, "source")
+			assert.NoError(t, os.WriteFile(source, []byte(tc.served), 0o644))
+
+			installCmd, err := tc.file.InstallCmd("file://"+source, map[string]string{"Authorization": "Bearer test"})
+			assert.NoError(t, err)
+			cmd := exec.Command("sh", "-c", strings.ReplaceAll(installCmd, FetchScriptPath, fetchScriptPath))
+			cmd.Env = append(os.Environ(), "PATH="+binDir+string(os.PathListSeparator)+os.Getenv("PATH"),
+				"CAPI_BOOTSTRAP_FETCH_ATTEMPTS=2", "CAPI_BOOTSTRAP_FETCH_DELAY=0")
+			output, err := cmd.CombinedOutput()
+			if tc.wantErr {
+				assert.Errorf(t, err, "install command succeeded: %s", output)
+				assert.Contains(t, string(output), "checksum mismatch")
+				assert.NoFileExists(t, tc.file.Path)
+				return
+			}
+			assert.NoErrorf(t, err, "install command failed: %s", output)
+
+			content, err := os.ReadFile(tc.file.Path)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, string(content))
+			info, err := os.Stat(tc.file.Path)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantMode, info.Mode().Perm())
+		})
+	}
+}
+
+func TestInitFile_VerifySourceCmd(t *testing.T) {
+	fetchScriptPath := writeFetchScript(t)
+	type test struct {
+		name     string
+		existing string
+		served   string
+		want     string
+		wantErr  bool
+	}
+	tests := []test{
+		{name: "verified", existing: "content", served: "changed", want: "content"},
+		{name: "fetched again", existing: "truncated", served: "content", want: "content"},
+		{name: "err checksum mismatch", existing: "truncated", served: "tampered", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			root := t.TempDir()
+			source := filepath.Join(root, "source")
+			assert.NoError(t, os.WriteFile(source, []byte(tc.served), 0o644))
+			file := InitFile{Path: filepath.Join(root, "file"), Source: Source{URI: "file://" + source}}
+			assert.NoError(t, os.WriteFile(file.Path, []byte(tc.existing), 0o644))
+
+			verifyCmd := file.VerifySourceCmd(InitFile{Content: "content"}.SHA256())
+			cmd := exec.Command("sh", "-c", strings.ReplaceAll(verifyCmd, FetchScriptPath, fetchScriptPath))
+			cmd.Env = append(os.Environ(), "CAPI_BOOTSTRAP_FETCH_ATTEMPTS=2", "CAPI_BOOTSTRAP_FETCH_DELAY=0")
+			output, err := cmd.CombinedOutput()
+			if tc.wantErr {
+				assert.Errorf(t, err, "verify command succeeded: %s", output)
+				assert.NoFileExists(t, file.Path)
+				return
+			}
+			assert.NoErrorf(t, err, "verify command failed: %s", output)
+			content, err := os.ReadFile(file.Path)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, string(content))
+		})
+	}
+}
+
+// writeFetchScript writes the fetch script to a temporary directory, since FetchScriptPath is not writable in tests.
+func writeFetchScript(t *testing.T) string {
+	t.Helper()
+	fetchScript := FetchScript()
+	fetchScriptPath := filepath.Join(t.TempDir(), "capi-bootstrap-fetch")
+	assert.NoError(t, os.WriteFile(fetchScriptPath, []byte(fetchScript.Content), 0o755))
+	return fetchScriptPath
+}
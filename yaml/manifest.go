@@ -0,0 +1,159 @@
+package yaml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// Manifest is a multi-document yaml manifest decoded into unstructured objects. Documents that aren't changed are
+// written back as they were read, changed documents are marshaled from their object, so fields that aren't known to
+// the typed APIs are kept either way.
+type Manifest struct {
+	documents []*document
+	index     map[schema.GroupVersionKind][]*unstructured.Unstructured
+}
+
+const separator = "---"
+
+type document struct {
+	// index is the position of the document in the content it was parsed from, including empty documents
+	index    int
+	raw      string
+	object   *unstructured.Unstructured
+	original map[string]any
+}
+
+// ParseManifest decodes all documents in content. Documents are separated by "---" lines, which can be followed by a
+// comment, documents without content are dropped.
+func ParseManifest(content string) (*Manifest, error) {
+	manifest := &Manifest{index: map[schema.GroupVersionKind][]*unstructured.Unstructured{}}
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(strings.NewReader(content)))
+	for i := 0; ; i++ {
+		raw, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return manifest, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read document %d: %s", i, err)
+		}
+		rawJSON, err := yaml.YAMLToJSON(raw)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode document %d: %s", i, err)
+		}
+		if bytes.Equal(bytes.TrimSpace(rawJSON), []byte("null")) {
+			continue
+		}
+		object := &unstructured.Unstructured{}
+		if err := object.UnmarshalJSON(rawJSON); err != nil {
+			return nil, fmt.Errorf("could not decode document %d: %s", i, err)
+		}
+		// the reader only drops separators that end a document, so the first document can start with one
+		if bytes.HasPrefix(raw, []byte(separator)) {
+			_, raw, _ = bytes.Cut(raw, []byte("\n"))
+		}
+		manifest.documents = append(manifest.documents, &document{
+			index:    i,
+			raw:      string(raw),
+			object:   object,
+			original: runtime.DeepCopyJSON(object.Object),
+		})
+		gvk := object.GroupVersionKind()
+		manifest.index[gvk] = append(manifest.index[gvk], object)
+	}
+}
+
+// Objects returns all objects in the order of their documents, changes to them are written by Marshal.
+func (m *Manifest) Objects() []*unstructured.Unstructured {
+	if m == nil {
+		return nil
+	}
+	objects := make([]*unstructured.Unstructured, len(m.documents))
+	for i, doc := range m.documents {
+		objects[i] = doc.object
+	}
+	return objects
+}
+
+// List returns the objects of gvk in the order of their documents, an empty version matches all versions of the kind.
+func (m *Manifest) List(gvk schema.GroupVersionKind) []*unstructured.Unstructured {
+	if m == nil {
+		return nil
+	}
+	if gvk.Version != "" {
+		return m.index[gvk]
+	}
+	var objects []*unstructured.Unstructured
+	for _, object := range m.Objects() {
+		if object.GroupVersionKind().GroupKind() == gvk.GroupKind() {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
+
+// Get returns the first object of gvk named name, an empty name matches the first object of gvk. It returns nil if
+// there is no such object.
+func (m *Manifest) Get(gvk schema.GroupVersionKind, name string) *unstructured.Unstructured {
+	for _, object := range m.List(gvk) {
+		if name == "" || object.GetName() == name {
+			return object
+		}
+	}
+	return nil
+}
+
+// Add appends object as a new document, which is always marshaled from the object.
+func (m *Manifest) Add(object *unstructured.Unstructured) {
+	index := 0
+	if len(m.documents) != 0 {
+		index = m.documents[len(m.documents)-1].index + 1
+	}
+	m.documents = append(m.documents, &document{index: index, object: object})
+	gvk := object.GroupVersionKind()
+	m.index[gvk] = append(m.index[gvk], object)
+}
+
+// Marshal returns the manifest with all documents prefixed by a "---" separator.
+func (m *Manifest) Marshal() ([]byte, error) {
+	if m == nil {
+		return nil, nil
+	}
+	var b bytes.Buffer
+	for _, doc := range m.documents {
+		content := []byte(doc.raw)
+		if !reflect.DeepEqual(doc.object.Object, doc.original) {
+			var err error
+			content, err = yaml.Marshal(doc.object.Object)
+			if err != nil {
+				return nil, fmt.Errorf("could not marshal %s %s: %s", doc.object.GetKind(), doc.object.GetName(), err)
+			}
+		}
+		b.WriteString(separator + "\n")
+		b.Write(content)
+		if !bytes.HasSuffix(content, []byte("\n")) {
+			b.WriteString("\n")
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// DecodeObject converts object into the typed API object into.
+func DecodeObject(object *unstructured.Unstructured, into any) error {
+	rawObject, err := json.Marshal(object.Object)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(rawObject, into)
+}
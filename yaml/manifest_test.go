@@ -0,0 +1,208 @@
+package yaml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestParseManifest(t *testing.T) {
+	type test struct {
+		name      string
+		input     string
+		wantKinds []string
+		wantErr   string
+	}
+	tests := []test{
+		{
+			name: "success",
+			input: `--- # the cluster
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+---
+# only a comment
+---
+  apiVersion: v1
+  kind: Secret
+  metadata:
+    name: test-cluster-ca
+  stringData:
+    tls.crt: |
+      -----BEGIN CERTIFICATE-----
+      ---
+      -----END CERTIFICATE-----
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-cluster-config`,
+			wantKinds: []string{"Cluster", "Secret", "ConfigMap"},
+		},
+		{
+			name:  "success empty",
+			input: "",
+		},
+		{
+			name: "err invalid separator",
+			input: `apiVersion: v1
+kind: ConfigMap
+--- name: test`,
+			wantErr: "could not read document 0: invalid Yaml document separator: name: test",
+		},
+		{
+			name: "err invalid yaml",
+			input: `apiVersion: v1
+kind: [ConfigMap`,
+			wantErr: "could not decode document 0: yaml: line 2: did not find expected ',' or ']'",
+		},
+		{
+			name: "err no kind",
+			input: `apiVersion: v1
+metadata:
+  name: test`,
+			wantErr: "could not decode document 0: Object 'Kind' is missing in '{\"apiVersion\":\"v1\",\"metadata\":{\"name\":\"test\"}}'",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			manifest, err := ParseManifest(tc.input)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			var kinds []string
+			for _, object := range manifest.Objects() {
+				kinds = append(kinds, object.GetKind())
+			}
+			assert.Equal(t, tc.wantKinds, kinds)
+		})
+	}
+}
+
+func TestManifest_Get(t *testing.T) {
+	manifest, err := ParseManifest(`---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta1
+kind: KThreesControlPlane
+metadata:
+  name: old
+---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+kind: KThreesControlPlane
+metadata:
+  name: test-cluster-control-plane
+`)
+	require.NoError(t, err)
+	type test struct {
+		name      string
+		gvk       schema.GroupVersionKind
+		objName   string
+		wantFound bool
+		wantName  string
+	}
+	tests := []test{
+		{
+			name:      "success any version",
+			gvk:       schema.GroupVersionKind{Group: "controlplane.cluster.x-k8s.io", Kind: "KThreesControlPlane"},
+			objName:   "test-cluster-control-plane",
+			wantFound: true,
+			wantName:  "test-cluster-control-plane",
+		},
+		{
+			name:      "success first of kind",
+			gvk:       schema.GroupVersionKind{Group: "controlplane.cluster.x-k8s.io", Kind: "KThreesControlPlane"},
+			wantFound: true,
+			wantName:  "old",
+		},
+		{
+			name:      "success version",
+			gvk:       schema.GroupVersionKind{Group: "controlplane.cluster.x-k8s.io", Version: "v1beta2", Kind: "KThreesControlPlane"},
+			wantFound: true,
+			wantName:  "test-cluster-control-plane",
+		},
+		{
+			name:    "not found version",
+			gvk:     schema.GroupVersionKind{Group: "controlplane.cluster.x-k8s.io", Version: "v1beta2", Kind: "KThreesControlPlane"},
+			objName: "old",
+		},
+		{
+			name: "not found group",
+			gvk:  schema.GroupVersionKind{Group: "infrastructure.cluster.x-k8s.io", Kind: "KThreesControlPlane"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			object := manifest.Get(tc.gvk, tc.objName)
+			if !tc.wantFound {
+				assert.Nil(t, object)
+				return
+			}
+			require.NotNil(t, object)
+			assert.Equal(t, tc.wantName, object.GetName())
+		})
+	}
+}
+
+func TestManifest_Marshal(t *testing.T) {
+	input := `--- # the cluster
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster # kept as is
+spec:
+  unknownField: true
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-cluster-config
+data:
+  key: value`
+	manifest, err := ParseManifest(input)
+	require.NoError(t, err)
+	actual, err := manifest.Marshal()
+	require.NoError(t, err)
+	assert.Equal(t, `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster # kept as is
+spec:
+  unknownField: true
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-cluster-config
+data:
+  key: value
+`, string(actual))
+
+	configMap := manifest.Get(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, "test-cluster-config")
+	require.NoError(t, unstructured.SetNestedField(configMap.Object, int64(3), "data", "replicas"))
+	actual, err = manifest.Marshal()
+	require.NoError(t, err)
+	assert.Equal(t, `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster # kept as is
+spec:
+  unknownField: true
+---
+apiVersion: v1
+data:
+  key: value
+  replicas: 3
+kind: ConfigMap
+metadata:
+  name: test-cluster-config
+`, string(actual))
+}
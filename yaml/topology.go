@@ -0,0 +1,196 @@
+package yaml
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	capi "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/exp/topology/desiredstate"
+	"sigs.k8s.io/cluster-api/exp/topology/scope"
+)
+
+var clusterClassGVK = capi.GroupVersion.WithKind("ClusterClass").GroupKind().WithVersion("")
+
+// TopologyControlPlaneName is the name of the control plane and its machine template created for the topology of a
+// cluster. The topology controller names them randomly, but they have to match between the manifests that are parsed
+// for the values and the ones written to the bootstrap machine.
+func TopologyControlPlaneName(clusterName string) string {
+	return clusterName + "-control-plane"
+}
+
+// ResolveTopology adds the infrastructure cluster, the control plane and the control plane machine template of a
+// Cluster with a managed topology to the manifest and points the Cluster's infrastructureRef and controlPlaneRef at
+// them. They are computed by the generator of the topology controller from the ClusterClass and its templates in the
+// manifest, so the controller adopts them once it runs on the cluster. Worker MachineDeployments are left to the
+// controller. Clusters without a topology or with both refs set are not changed.
+func ResolveTopology(ctx context.Context, manifest *Manifest) error {
+	clusterObject := manifest.Get(clusterGVK, "")
+	if clusterObject == nil {
+		return nil
+	}
+	var cluster capi.Cluster
+	if err := DecodeObject(clusterObject, &cluster); err != nil {
+		return fmt.Errorf("could not decode cluster %s: %s", clusterObject.GetName(), err)
+	}
+	if cluster.Spec.Topology == nil || (cluster.Spec.InfrastructureRef != nil && cluster.Spec.ControlPlaneRef != nil) {
+		return nil
+	}
+	blueprint, err := topologyBlueprint(manifest, &cluster)
+	if err != nil {
+		return err
+	}
+
+	// the names are set as current refs, the generator keeps them instead of generating random ones
+	current := cluster.DeepCopy()
+	if current.Spec.InfrastructureRef == nil {
+		current.Spec.InfrastructureRef = &corev1.ObjectReference{Name: cluster.Name}
+	}
+	if current.Spec.ControlPlaneRef == nil {
+		current.Spec.ControlPlaneRef = &corev1.ObjectReference{Name: TopologyControlPlaneName(cluster.Name)}
+	}
+	s := scope.New(current)
+	s.Blueprint = blueprint
+	s.Current.ControlPlane = &scope.ControlPlaneState{}
+	desired, err := desiredstate.NewGenerator(nil, nil, nil).Generate(ctx, s)
+	if err != nil {
+		return fmt.Errorf("could not compute the topology of cluster %s: %s", cluster.Name, err)
+	}
+
+	objects := []*unstructured.Unstructured{desired.InfrastructureCluster, desired.ControlPlane.Object}
+	if template := desired.ControlPlane.InfrastructureMachineTemplate; template != nil {
+		template.SetName(TopologyControlPlaneName(cluster.Name))
+		if err := unstructured.SetNestedField(desired.ControlPlane.Object.Object, template.GetName(), "spec", "machineTemplate", "infrastructureRef", "name"); err != nil {
+			return fmt.Errorf("could not update control plane %s: %s", desired.ControlPlane.Object.GetName(), err)
+		}
+		objects = append(objects, template)
+	}
+	for _, object := range objects {
+		if findObject(manifest, object.GroupVersionKind().GroupKind().WithVersion(""), object.GetName(), object.GetNamespace()) != nil {
+			return fmt.Errorf("%s %s of the topology of cluster %s already exists in the manifest", object.GetKind(), object.GetName(), cluster.Name)
+		}
+		// the uid of the Cluster is only known once it's created, the controller sets the owner when it adopts them
+		object.SetOwnerReferences(nil)
+		manifest.Add(object)
+	}
+	refs := map[string]*corev1.ObjectReference{
+		"infrastructureRef": desired.Cluster.Spec.InfrastructureRef,
+		"controlPlaneRef":   desired.Cluster.Spec.ControlPlaneRef,
+	}
+	for field, ref := range refs {
+		value, err := runtime.DefaultUnstructuredConverter.ToUnstructured(ref)
+		if err != nil {
+			return fmt.Errorf("could not convert %s of cluster %s: %s", field, cluster.Name, err)
+		}
+		if err := unstructured.SetNestedMap(clusterObject.Object, value, "spec", field); err != nil {
+			return fmt.Errorf("could not update cluster %s: %s", cluster.Name, err)
+		}
+	}
+	return nil
+}
+
+// topologyBlueprint collects the ClusterClass of cluster and its templates from the manifest. The variables of the
+// topology are defaulted like the Cluster webhook does.
+func topologyBlueprint(manifest *Manifest, cluster *capi.Cluster) (*scope.ClusterBlueprint, error) {
+	classKey := cluster.GetClassKey()
+	classObject := findObject(manifest, clusterClassGVK, classKey.Name, classKey.Namespace)
+	if classObject == nil {
+		return nil, fmt.Errorf("ClusterClass %s referenced by cluster %s not found in the manifest", classKey.Name, cluster.Name)
+	}
+	class := &capi.ClusterClass{}
+	if err := DecodeObject(classObject, class); err != nil {
+		return nil, fmt.Errorf("could not decode ClusterClass %s: %s", classKey.Name, err)
+	}
+	for _, patch := range class.Spec.Patches {
+		if patch.External != nil {
+			return nil, fmt.Errorf("external patch %s of ClusterClass %s is not supported", patch.Name, class.Name)
+		}
+	}
+	// the status is set by the ClusterClass controller, inline patches only see variables defined inline
+	class.Status.Variables = nil
+	for _, variable := range class.Spec.Variables {
+		class.Status.Variables = append(class.Status.Variables, capi.ClusterClassStatusVariable{
+			Name: variable.Name,
+			Definitions: []capi.ClusterClassStatusVariableDefinition{{
+				From:     capi.VariableDefinitionFromInline,
+				Required: variable.Required,
+				Metadata: variable.Metadata,
+				Schema:   variable.Schema,
+			}},
+		})
+	}
+
+	topology := cluster.Spec.Topology.DeepCopy()
+	topology.Workers = nil
+	for _, variable := range class.Spec.Variables {
+		if slices.ContainsFunc(topology.Variables, func(v capi.ClusterVariable) bool { return v.Name == variable.Name }) {
+			continue
+		}
+		if variable.Schema.OpenAPIV3Schema.Default != nil {
+			topology.Variables = append(topology.Variables, capi.ClusterVariable{Name: variable.Name, Value: *variable.Schema.OpenAPIV3Schema.Default})
+		} else if variable.Required {
+			return nil, fmt.Errorf("variable %s required by ClusterClass %s is not set on cluster %s", variable.Name, class.Name, cluster.Name)
+		}
+	}
+
+	blueprint := &scope.ClusterBlueprint{
+		Topology:     topology,
+		ClusterClass: class,
+		ControlPlane: &scope.ControlPlaneBlueprint{},
+	}
+	var err error
+	blueprint.InfrastructureClusterTemplate, err = classTemplate(manifest, class, class.Spec.Infrastructure.Ref)
+	if err != nil {
+		return nil, err
+	}
+	blueprint.ControlPlane.Template, err = classTemplate(manifest, class, class.Spec.ControlPlane.Ref)
+	if err != nil {
+		return nil, err
+	}
+	if machineInfrastructure := class.Spec.ControlPlane.MachineInfrastructure; machineInfrastructure != nil {
+		blueprint.ControlPlane.InfrastructureMachineTemplate, err = classTemplate(manifest, class, machineInfrastructure.Ref)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blueprint, nil
+}
+
+// classTemplate returns the template referenced by the ClusterClass, the ref defaults to the namespace of the class.
+func classTemplate(manifest *Manifest, class *capi.ClusterClass, ref *corev1.ObjectReference) (*unstructured.Unstructured, error) {
+	if ref == nil {
+		return nil, fmt.Errorf("ClusterClass %s has a template without a ref", class.Name)
+	}
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = class.Namespace
+	}
+	gvk := schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind)
+	template := findObject(manifest, gvk.GroupKind().WithVersion(""), ref.Name, namespace)
+	if template == nil {
+		return nil, fmt.Errorf("%s %s referenced by ClusterClass %s not found in the manifest", ref.Kind, ref.Name, class.Name)
+	}
+	return template.DeepCopy(), nil
+}
+
+// findObject returns the object of gvk named name in namespace, objects without a namespace are in the default one.
+func findObject(manifest *Manifest, gvk schema.GroupVersionKind, name, namespace string) *unstructured.Unstructured {
+	for _, object := range manifest.List(gvk) {
+		if object.GetName() == name && namespaceOrDefault(object.GetNamespace()) == namespaceOrDefault(namespace) {
+			return object
+		}
+	}
+	return nil
+}
+
+func namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return metav1.NamespaceDefault
+	}
+	return namespace
+}
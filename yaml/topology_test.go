@@ -0,0 +1,303 @@
+package yaml
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var clusterClassManifest = []string{`---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: ClusterClass
+metadata:
+  name: linode-k3s
+spec:
+  controlPlane:
+    ref:
+      apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+      kind: KThreesControlPlaneTemplate
+      name: linode-k3s-control-plane
+    machineInfrastructure:
+      ref:
+        apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+        kind: LinodeMachineTemplate
+        name: linode-k3s-control-plane
+  infrastructure:
+    ref:
+      apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+      kind: LinodeClusterTemplate
+      name: linode-k3s
+  variables:
+  - name: region
+    required: true
+    schema:
+      openAPIV3Schema:
+        type: string
+        default: us-ord
+  patches:
+  - name: region
+    definitions:
+    - selector:
+        apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+        kind: LinodeClusterTemplate
+        matchResources:
+          infrastructureCluster: true
+      jsonPatches:
+      - op: add
+        path: /spec/template/spec/region
+        valueFrom:
+          variable: region
+    - selector:
+        apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+        kind: LinodeMachineTemplate
+        matchResources:
+          controlPlane: true
+      jsonPatches:
+      - op: add
+        path: /spec/template/spec/region
+        valueFrom:
+          variable: region
+      - op: add
+        path: /spec/template/spec/tags
+        valueFrom:
+          template: '["{{ .builtin.cluster.name }}"]'`,
+	`---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeClusterTemplate
+metadata:
+  name: linode-k3s
+spec:
+  template:
+    spec: {}`,
+	`---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+kind: KThreesControlPlaneTemplate
+metadata:
+  name: linode-k3s-control-plane
+spec:
+  template:
+    spec:
+      kthreesConfigSpec:
+        preK3sCommands:
+        - echo "{{ ds.meta_data.label }}"`,
+	`---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeMachineTemplate
+metadata:
+  name: linode-k3s-control-plane
+spec:
+  template:
+    spec:
+      type: g6-standard-2`,
+}
+
+var topologyClusterManifest = `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+spec:
+  topology:
+    class: linode-k3s
+    version: v1.29.1+k3s2
+    controlPlane:
+      replicas: 1
+    workers:
+      machineDeployments:
+      - class: default-worker
+        name: md-0
+        replicas: 1`
+
+func TestResolveTopology(t *testing.T) {
+	type test struct {
+		name    string
+		input   []string
+		want    string
+		wantErr string
+	}
+	tests := []test{
+		{
+			name:  "success",
+			input: append([]string{topologyClusterManifest}, clusterClassManifest...),
+			want: `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+spec:
+  controlPlaneRef:
+    apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+    kind: KThreesControlPlane
+    name: test-cluster-control-plane
+  infrastructureRef:
+    apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+    kind: LinodeCluster
+    name: test-cluster
+  topology:
+    class: linode-k3s
+    controlPlane:
+      replicas: 1
+    version: v1.29.1+k3s2
+    workers:
+      machineDeployments:
+      - class: default-worker
+        name: md-0
+        replicas: 1
+` + strings.Join(clusterClassManifest, "\n") + `
+---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeCluster
+metadata:
+  annotations:
+    cluster.x-k8s.io/cloned-from-groupkind: LinodeClusterTemplate.infrastructure.cluster.x-k8s.io
+    cluster.x-k8s.io/cloned-from-name: linode-k3s
+  labels:
+    cluster.x-k8s.io/cluster-name: test-cluster
+    topology.cluster.x-k8s.io/owned: ""
+  name: test-cluster
+spec:
+  region: us-ord
+---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+kind: KThreesControlPlane
+metadata:
+  annotations:
+    cluster.x-k8s.io/cloned-from-groupkind: KThreesControlPlaneTemplate.controlplane.cluster.x-k8s.io
+    cluster.x-k8s.io/cloned-from-name: linode-k3s-control-plane
+  labels:
+    cluster.x-k8s.io/cluster-name: test-cluster
+    topology.cluster.x-k8s.io/owned: ""
+  name: test-cluster-control-plane
+spec:
+  kthreesConfigSpec:
+    preK3sCommands:
+    - echo "{{ ds.meta_data.label }}"
+  machineTemplate:
+    infrastructureRef:
+      apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+      kind: LinodeMachineTemplate
+      name: test-cluster-control-plane
+      namespace: ""
+    metadata:
+      labels:
+        cluster.x-k8s.io/cluster-name: test-cluster
+        topology.cluster.x-k8s.io/owned: ""
+  replicas: 1
+  version: v1.29.1+k3s2
+---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeMachineTemplate
+metadata:
+  annotations:
+    cluster.x-k8s.io/cloned-from-groupkind: LinodeMachineTemplate.infrastructure.cluster.x-k8s.io
+    cluster.x-k8s.io/cloned-from-name: linode-k3s-control-plane
+  labels:
+    cluster.x-k8s.io/cluster-name: test-cluster
+    topology.cluster.x-k8s.io/owned: ""
+  name: test-cluster-control-plane
+spec:
+  template:
+    spec:
+      region: us-ord
+      tags:
+      - test-cluster
+      type: g6-standard-2
+`,
+		},
+		{
+			name: "success refs set",
+			input: []string{`---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+spec:
+  controlPlaneRef:
+    apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+    kind: KThreesControlPlane
+    name: test-cluster-control-plane
+  infrastructureRef:
+    apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+    kind: LinodeCluster
+    name: test-cluster
+  topology:
+    class: linode-k3s
+    version: v1.29.1+k3s2`},
+			want: `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+spec:
+  controlPlaneRef:
+    apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+    kind: KThreesControlPlane
+    name: test-cluster-control-plane
+  infrastructureRef:
+    apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+    kind: LinodeCluster
+    name: test-cluster
+  topology:
+    class: linode-k3s
+    version: v1.29.1+k3s2
+`,
+		},
+		{
+			name:  "success no cluster",
+			input: clusterClassManifest[1:],
+			want:  strings.Join(clusterClassManifest[1:], "\n") + "\n",
+		},
+		{
+			name:    "err cluster class not found",
+			input:   append([]string{topologyClusterManifest}, clusterClassManifest[1:]...),
+			wantErr: "ClusterClass linode-k3s referenced by cluster test-cluster not found in the manifest",
+		},
+		{
+			name:    "err template not found",
+			input:   []string{topologyClusterManifest, clusterClassManifest[0], clusterClassManifest[1], clusterClassManifest[2]},
+			wantErr: "LinodeMachineTemplate linode-k3s-control-plane referenced by ClusterClass linode-k3s not found in the manifest",
+		},
+		{
+			name: "err required variable",
+			input: append([]string{topologyClusterManifest, strings.Replace(clusterClassManifest[0], `
+        default: us-ord`, "", 1)}, clusterClassManifest[1:]...),
+			wantErr: "variable region required by ClusterClass linode-k3s is not set on cluster test-cluster",
+		},
+		{
+			name: "err external patch",
+			input: append([]string{topologyClusterManifest, clusterClassManifest[0] + `
+  - name: external
+    external:
+      generateExtension: generate-patches.test-extension`}, clusterClassManifest[1:]...),
+			wantErr: "external patch external of ClusterClass linode-k3s is not supported",
+		},
+		{
+			name: "err object exists",
+			input: append([]string{topologyClusterManifest, `---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeCluster
+metadata:
+  name: test-cluster`}, clusterClassManifest...),
+			wantErr: "LinodeCluster test-cluster of the topology of cluster test-cluster already exists in the manifest",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			manifest, err := ParseManifest(strings.Join(tc.input, "\n"))
+			require.NoError(t, err)
+			err = ResolveTopology(context.Background(), manifest)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			actual, err := manifest.Marshal()
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, string(actual))
+		})
+	}
+}
@@ -9,6 +9,8 @@ type InitFile struct {
 	Encoding    string `yaml:"encoding,omitempty"`
 	Append      bool   `yaml:"append,omitempty"`
 	Defer       bool   `yaml:"defer,omitempty"`
+	// Jinja marks files that use cloud-init instance data and have to be rendered with jinja on the machine
+	Jinja bool `yaml:"-"`
 }
 
 type Source struct {
@@ -19,6 +21,17 @@ type Source struct {
 type Config struct {
 	WriteFiles []InitFile `yaml:"write_files"`
 	RunCmd     []string   `yaml:"runcmd"`
+	// Parts are shipped next to the cloud-config in multi-part MIME user data
+	Parts []Part `yaml:"-"`
+	// TarFiles are the files bundled into the tarball in WriteFiles, they are kept so the files can be rendered locally
+	TarFiles []InitFile `yaml:"-"`
+}
+
+// Part is a part of multi-part MIME user data.
+type Part struct {
+	Filename    string
+	ContentType string
+	Content     string
 }
 
 type ParsedManifest struct {
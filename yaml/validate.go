@@ -0,0 +1,122 @@
+package yaml
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	capi "sigs.k8s.io/cluster-api/api/v1beta1"
+	kjson "sigs.k8s.io/json"
+)
+
+// Reference is a field of a kind that references another object in the manifest.
+type Reference struct {
+	// From is the kind of the objects with the reference
+	From schema.GroupKind
+	// Path is the path of the reference in the objects
+	Path []string
+	// To is the kind of the referenced object if the reference has no apiVersion and kind
+	To schema.GroupKind
+	// Required references have to be set
+	Required bool
+}
+
+// ClusterReferences are the references of the CAPI kinds.
+var ClusterReferences = []Reference{
+	{From: clusterGVK.GroupKind(), Path: []string{"spec", "infrastructureRef"}, Required: true},
+	{From: clusterGVK.GroupKind(), Path: []string{"spec", "controlPlaneRef"}, Required: true},
+	{From: capi.GroupVersion.WithKind("MachineDeployment").GroupKind(), Path: []string{"spec", "template", "spec", "infrastructureRef"}, Required: true},
+	{From: capi.GroupVersion.WithKind("MachineDeployment").GroupKind(), Path: []string{"spec", "template", "spec", "bootstrap", "configRef"}},
+}
+
+// ValidateManifest strictly decodes the objects of the manifest that have a version registered in scheme and checks
+// that their references resolve to objects in the manifest. Objects of versions that aren't registered are only checked
+// for references. All errors are returned, they name the index of the document and the field. The paths of references
+// are known fields, even if the registered version of their kind predates them.
+func ValidateManifest(manifest *Manifest, scheme *runtime.Scheme, references []Reference) error {
+	var errs []error
+	for _, doc := range manifest.documents {
+		object := doc.object
+		prefix := fmt.Sprintf("document %d (%s %s)", doc.index, object.GetKind(), object.GetName())
+		var objectReferences []Reference
+		var referenceFields []string
+		for _, reference := range references {
+			if object.GroupVersionKind().GroupKind() == reference.From {
+				objectReferences = append(objectReferences, reference)
+				referenceFields = append(referenceFields, strings.Join(reference.Path, "."))
+			}
+		}
+		if err := decodeStrict(object, scheme, referenceFields); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", prefix, err))
+		}
+		for _, reference := range objectReferences {
+			if err := checkReference(manifest, object, reference); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %s", prefix, strings.Join(reference.Path, "."), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// decodeStrict decodes object into its type registered in scheme, unknown and duplicate fields are errors. Unknown
+// fields at the paths in knownFields are ignored.
+func decodeStrict(object *unstructured.Unstructured, scheme *runtime.Scheme, knownFields []string) error {
+	if !scheme.Recognizes(object.GroupVersionKind()) {
+		return nil
+	}
+	into, err := scheme.New(object.GroupVersionKind())
+	if err != nil {
+		return err
+	}
+	rawObject, err := object.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	strictErrs, err := kjson.UnmarshalStrict(rawObject, into)
+	if err != nil {
+		return err
+	}
+	strictErrs = slices.DeleteFunc(strictErrs, func(strictErr error) bool {
+		return slices.ContainsFunc(knownFields, func(field string) bool {
+			return strictErr.Error() == fmt.Sprintf("unknown field %q", field)
+		})
+	})
+	return errors.Join(strictErrs...)
+}
+
+// checkReference returns an error if the reference of object doesn't resolve to an object in the manifest. References
+// default to the namespace of object.
+func checkReference(manifest *Manifest, object *unstructured.Unstructured, reference Reference) error {
+	ref, found, err := unstructured.NestedMap(object.Object, reference.Path...)
+	if err != nil {
+		return err
+	}
+	if !found {
+		if reference.Required {
+			return errors.New("required reference is not set")
+		}
+		return nil
+	}
+	name, _, _ := unstructured.NestedString(ref, "name")
+	namespace, _, _ := unstructured.NestedString(ref, "namespace")
+	apiVersion, _, _ := unstructured.NestedString(ref, "apiVersion")
+	kind, _, _ := unstructured.NestedString(ref, "kind")
+	groupKind := reference.To
+	if kind != "" {
+		groupKind = schema.FromAPIVersionAndKind(apiVersion, kind).GroupKind()
+	}
+	if name == "" || groupKind.Kind == "" {
+		return errors.New("reference needs a name and a kind")
+	}
+	if namespace == "" {
+		namespace = object.GetNamespace()
+	}
+	if findObject(manifest, groupKind.WithVersion(""), name, namespace) == nil {
+		return fmt.Errorf("%s %s not found in the manifest", groupKind.Kind, name)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package yaml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	capi "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+func TestValidateManifest(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, capi.AddToScheme(scheme))
+	references := append(ClusterReferences, Reference{
+		From: schema.GroupKind{Group: "infrastructure.cluster.x-k8s.io", Kind: "LinodeCluster"},
+		Path: []string{"spec", "vpcRef"},
+		To:   schema.GroupKind{Group: "infrastructure.cluster.x-k8s.io", Kind: "LinodeVPC"},
+	}, Reference{
+		From: schema.GroupKind{Group: "cluster.x-k8s.io", Kind: "Cluster"},
+		Path: []string{"spec", "vpcRef"},
+		To:   schema.GroupKind{Group: "infrastructure.cluster.x-k8s.io", Kind: "LinodeVPC"},
+	})
+	cluster := `---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+spec:
+  controlPlaneRef:
+    apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+    kind: KThreesControlPlane
+    name: test-cluster-control-plane
+  infrastructureRef:
+    apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+    kind: LinodeCluster
+    name: test-cluster`
+	controlPlane := `---
+apiVersion: controlplane.cluster.x-k8s.io/v1beta2
+kind: KThreesControlPlane
+metadata:
+  name: test-cluster-control-plane
+spec:
+  unknownField: true`
+	linodeCluster := `---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeCluster
+metadata:
+  name: test-cluster
+spec:
+  vpcRef:
+    name: test-cluster`
+	vpc := `---
+apiVersion: infrastructure.cluster.x-k8s.io/v1alpha2
+kind: LinodeVPC
+metadata:
+  name: test-cluster`
+	type test struct {
+		name    string
+		input   []string
+		wantErr string
+	}
+	tests := []test{
+		{
+			name:  "success",
+			input: []string{cluster, controlPlane, linodeCluster, vpc},
+		},
+		{
+			name:  "err other namespace",
+			input: []string{strings.Replace(cluster, "name: test-cluster\n", "name: test-cluster\n  namespace: capl\n", 1), controlPlane, linodeCluster, vpc},
+			wantErr: "document 0 (Cluster test-cluster): spec.infrastructureRef: LinodeCluster test-cluster not found in the manifest\n" +
+				"document 0 (Cluster test-cluster): spec.controlPlaneRef: KThreesControlPlane test-cluster-control-plane not found in the manifest",
+		},
+		{
+			name: "success reference unknown to the scheme",
+			input: []string{cluster + `
+  vpcRef:
+    name: test-cluster`, controlPlane, linodeCluster, vpc},
+		},
+		{
+			name: "err field type",
+			input: []string{cluster + `
+  paused: "true"
+  unknownField: true`, controlPlane, linodeCluster, vpc},
+			wantErr: "document 0 (Cluster test-cluster): json: cannot unmarshal string into Go struct field ClusterSpec.spec.paused of type bool",
+		},
+		{
+			name: "err unknown field",
+			input: []string{cluster + `
+  unknownField: true`, controlPlane, linodeCluster, vpc},
+			wantErr: "document 0 (Cluster test-cluster): unknown field \"spec.unknownField\"",
+		},
+		{
+			name:    "err references",
+			input:   []string{cluster, "---\n# only a comment", linodeCluster},
+			wantErr: "document 0 (Cluster test-cluster): spec.controlPlaneRef: KThreesControlPlane test-cluster-control-plane not found in the manifest\ndocument 2 (LinodeCluster test-cluster): spec.vpcRef: LinodeVPC test-cluster not found in the manifest",
+		},
+		{
+			name: "err required reference",
+			input: []string{`---
+apiVersion: cluster.x-k8s.io/v1beta1
+kind: Cluster
+metadata:
+  name: test-cluster
+spec:
+  controlPlaneRef:
+    name: test-cluster-control-plane`},
+			wantErr: "document 0 (Cluster test-cluster): spec.infrastructureRef: required reference is not set\ndocument 0 (Cluster test-cluster): spec.controlPlaneRef: reference needs a name and a kind",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			manifest, err := ParseManifest(strings.Join(tc.input, "\n"))
+			require.NoError(t, err)
+			err = ValidateManifest(manifest, scheme, references)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}